@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"zombie_locator/internal/config"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/repository/history"
+	"zombie_locator/internal/repository/zombie"
+	"zombie_locator/internal/service/observer"
+	"zombie_locator/internal/service/replay"
+	"zombie_locator/internal/storage/broker"
+	"zombie_locator/internal/storage/db"
+	"zombie_locator/internal/utils/shema_registry"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	modeHandler   = "handler"
+	modeRepublish = "republish"
+
+	locationsDLQ = "locations"
+	capturedDLQ  = "captured"
+)
+
+// Connections and topics are taken from the zombie-tracker configuration, see config.Load, only the replay
+// settings are the flags of the tool.
+var (
+	dlq           = flag.String("dlq", locationsDLQ, "dead letter queue to read: locations or captured")
+	sourceTopic   = flag.String("source-topic", "", "replay only messages failed in this topic")
+	errorContains = flag.String("error-contains", "", "replay only messages which error contains this substring")
+	from          = flag.String("from", "", "replay only messages dead-lettered after this time, RFC3339")
+	to            = flag.String("to", "", "replay only messages dead-lettered before this time, RFC3339")
+	dryRun        = flag.Bool("dry-run", true, "only print the summary of matched messages")
+	mode          = flag.String("mode", modeHandler, "handler - process messages with the observer handlers, republish - write messages back to the source topic")
+)
+
+func main() {
+	cfg, _, err := config.LoadFlagSet(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Fatalf("unable to load config: %s", err)
+	}
+	appLog, err := logger.NewAppLogger()
+	if err != nil {
+		log.Fatalf("unable to create logger: %s", err)
+	}
+	filter, err := parseFilter()
+	if err != nil {
+		appLog.Fatal("invalid filter", err)
+	}
+	topic, err := dlqTopic(cfg.Kafka.Topics)
+	if err != nil {
+		appLog.Fatal("invalid dead letter queue", err)
+	}
+
+	var handlers map[string]replay.Handler
+	if !*dryRun {
+		var closeHandlers func()
+		handlers, closeHandlers, err = initHandlers(appLog, cfg)
+		if err != nil {
+			appLog.Fatal("unable to init replay handlers", err)
+		}
+		defer closeHandlers()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	partitions, err := openPartitions(ctx, cfg.Kafka.Brokers, topic, filter.From)
+	if err != nil {
+		appLog.Fatal("unable to open dead letter queue", err)
+	}
+	defer func() {
+		for _, p := range partitions {
+			if err := p.Reader.Close(); err != nil {
+				appLog.Error("unable to close dead letter queue reader", err)
+			}
+		}
+	}()
+
+	summary, err := replay.NewReplayService(appLog, partitions, handlers).Run(ctx, filter, *dryRun)
+	if err != nil {
+		appLog.Error("replay interrupted", err)
+	}
+
+	out, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		appLog.Fatal("unable to print summary", err)
+	}
+	fmt.Fprintln(os.Stdout, string(out))
+}
+
+func parseFilter() (replay.Filter, error) {
+	filter := replay.Filter{
+		SourceTopic:   *sourceTopic,
+		ErrorContains: *errorContains,
+	}
+	var err error
+	if *from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			return filter, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if *to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			return filter, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+// dlqTopic returns the configured dead letter queue topic chosen by the dlq flag.
+func dlqTopic(topics config.Topics) (string, error) {
+	switch *dlq {
+	case locationsDLQ:
+		return topics.LocationsDLQ, nil
+	case capturedDLQ:
+		return topics.CapturedDLQ, nil
+	}
+	return "", fmt.Errorf("unsupported dead letter queue %q, expected %s or %s", *dlq, locationsDLQ, capturedDLQ)
+}
+
+// openPartitions reads each partition of the dead letter queue up to its current high-water mark, so the replay
+// ends once the messages dead-lettered before the start are read. Partitions are read from the first message
+// written after from, the whole partition when from is zero. Consumer group is not used, nothing is committed.
+func openPartitions(ctx context.Context, brokers []string, topic string, from time.Time) ([]replay.Partition, error) {
+	conn, err := dialAny(ctx, brokers)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	topicPartitions, err := conn.ReadPartitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get partitions of %s: %w", topic, err)
+	}
+
+	partitions := make([]replay.Partition, 0, len(topicPartitions))
+	for _, tp := range topicPartitions {
+		p, err := openPartition(ctx, conn.RemoteAddr().String(), brokers, topic, tp.ID, from)
+		if err != nil {
+			for _, p := range partitions {
+				_ = p.Reader.Close()
+			}
+			return nil, err
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+// dialAny connects to the first reachable broker.
+func dialAny(ctx context.Context, brokers []string) (*kafka.Conn, error) {
+	var err error
+	for _, b := range brokers {
+		var conn *kafka.Conn
+		if conn, err = kafka.DialContext(ctx, "tcp", b); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("kafka is not reachable: %w", err)
+}
+
+func openPartition(ctx context.Context, addr string, brokers []string, topic string, partition int, from time.Time) (replay.Partition, error) {
+	start, end, err := partitionOffsets(ctx, addr, topic, partition, from)
+	if err != nil {
+		return replay.Partition{}, err
+	}
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   brokers,
+		Topic:     topic,
+		Partition: partition,
+	})
+	if err = reader.SetOffset(start); err != nil {
+		_ = reader.Close()
+		return replay.Partition{}, fmt.Errorf("unable to seek %s/%d to %d: %w", topic, partition, start, err)
+	}
+	return replay.Partition{ID: partition, Reader: reader, Start: start, End: end}, nil
+}
+
+// partitionOffsets returns the offset of the first message of the partition written after from and the high-water mark.
+func partitionOffsets(ctx context.Context, addr, topic string, partition int, from time.Time) (int64, int64, error) {
+	conn, err := kafka.DialLeader(ctx, "tcp", addr, topic, partition)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to connect to the leader of %s/%d: %w", topic, partition, err)
+	}
+	defer conn.Close()
+	start, end, err := conn.ReadOffsets()
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to read offsets of %s/%d: %w", topic, partition, err)
+	}
+	if from.IsZero() {
+		return start, end, nil
+	}
+	// messages are dead-lettered after they fail, so the ones failed before from are written before it too
+	at, err := conn.ReadOffset(from)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to find offset of %s/%d at %s: %w", topic, partition, from, err)
+	}
+	switch {
+	case at < 0:
+		// nothing is written after from
+		start = end
+	case at > start:
+		start = at
+	}
+	return start, end, nil
+}
+
+// initHandlers returns handlers keyed by the source topic and the function releasing their connections.
+func initHandlers(appLog logger.AppLogger, cfg config.Config) (map[string]replay.Handler, func(), error) {
+	topics := cfg.Kafka.Topics
+	switch *mode {
+	case modeHandler:
+		dbConnect, err := db.NewPostgresConnection(cfg.Postgres)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to connect to database: %w", err)
+		}
+		var (
+			zRepo        zombie.Zombier
+			closeStorage = func() {}
+		)
+		switch cfg.Storage {
+		case config.PostgisStorage:
+			zRepo = zombie.NewPostgisRepository(dbConnect)
+		case config.Tile38Storage:
+			tile38Client, err := db.NewTile38Connection(cfg.Tile38)
+			if err != nil {
+				return nil, nil, fmt.Errorf("tile38 database is not reachable: %w", err)
+			}
+			zRepo = zombie.NewZombieRepository(dbConnect, tile38Client, nil)
+			closeStorage = func() {
+				if err := tile38Client.Close(); err != nil {
+					appLog.Error("unable to close tile38 connection", err)
+				}
+			}
+		default:
+			return nil, nil, fmt.Errorf("unsupported storage: %s", cfg.Storage)
+		}
+		// consumers are not needed, messages are passed to the handlers directly
+		zombieObserver := observer.NewObserver(appLog, zRepo, history.NewHistoryRepository(dbConnect), shema_registry.NewRegistry([]int{1}), nil, nil, false, nil)
+		handlers := map[string]replay.Handler{
+			topics.Locations: replay.ConsumerHandler(zombieObserver.ZombieLocationUpdate),
+			topics.Captured:  replay.ConsumerHandler(zombieObserver.ZombieCapturedUpdate),
+		}
+		closeHandlers := func() {
+			closeStorage()
+			if err = dbConnect.Client().Close(); err != nil {
+				appLog.Error("unable to close database connection", err)
+			}
+		}
+		return handlers, closeHandlers, nil
+	case modeRepublish:
+		locations := broker.NewKafkaProducer(appLog, cfg.Kafka.Producer(topics.Locations))
+		captured := broker.NewKafkaProducer(appLog, cfg.Kafka.Producer(topics.Captured))
+		handlers := map[string]replay.Handler{
+			topics.Locations: replay.PublishHandler(locations.Publish),
+			topics.Captured:  replay.PublishHandler(captured.Publish),
+		}
+		closeHandlers := func() {
+			for _, p := range []*broker.KafkaProducer{locations, captured} {
+				if err := p.Shutdown(); err != nil {
+					appLog.Error("unable to close producer", err)
+				}
+			}
+		}
+		return handlers, closeHandlers, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported mode: %s", *mode)
+}
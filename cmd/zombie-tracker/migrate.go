@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+	"zombie_locator/internal/config"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/storage/db"
+	"zombie_locator/internal/storage/migrations"
+
+	"go.uber.org/zap"
+)
+
+// migrate runs the migrate command: up applies the pending migrations, down reverts the given number
+// of the latest ones, one by default, status prints the state of every migration.
+func migrate(ctx context.Context, appLog logger.AppLogger, cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s up|down [steps]|status", migrateCommand)
+	}
+	dbConnect, err := db.NewPostgresConnection(cfg.Postgres)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer func() {
+		if err := dbConnect.Client().Close(); err != nil {
+			appLog.Error("unable to close database connection", err)
+		}
+	}()
+	migrators, err := newMigrators(appLog, dbConnect, cfg)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrateUp(ctx, migrators)
+		if err != nil {
+			return err
+		}
+		appLog.Info("database is migrated", zap.Int("applied", applied))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("steps must be a positive number: %s", args[1])
+			}
+		}
+		// the storage migrations are applied on top of the common ones, so they are reverted first
+		reverted := 0
+		for i := len(migrators) - 1; i >= 0 && reverted < steps; i-- {
+			n, err := migrators[i].Down(ctx, steps-reverted)
+			reverted += n
+			if err != nil {
+				return err
+			}
+		}
+		appLog.Info("migrations are reverted", zap.Int("reverted", reverted))
+	case "status":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TABLE\tVERSION\tNAME\tAPPLIED AT")
+		for _, migrator := range migrators {
+			status, err := migrator.Status(ctx)
+			if err != nil {
+				return err
+			}
+			for _, s := range status {
+				appliedAt := "pending"
+				if s.AppliedAt != nil {
+					appliedAt = s.AppliedAt.Format(time.RFC3339)
+				}
+				name := s.Name
+				if s.Unknown {
+					name = "(unknown to this version)"
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", migrator.Table(), s.Version, name, appliedAt)
+			}
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+	return nil
+}
+
+// newMigrators returns the migrators of the schema used by the configured storage in the order they are applied.
+// The postgis storage migrations need the postgis extension, so they are not applied with the other storages.
+func newMigrators(appLog logger.AppLogger, dbConnect *db.Connect, cfg config.Config) ([]*migrations.Migrator, error) {
+	migrator, err := migrations.NewMigrator(appLog, dbConnect, migrations.Config{})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Storage != config.PostgisStorage {
+		return []*migrations.Migrator{migrator}, nil
+	}
+	postgisMigrations, err := migrations.EmbeddedPostgis()
+	if err != nil {
+		return nil, err
+	}
+	postgisMigrator, err := migrations.NewMigrator(appLog, dbConnect, migrations.Config{
+		Table:      migrations.PostgisTable,
+		Migrations: postgisMigrations,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []*migrations.Migrator{migrator, postgisMigrator}, nil
+}
+
+// migrateUp applies the pending migrations of each migrator and returns the number of applied ones.
+func migrateUp(ctx context.Context, migrators []*migrations.Migrator) (int, error) {
+	applied := 0
+	for _, migrator := range migrators {
+		n, err := migrator.Up(ctx)
+		applied += n
+		if err != nil {
+			return applied, err
+		}
+	}
+	return applied, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"zombie_locator/internal/config"
+	"zombie_locator/internal/storage/broker"
+	"zombie_locator/internal/utils/health"
+)
+
+// newReadiness sets up the checks the readiness of the process is judged by. Kafka is checked only when
+// the process consumes, then the freshness of the stored data is judged by the consumer lag as well.
+func newReadiness(cfg config.Config, zStorage storage, consume bool, clientID string) *health.Checker {
+	checker := health.NewChecker(health.Config{Timeout: cfg.Health.CheckTimeout})
+	checker.Add(zStorage.checks...)
+	if !consume {
+		checker.Add(health.Freshness("freshness", cfg.Health.MaxStaleness, zStorage.zombies.LastUpdate, nil))
+		return checker
+	}
+
+	topics := []string{cfg.Kafka.Topics.Locations, cfg.Kafka.Topics.Captured}
+	inspectors := make([]*broker.GroupInspector, 0, len(topics))
+	for _, topic := range topics {
+		inspectors = append(inspectors, broker.NewGroupInspector(broker.InspectorConfig{
+			Brokers:  cfg.Kafka.Brokers,
+			GroupID:  cfg.Kafka.GroupID,
+			Topic:    topic,
+			ClientID: clientID,
+			Timeout:  cfg.Health.CheckTimeout,
+		}))
+	}
+	lags := func(ctx context.Context) (map[string]int64, error) {
+		res := make(map[string]int64, len(topics))
+		for i, inspector := range inspectors {
+			lag, err := inspector.Lag(ctx)
+			if err != nil {
+				return nil, err
+			}
+			res[topics[i]] = lag
+		}
+		return res, nil
+	}
+	checker.Add(
+		health.Ping("kafka", inspectors[0].Ping),
+		health.Ping("kafka_group", func(ctx context.Context) error {
+			for _, inspector := range inspectors {
+				if err := inspector.Member(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+		health.Check{Name: "kafka_lag", Run: func(ctx context.Context) (interface{}, error) {
+			res, err := lags(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return res, nil
+		}},
+		health.Freshness("freshness", cfg.Health.MaxStaleness, zStorage.zombies.LastUpdate, func(ctx context.Context) (int64, error) {
+			res, err := lags(ctx)
+			var total int64
+			for _, lag := range res {
+				total += lag
+			}
+			return total, err
+		}),
+	)
+	return checker
+}
+
+// kafkaClientID tells the consumers of this process apart from the other members of the group.
+func kafkaClientID(cfg config.Config) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%s-%d", cfg.Kafka.GroupID, host, os.Getpid())
+}
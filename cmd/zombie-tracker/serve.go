@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/signal"
+	"syscall"
+	"time"
+	"zombie_locator/internal/config"
+	"zombie_locator/internal/http"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/metrics"
+	"zombie_locator/internal/repository/history"
+	"zombie_locator/internal/repository/zombie"
+	"zombie_locator/internal/service/locator"
+	"zombie_locator/internal/service/observer"
+	"zombie_locator/internal/storage/broker"
+	"zombie_locator/internal/tracing"
+	"zombie_locator/internal/utils/circuit"
+	"zombie_locator/internal/utils/lifecycle"
+	"zombie_locator/internal/utils/shema_registry"
+)
+
+const tracingShutdownTimeout = 5 * time.Second
+
+// serve runs the service in the given mode until the termination signal or the failure of a component.
+// The api mode opens no kafka connection, the consume mode does not listen for http requests.
+func serve(ctx context.Context, appLog logger.AppLogger, cfg config.Config, mode string) error {
+	if mode != allCommand && !cfg.UsesPostgres() {
+		return fmt.Errorf("%s storage is not shared between processes, it runs in the %s mode only", cfg.Storage, allCommand)
+	}
+	serveAPI := mode != consumeCommand
+	consume := mode != serveAPICommand
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing, "zombie-tracker")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// buffered spans are exported on shutdown, the unreachable collector must not hold the process
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			appLog.Error("unable to export buffered trace spans", err)
+		}
+	}()
+
+	appMetrics := metrics.New()
+	zStorage, err := openStorage(ctx, appLog, cfg, consume, appMetrics.Storage)
+	if err != nil {
+		return err
+	}
+	defer zStorage.close()
+
+	// storage breaker pauses consumers while postgres or tile38 is down, the api reports its state
+	storageBreaker := circuit.NewBreaker(appLog, "storage", circuit.Config{}, zStorage.zombies.Ping)
+
+	clientID := kafkaClientID(cfg)
+	// components are started in this order and stopped in the reverse one, so the api stops first
+	manager := lifecycle.NewManager(appLog, lifecycle.Config{ShutdownTimeout: cfg.Lifecycle.ShutdownTimeout})
+	manager.Add(lifecycle.Component{
+		Name: "storage_breaker",
+		Run: func(ctx context.Context) error {
+			storageBreaker.Run(ctx)
+			return nil
+		},
+	})
+	manager.Add(zStorage.components...)
+	if consume {
+		appLog.Info("init observer service")
+		zombieObserver := newObserver(appLog, cfg, zStorage, storageBreaker, clientID, appMetrics)
+		manager.Add(zombieObserver.Components(cfg.Lifecycle.ConsumerRestart())...)
+	}
+	if serveAPI {
+		appLog.Info("init http service")
+		appHTTPServer := http.NewServer(appLog, cfg.HTTP, locator.NewLocatorService(appLog, zStorage.zombies, zStorage.history),
+			storageBreaker, newReadiness(cfg, zStorage, consume, clientID), appMetrics)
+		manager.Add(appHTTPServer.Component(lifecycle.RestartPolicy{}))
+	}
+
+	// the failed component stops the process, the termination signal does it gracefully
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	appLog.Info("starting services")
+	return manager.Run(ctx)
+}
+
+// newObserver sets up the consumers of zombie events along with their dead letter queues.
+// clientID tells the consumers apart from the other group members.
+func newObserver(appLog logger.AppLogger, cfg config.Config, zStorage storage, storageBreaker *circuit.Breaker, clientID string,
+	appMetrics *metrics.Metrics) *observer.Observer {
+	// history is appended to postgres before the zombie is stored, so it is guarded by the same breaker
+	guardedRepo := zombie.NewBreakerRepository(zStorage.zombies, storageBreaker)
+	guardedHistory := history.NewBreakerHistory(zStorage.history, storageBreaker)
+
+	// dead-letter queue producers init here
+	locationDLQProducer := broker.NewKafkaProducer(appLog, cfg.Kafka.Producer(cfg.Kafka.Topics.LocationsDLQ))
+	statusDLQProducer := broker.NewKafkaProducer(appLog, cfg.Kafka.Producer(cfg.Kafka.Topics.CapturedDLQ))
+
+	// consumers for different type of events
+	locationConsumerConfig := cfg.Kafka.Consumer(cfg.Kafka.Topics.Locations)
+	locationConsumerConfig.Pauser = storageBreaker
+	locationConsumerConfig.ClientID = clientID
+	locationConsumerConfig.Metrics = appMetrics.Consumer
+	locationConsumerConfig.VersionHeader = shema_registry.HeaderSchemaVersion
+	locationConsumer := broker.NewKafkaConsumer(appLog, locationDLQProducer, locationConsumerConfig)
+	statusConsumerConfig := cfg.Kafka.Consumer(cfg.Kafka.Topics.Captured)
+	statusConsumerConfig.Pauser = storageBreaker
+	statusConsumerConfig.ClientID = clientID
+	statusConsumerConfig.Metrics = appMetrics.Consumer
+	statusConsumerConfig.VersionHeader = shema_registry.HeaderSchemaVersion
+	zombieStatusConsumer := broker.NewKafkaConsumer(appLog, statusDLQProducer, statusConsumerConfig)
+
+	registry := shema_registry.NewRegistry([]int{1})
+	return observer.NewObserver(appLog, guardedRepo, guardedHistory, registry, locationConsumer, zombieStatusConsumer, cfg.Kafka.BatchLocations,
+		appMetrics.Observer)
+}
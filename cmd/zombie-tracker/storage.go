@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"zombie_locator/internal/config"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/metrics"
+	"zombie_locator/internal/repository/history"
+	"zombie_locator/internal/repository/zombie"
+	"zombie_locator/internal/service/consistency"
+	"zombie_locator/internal/storage/db"
+	"zombie_locator/internal/utils/health"
+	"zombie_locator/internal/utils/lifecycle"
+
+	"github.com/xjem/t38c"
+	"go.uber.org/zap"
+)
+
+// zombieStorage keeps zombies and reports whether it is reachable.
+type zombieStorage interface {
+	zombie.Zombier
+	Ping(ctx context.Context) error
+	// LastUpdate returns the time of the newest stored event.
+	LastUpdate(ctx context.Context) (time.Time, error)
+}
+
+// storage is the configured zombie storage and the location history kept along with it.
+type storage struct {
+	zombies zombieStorage
+	history history.Historian
+	// checks report the readiness of the storage backends.
+	checks []health.Check
+	// components keep the storage consistent, they are run by the lifecycle manager before the storage is closed.
+	components []lifecycle.Component
+	// close releases the connections.
+	close func()
+}
+
+// openStorage sets up the configured storage. The writer, which consumes events, keeps tile38 consistent
+// with postgres: it loads the hunting list at startup and relays the outbox, the reader only searches.
+func openStorage(ctx context.Context, appLog logger.AppLogger, cfg config.Config, writer bool, storageMetrics *metrics.Storage) (storage, error) {
+	if !cfg.UsesPostgres() {
+		appLog.Info("zombies are kept in memory, they are lost on restart")
+		return storage{
+			zombies: zombie.NewMemoryRepository(),
+			history: history.NewMemoryHistory(),
+			close:   func() {},
+		}, nil
+	}
+
+	dbConnect, err := openPostgres(ctx, appLog, cfg)
+	if err != nil {
+		return storage{}, err
+	}
+	closePostgres := func() {
+		if err := dbConnect.Client().Close(); err != nil {
+			appLog.Error("unable to close database connection", err)
+		}
+	}
+	postgresCheck := health.Ping("postgres", func(ctx context.Context) error {
+		return dbConnect.Client().PingContext(ctx)
+	})
+	if cfg.Storage == config.PostgisStorage {
+		return storage{
+			zombies: zombie.NewPostgisRepository(dbConnect),
+			history: history.NewHistoryRepository(dbConnect),
+			checks:  []health.Check{postgresCheck},
+			close:   closePostgres,
+		}, nil
+	}
+
+	tile38Client, err := db.NewTile38Connection(cfg.Tile38)
+	if err != nil {
+		closePostgres()
+		return storage{}, fmt.Errorf("tile38 database is not reachable: %w", err)
+	}
+	zRepo := zombie.NewZombieRepository(dbConnect, tile38Client, storageMetrics)
+	closeStorage := func() {
+		closeTile38(appLog, tile38Client)
+		closePostgres()
+	}
+	var components []lifecycle.Component
+	if writer {
+		// the hunting list is loaded before the http server and consumers are started,
+		// so the api never serves the partial list after tile38 data loss.
+		if err = rehydrate(ctx, appLog, zRepo); err != nil {
+			closeStorage()
+			return storage{}, err
+		}
+		// tile38 writes failed after postgres commit are relayed from the outbox, drift is repaired periodically
+		components = append(components,
+			consistency.NewConsistencyService(appLog, zRepo, consistency.Config{}).Component(lifecycle.RestartPolicy{MaxRestarts: -1}))
+	}
+	return storage{
+		zombies: zRepo,
+		history: history.NewHistoryRepository(dbConnect),
+		checks: []health.Check{
+			postgresCheck,
+			health.Ping("tile38", func(ctx context.Context) error {
+				return tile38Client.Ping()
+			}),
+			// the api serves the search from tile38, it is not ready until the writer has loaded the hunting list
+			{Name: "rehydration", Run: func(ctx context.Context) (interface{}, error) {
+				rehydratedAt, err := zRepo.Rehydrated(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return map[string]time.Time{"rehydrated_at": rehydratedAt}, nil
+			}},
+		},
+		components: components,
+		close:      closeStorage,
+	}, nil
+}
+
+// rebuildIndex reloads the hunting list from postgres to tile38, drops zombies which should not be hunted and exits.
+// The postgis storage rebuilds its geography column from the zombie points instead.
+func rebuildIndex(ctx context.Context, appLog logger.AppLogger, cfg config.Config) error {
+	if !cfg.UsesPostgres() {
+		appLog.Info(fmt.Sprintf("%s storage has no index to rebuild", cfg.Storage))
+		return nil
+	}
+	dbConnect, err := openPostgres(ctx, appLog, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := dbConnect.Client().Close(); err != nil {
+			appLog.Error("unable to close database connection", err)
+		}
+	}()
+	if cfg.Storage == config.PostgisStorage {
+		rebuilt, err := zombie.NewPostgisRepository(dbConnect).RebuildLocations(ctx)
+		if err != nil {
+			return err
+		}
+		appLog.Info("zombie locations are rebuilt", zap.Int("rebuilt", rebuilt))
+		return nil
+	}
+	tile38Client, err := db.NewTile38Connection(cfg.Tile38)
+	if err != nil {
+		return fmt.Errorf("tile38 database is not reachable: %w", err)
+	}
+	defer closeTile38(appLog, tile38Client)
+
+	zRepo := zombie.NewZombieRepository(dbConnect, tile38Client, nil)
+	if err = rehydrate(ctx, appLog, zRepo); err != nil {
+		return err
+	}
+	drifted, err := zRepo.Reconcile(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to reconcile tile38: %w", err)
+	}
+	if _, err = zRepo.RelayOutbox(ctx); err != nil {
+		return fmt.Errorf("unable to relay outbox: %w", err)
+	}
+	appLog.Info("tile38 index is rebuilt", zap.Int("drifted", drifted))
+	return nil
+}
+
+func rehydrate(ctx context.Context, appLog logger.AppLogger, zRepo *zombie.Zombie) error {
+	appLog.Info("rehydrating tile38 from postgres")
+	loaded, err := zRepo.Rehydrate(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to rehydrate tile38: %w", err)
+	}
+	appLog.Info("tile38 is rehydrated", zap.Int("zombies", loaded))
+	return nil
+}
+
+// openPostgres connects to postgres and applies pending migrations, unless it is disabled.
+func openPostgres(ctx context.Context, appLog logger.AppLogger, cfg config.Config) (*db.Connect, error) {
+	dbConnect, err := db.NewPostgresConnection(cfg.Postgres)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	if !cfg.MigrateOnStart {
+		return dbConnect, nil
+	}
+	var applied int
+	migrators, err := newMigrators(appLog, dbConnect, cfg)
+	if err == nil {
+		applied, err = migrateUp(ctx, migrators)
+	}
+	if err != nil {
+		if cErr := dbConnect.Client().Close(); cErr != nil {
+			appLog.Error("unable to close database connection", cErr)
+		}
+		return nil, fmt.Errorf("unable to migrate database: %w", err)
+	}
+	appLog.Info("database is migrated", zap.Int("applied", applied))
+	return dbConnect, nil
+}
+
+func closeTile38(appLog logger.AppLogger, client *t38c.Client) {
+	if err := client.Close(); err != nil {
+		appLog.Error("unable to close tile38 connection", err)
+	}
+}
@@ -0,0 +1,256 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// envPrefix starts the environment variables of the settings, e.g. ZOMBIE_TRACKER_KAFKA_GROUP_ID.
+	envPrefix = "ZOMBIE_TRACKER_"
+	// configFlag is the path of the YAML file, it can be given by the ZOMBIE_TRACKER_CONFIG variable as well.
+	configFlag = "config"
+)
+
+var InvalidConfig = errors.New("invalid config")
+
+// LookupEnv returns the value of the environment variable, os.LookupEnv is used by the service.
+type LookupEnv func(key string) (string, bool)
+
+// Load builds the configuration from the defaults, the YAML file, the environment and the command line
+// arguments, each one overrides the previous. It returns the arguments left after the flags.
+// The configuration is validated, flag.ErrHelp is returned when the usage is requested.
+func Load(name string, args []string, lookupEnv LookupEnv) (Config, []string, error) {
+	return LoadFlagSet(flag.NewFlagSet(name, flag.ContinueOnError), args, lookupEnv)
+}
+
+// LoadFlagSet is Load which defines the configuration flags in flagSet, so tools can parse their own flags
+// along with them.
+func LoadFlagSet(flagSet *flag.FlagSet, args []string, lookupEnv LookupEnv) (Config, []string, error) {
+	cfg := Default()
+	fields := cfg.fields()
+
+	// flags are applied last, so their values are kept aside until then
+	configPath := flagSet.String(configFlag, "", "path of the YAML config file, "+envPrefix+"CONFIG variable is used when not set")
+	given := make(map[string]string)
+	for _, f := range fields {
+		flagSet.Var(&pendingValue{field: f, given: given}, f.flagName(), f.usage+", "+f.envName()+" variable")
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if *configPath == "" {
+		*configPath, _ = lookupEnv(envPrefix + "CONFIG")
+	}
+	if *configPath != "" {
+		if err := loadFile(&cfg, *configPath); err != nil {
+			return cfg, nil, err
+		}
+	}
+	for _, f := range fields {
+		if value, ok := lookupEnv(f.envName()); ok {
+			if err := f.value.Set(value); err != nil {
+				return cfg, nil, fmt.Errorf("%w: %s: %v", InvalidConfig, f.envName(), err)
+			}
+		}
+	}
+	for _, f := range fields {
+		if value, ok := given[f.key]; ok {
+			if err := f.value.Set(value); err != nil {
+				return cfg, nil, fmt.Errorf("%w: -%s: %v", InvalidConfig, f.flagName(), err)
+			}
+		}
+	}
+	return cfg, flagSet.Args(), cfg.Validate()
+}
+
+// loadFile overrides the configuration with the settings of the YAML file, unknown settings are rejected.
+func loadFile(cfg *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %s: %v", InvalidConfig, path, err)
+	}
+	return nil
+}
+
+// field is the setting which can be given by the environment variable and the flag.
+type field struct {
+	// key is the path of the setting in the YAML file.
+	key   string
+	usage string
+	value flag.Value
+}
+
+func (c *Config) fields() []field {
+	return []field{
+		{key: "storage", usage: "zombie storage: tile38 - postgres with tile38 search index, postgis - postgres only, memory - single node without persistence", value: (*stringValue)(&c.Storage)},
+		{key: "migrate_on_start", usage: "apply pending schema migrations at startup", value: (*boolValue)(&c.MigrateOnStart)},
+		{key: "http.addr", usage: "http server host:port", value: (*stringValue)(&c.HTTP.Addr)},
+		{key: "postgres.url", usage: "postgres connection URL", value: (*stringValue)(&c.Postgres.URL)},
+		{key: "tile38.addr", usage: "tile38 host:port", value: (*stringValue)(&c.Tile38.Addr)},
+		{key: "kafka.brokers", usage: "comma separated kafka brokers host:port", value: (*listValue)(&c.Kafka.Brokers)},
+		{key: "kafka.group_id", usage: "kafka consumer group", value: (*stringValue)(&c.Kafka.GroupID)},
+		{key: "kafka.workers", usage: "number of zombies updated concurrently by a consumer", value: (*intValue)(&c.Kafka.Workers)},
+		{key: "kafka.batch_locations", usage: "store location events in batches", value: (*boolValue)(&c.Kafka.BatchLocations)},
+		{key: "kafka.drain_timeout", usage: "time the in-flight messages have to be handled on shutdown, e.g. 10s", value: (*durationValue)(&c.Kafka.DrainTimeout)},
+		{key: "kafka.topics.locations", usage: "zombie locations topic", value: (*stringValue)(&c.Kafka.Topics.Locations)},
+		{key: "kafka.topics.captured", usage: "captured zombies topic", value: (*stringValue)(&c.Kafka.Topics.Captured)},
+		{key: "kafka.topics.locations_dlq", usage: "dead letter queue topic of zombie locations", value: (*stringValue)(&c.Kafka.Topics.LocationsDLQ)},
+		{key: "kafka.topics.captured_dlq", usage: "dead letter queue topic of captured zombies", value: (*stringValue)(&c.Kafka.Topics.CapturedDLQ)},
+		{key: "lifecycle.shutdown_timeout", usage: "graceful shutdown timeout, e.g. 30s", value: (*durationValue)(&c.Lifecycle.ShutdownTimeout)},
+		{key: "lifecycle.consumer_restarts", usage: "restarts in a row of the failed consumer before the process stops, negative restarts forever", value: (*intValue)(&c.Lifecycle.ConsumerRestarts)},
+		{key: "health.check_timeout", usage: "timeout of each readiness check, e.g. 2s", value: (*durationValue)(&c.Health.CheckTimeout)},
+		{key: "health.max_staleness", usage: "age of the newest stored event after which the data is stale while consumers lag behind, e.g. 5m", value: (*durationValue)(&c.Health.MaxStaleness)},
+		{key: "tracing.exporter", usage: "where the trace spans go: none, stdout or otlp", value: (*stringValue)(&c.Tracing.Exporter)},
+		{key: "tracing.endpoint", usage: "OTLP gRPC collector host:port", value: (*stringValue)(&c.Tracing.Endpoint)},
+		{key: "tracing.insecure", usage: "connect to the OTLP collector without TLS", value: (*boolValue)(&c.Tracing.Insecure)},
+		{key: "tracing.sample_ratio", usage: "share of the traces started by the service which are recorded, from 0 to 1", value: (*floatValue)(&c.Tracing.SampleRatio)},
+	}
+}
+
+// envName is the key in upper case with underscores, e.g. ZOMBIE_TRACKER_HTTP_ADDR.
+func (f field) envName() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(f.key, ".", "_"))
+}
+
+// flagName is the key with dashes, e.g. http-addr.
+func (f field) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(f.key)
+}
+
+// pendingValue keeps the flag value until the file and the environment are applied.
+type pendingValue struct {
+	field field
+	given map[string]string
+}
+
+func (p *pendingValue) String() string {
+	if p == nil || p.field.value == nil {
+		return ""
+	}
+	return p.field.value.String()
+}
+
+func (p *pendingValue) Set(value string) error {
+	// the value is checked now, so the wrong flag is reported along with the usage
+	if err := scratch(p.field.value).Set(value); err != nil {
+		return err
+	}
+	p.given[p.field.key] = value
+	return nil
+}
+
+func (p *pendingValue) IsBoolFlag() bool {
+	_, ok := p.field.value.(*boolValue)
+	return ok
+}
+
+// scratch returns the empty value of the same kind, so the flag is checked without changing the configuration.
+func scratch(v flag.Value) flag.Value {
+	switch v.(type) {
+	case *boolValue:
+		return new(boolValue)
+	case *intValue:
+		return new(intValue)
+	case *floatValue:
+		return new(floatValue)
+	case *listValue:
+		return new(listValue)
+	case *durationValue:
+		return new(durationValue)
+	default:
+		return new(stringValue)
+	}
+}
+
+type stringValue string
+
+func (s *stringValue) Set(value string) error {
+	*s = stringValue(value)
+	return nil
+}
+
+func (s *stringValue) String() string { return string(*s) }
+
+type boolValue bool
+
+func (b *boolValue) Set(value string) error {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", value)
+	}
+	*b = boolValue(v)
+	return nil
+}
+
+func (b *boolValue) String() string { return strconv.FormatBool(bool(*b)) }
+
+type intValue int
+
+func (i *intValue) Set(value string) error {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", value)
+	}
+	*i = intValue(v)
+	return nil
+}
+
+func (i *intValue) String() string { return strconv.Itoa(int(*i)) }
+
+type floatValue float64
+
+func (f *floatValue) Set(value string) error {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", value)
+	}
+	*f = floatValue(v)
+	return nil
+}
+
+func (f *floatValue) String() string { return strconv.FormatFloat(float64(*f), 'g', -1, 64) }
+
+type durationValue time.Duration
+
+func (d *durationValue) Set(value string) error {
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration", value)
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+func (d *durationValue) String() string { return time.Duration(*d).String() }
+
+// listValue is the comma separated list.
+type listValue []string
+
+func (l *listValue) Set(value string) error {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*l = list
+	return nil
+}
+
+func (l *listValue) String() string { return strings.Join(*l, ",") }
@@ -0,0 +1,26 @@
+package http
+
+import (
+	"zombie_locator/internal/utils/health"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// livenessHandler reports that the process serves requests, the dependencies are not checked,
+// so their outage does not get the process restarted.
+func (s *Server) livenessHandler(ctx *fiber.Ctx) error {
+	return ctx.JSON(health.Report{Status: health.Up, Checks: map[string]health.Result{}})
+}
+
+// readinessHandler reports the state of each dependency, it responds 503 while any of them is down,
+// so the traffic is sent to the other instances.
+func (s *Server) readinessHandler(ctx *fiber.Ctx) error {
+	report := health.Report{Status: health.Up, Checks: map[string]health.Result{}}
+	if s.readiness != nil {
+		report = s.readiness.Check(ctx.UserContext())
+	}
+	if report.Status != health.Up {
+		ctx.Status(fiber.StatusServiceUnavailable)
+	}
+	return ctx.JSON(report)
+}
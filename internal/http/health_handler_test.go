@@ -0,0 +1,67 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"zombie_locator/internal/service/locator"
+	"zombie_locator/internal/utils/health"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_HealthHandlers(t *testing.T) {
+	var tile38Err atomic.Value
+	tile38Err.Store("")
+	checker := health.NewChecker(health.Config{})
+	checker.Add(
+		health.Ping("postgres", func(ctx context.Context) error { return nil }),
+		health.Ping("tile38", func(ctx context.Context) error {
+			if msg := tile38Err.Load().(string); msg != "" {
+				return errors.New(msg)
+			}
+			return nil
+		}),
+	)
+	httpAddr := startServerWithReadiness(t, locator.NewMockLocator(gomock.NewController(t)), checker)
+
+	report := getReport(t, httpAddr, "/readyz", http.StatusOK)
+	require.Equal(t, health.Up, report.Status)
+	require.Len(t, report.Checks, 2)
+	require.Equal(t, health.Up, report.Checks["tile38"].Status)
+
+	tile38Err.Store("connection refused")
+	report = getReport(t, httpAddr, "/readyz", http.StatusServiceUnavailable)
+	require.Equal(t, health.Down, report.Status)
+	require.Equal(t, health.Up, report.Checks["postgres"].Status)
+	require.Equal(t, health.Down, report.Checks["tile38"].Status)
+	require.Equal(t, "connection refused", report.Checks["tile38"].Error)
+
+	// dependencies do not affect the liveness
+	report = getReport(t, httpAddr, "/healthz", http.StatusOK)
+	require.Equal(t, health.Up, report.Status)
+	require.Empty(t, report.Checks)
+}
+
+func TestServer_ReadyWithoutChecks(t *testing.T) {
+	httpAddr := startServer(t, locator.NewMockLocator(gomock.NewController(t)))
+	report := getReport(t, httpAddr, "/readyz", http.StatusOK)
+	require.Equal(t, health.Up, report.Status)
+}
+
+func getReport(t *testing.T, host, path string, expectedStatus int) health.Report {
+	resp, err := http.Get(fmt.Sprintf("http://%s%s", host, path))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+	require.Equal(t, expectedStatus, resp.StatusCode)
+	var report health.Report
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	return report
+}
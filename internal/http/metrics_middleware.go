@@ -0,0 +1,59 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// unmatchedRoute labels the requests which match no route, so random paths do not blow up the label values.
+const unmatchedRoute = "unmatched"
+
+// metricsMiddleware records the requests by the route pattern rather than by the path, e.g. /zombies/:id/track.
+func (s *Server) metricsMiddleware(ctx *fiber.Ctx) error {
+	startedAt := time.Now()
+	middleware := ctx.Route()
+	err := ctx.Next()
+
+	route := matchedRoute(ctx, middleware)
+	// fiber method is backed by the request buffer, which is reused once the request is served
+	method := utils.CopyString(ctx.Method())
+	s.metrics.HTTP.Requests.WithLabelValues(method, route, strconv.Itoa(responseStatus(ctx, err))).Inc()
+	s.metrics.HTTP.Duration.WithLabelValues(method, route).Observe(time.Since(startedAt).Seconds())
+	return err
+}
+
+// matchedRoute returns the pattern of the route which served the request, unmatchedRoute when only
+// the middleware route did.
+func matchedRoute(ctx *fiber.Ctx, middleware *fiber.Route) string {
+	if ctx.Route() == middleware {
+		return unmatchedRoute
+	}
+	return ctx.Route().Path
+}
+
+// responseStatus returns the status of the response, the error is turned into the response by the error handler later.
+func responseStatus(ctx *fiber.Ctx, err error) int {
+	if err == nil {
+		return ctx.Response().StatusCode()
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// adaptor serves the net/http handler by fiber.
+func adaptor(h http.Handler) fiber.Handler {
+	handler := fasthttpadaptor.NewFastHTTPHandler(h)
+	return func(ctx *fiber.Ctx) error {
+		handler(ctx.Context())
+		return nil
+	}
+}
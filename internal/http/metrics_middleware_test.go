@@ -0,0 +1,35 @@
+package http_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"zombie_locator/internal/service/locator"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	httpAddr := startServer(t, locator.NewMockLocator(gomock.NewController(t)))
+	for _, path := range []string{"/ping", "/ping", "/no/such/path", "/zombies/not-uuid/track"} {
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", httpAddr, path))
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", httpAddr))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `zombie_tracker_http_requests_total{method="GET",route="/ping",status="200"} 2`)
+	require.Contains(t, string(body), `zombie_tracker_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	// requests are labeled by the route pattern, not by the path
+	require.Contains(t, string(body), `zombie_tracker_http_requests_total{method="GET",route="/zombies/:id/track",status="400"} 1`)
+	require.Contains(t, string(body), "go_goroutines")
+}
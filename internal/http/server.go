@@ -1,8 +1,16 @@
 package http
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
 	"zombie_locator/internal/logger"
+	"zombie_locator/internal/metrics"
 	"zombie_locator/internal/service/locator"
+	"zombie_locator/internal/utils/circuit"
+	"zombie_locator/internal/utils/health"
+	"zombie_locator/internal/utils/lifecycle"
 
 	"go.uber.org/zap"
 
@@ -10,27 +18,60 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/recover"
 )
 
+// BreakerStatus reports the state of the storage circuit breaker.
+type BreakerStatus interface {
+	Status() circuit.Status
+}
+
+// Readiness reports whether the dependencies of the service are ready to serve the traffic.
+type Readiness interface {
+	Check(ctx context.Context) health.Report
+}
+
+// Config is the http server configuration.
+type Config struct {
+	// Addr is the host:port the server listens on.
+	Addr string `yaml:"addr"`
+}
+
 // Server implements a HTTP server and a router for the zombies locations endpoint.
 type Server struct {
-	log      logger.AppLogger
-	service  locator.Locator
-	appAddr  string
-	fiberApp *fiber.App
+	log            logger.AppLogger
+	service        locator.Locator
+	storageBreaker BreakerStatus
+	readiness      Readiness
+	metrics        *metrics.Metrics
+	appAddr        string
+	fiberApp       *fiber.App
+
+	mu sync.Mutex
+	// listener is bound by Start and released when Run returns.
+	listener net.Listener
 }
 
-// NewServer sets up a new Server using the provided listener address and HTTP handler for zombie locations.
-func NewServer(log logger.AppLogger, address string, service locator.Locator) *Server {
+// NewServer sets up a new Server using the provided listener configuration and HTTP handler for zombie locations.
+// readiness is optional, without it the server is ready as soon as it runs.
+// appMetrics are served on /metrics, the server records its requests there; the own registry is used when nil.
+func NewServer(log logger.AppLogger, cfg Config, service locator.Locator, storageBreaker BreakerStatus, readiness Readiness,
+	appMetrics *metrics.Metrics) *Server {
+	if appMetrics == nil {
+		appMetrics = metrics.New()
+	}
 	app := &Server{
 		log:     log.With(zap.String("service", "http")),
-		appAddr: address,
+		appAddr: cfg.Addr,
 		fiberApp: fiber.New(
 			fiber.Config{
 				DisableStartupMessage: true,
 			},
 		),
-		service: service,
+		service:        service,
+		storageBreaker: storageBreaker,
+		readiness:      readiness,
+		metrics:        appMetrics,
 	}
-	app.fiberApp.Use(recover.New())
+	// tracing and metrics wrap recover, so the recovered panics are recorded as 500
+	app.fiberApp.Use(app.tracingMiddleware, app.metricsMiddleware, recover.New())
 	app.initRoutes()
 	return app
 }
@@ -40,12 +81,81 @@ func (s *Server) initRoutes() {
 		return ctx.SendString("pong")
 	})
 	s.fiberApp.Get("/zombies", s.zombieLocationsHandler)
+	s.fiberApp.Get("/zombies/:id/track", s.zombieTrackHandler)
+	s.fiberApp.Get("/status", s.statusHandler)
+	s.fiberApp.Get("/healthz", s.livenessHandler)
+	s.fiberApp.Get("/readyz", s.readinessHandler)
+	s.fiberApp.Get("/metrics", adaptor(s.metrics.Handler()))
+}
+
+// statusHandler reports the state of the storage, so "storage down" can be told apart from "bad data".
+func (s *Server) statusHandler(ctx *fiber.Ctx) error {
+	if s.storageBreaker == nil {
+		return fiber.ErrNotFound
+	}
+	return ctx.JSON(fiber.Map{
+		"storage": s.storageBreaker.Status(),
+	})
 }
 
-// Run starts the HTTP Server.
-func (s *Server) Run() error {
+// Component returns the server to run by the lifecycle manager.
+func (s *Server) Component(restart lifecycle.RestartPolicy) lifecycle.Component {
+	return lifecycle.Component{
+		Name:    "http",
+		Start:   s.Start,
+		Run:     s.Run,
+		Restart: restart,
+	}
+}
+
+// Start binds the address, so the busy port is reported before the other components run.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", s.appAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen %s: %w", s.appAddr, err)
+	}
+	s.listener = listener
+	return nil
+}
+
+// Run serves the requests until ctx is done, then it waits for the in-flight requests to finish.
+// The address is bound by Run if it was not by Start.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.Start(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	listener := s.listener
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.listener = nil
+		s.mu.Unlock()
+	}()
+
 	s.log.Info("Starting HTTP server on port", zap.String("port", s.appAddr))
-	return s.fiberApp.Listen(s.appAddr)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.fiberApp.Listener(listener)
+	}()
+	select {
+	case err := <-served:
+		_ = listener.Close()
+		if err == nil {
+			return nil
+		}
+		return fmt.Errorf("failed to serve http: %w", err)
+	case <-ctx.Done():
+	}
+	if err := s.Shutdown(); err != nil {
+		return err
+	}
+	return <-served
 }
 
 // Shutdown gracefully shuts down the HTTP Server.
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("zombie_locator/internal/http")
+
+// tracingMiddleware continues the trace of the caller, the handlers pass the span on by ctx.UserContext().
+func (s *Server) tracingMiddleware(ctx *fiber.Ctx) error {
+	spanCtx := otel.GetTextMapPropagator().Extract(ctx.UserContext(), requestHeaderCarrier{ctx: ctx})
+	// fiber strings are backed by the request buffer, which is reused before the span is exported
+	method := utils.CopyString(ctx.Method())
+	spanCtx, span := tracer.Start(spanCtx, method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(method),
+			semconv.HTTPTargetKey.String(utils.CopyString(ctx.OriginalURL()))))
+	defer span.End()
+	ctx.SetUserContext(spanCtx)
+
+	middleware := ctx.Route()
+	err := ctx.Next()
+
+	route := matchedRoute(ctx, middleware)
+	// the route is known only after the routing, so the span is renamed by the route pattern
+	span.SetName(method + " " + route)
+	span.SetAttributes(semconv.HTTPRouteKey.String(route))
+	status := responseStatus(ctx, err)
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+	if err != nil {
+		span.RecordError(err)
+	}
+	if c, msg := semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer); c == codes.Error {
+		span.SetStatus(c, msg)
+	}
+	return err
+}
+
+// requestHeaderCarrier reads the trace context from the request headers.
+type requestHeaderCarrier struct {
+	ctx *fiber.Ctx
+}
+
+func (c requestHeaderCarrier) Get(key string) string {
+	// the value is kept by the trace state, so it must outlive the request buffer
+	return utils.CopyString(c.ctx.Get(key))
+}
+
+// Set is not used, the trace context is not sent back in the response.
+func (c requestHeaderCarrier) Set(key, value string) {}
+
+func (c requestHeaderCarrier) Keys() []string {
+	var keys []string
+	c.ctx.Request().Header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
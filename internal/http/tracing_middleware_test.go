@@ -0,0 +1,59 @@
+package http_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/repository/history"
+	"zombie_locator/internal/repository/zombie"
+	"zombie_locator/internal/service/locator"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestServer_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	const (
+		callerTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+		callerSpan  = "00f067aa0ba902b7"
+	)
+
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+	httpAddr := startServer(t, locator.NewLocatorService(appLog, zombie.NewMemoryRepository(), history.NewMemoryHistory()))
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/zombies?lat=1&lon=2", httpAddr), nil)
+	require.NoError(t, err)
+	req.Header.Set("traceparent", fmt.Sprintf("00-%s-%s-01", callerTrace, callerSpan))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	require.Eventually(t, func() bool {
+		for _, span := range recorder.Ended() {
+			spans[span.Name()] = span
+		}
+		return len(spans) == 2
+	}, time.Second, time.Millisecond)
+	// server span continues the trace of the caller and is named by the route pattern
+	server := spans["GET /zombies"]
+	require.NotNil(t, server)
+	require.Equal(t, trace.SpanKindServer, server.SpanKind())
+	require.Equal(t, callerTrace, server.SpanContext().TraceID().String())
+	require.Equal(t, callerSpan, server.Parent().SpanID().String())
+	// the service span is passed on by the user context of the request
+	service := spans["Locator.Locate"]
+	require.NotNil(t, service)
+	require.Equal(t, server.SpanContext().SpanID(), service.Parent().SpanID())
+}
@@ -2,18 +2,30 @@ package http
 
 import (
 	"errors"
+	"math"
 
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
 )
 
+const (
+	// defaultZombiesLimit is used when the client does not provide the limit.
+	defaultZombiesLimit = 10
+	maxZombiesLimit     = 100
+)
+
 type zombieLocationPayload struct {
-	Lat   float64 `json:"lat"`
-	Lon   float64 `json:"lon"`
-	Limit float64 `json:"limit"`
+	Lat      float64 `json:"lat" query:"lat"`
+	Lon      float64 `json:"lon" query:"lon"`
+	Limit    int     `json:"limit" query:"limit"`
+	RadiusKm float64 `json:"radius_km" query:"radius_km"`
 }
 
-var wrongPayload = errors.New("lat and lon must be greater than 0")
+var (
+	wrongPayload = errors.New("lat must be between -90 and 90, lon must be between -180 and 180, both are required")
+	wrongLimit   = errors.New("limit and radius_km must not be negative")
+	notFinite    = errors.New("lat, lon and radius_km must be finite numbers")
+)
 
 // zombieLocationsHandler processes HTTP requests for zombie locations.
 func (s *Server) zombieLocationsHandler(ctx *fiber.Ctx) error {
@@ -26,15 +38,36 @@ func (s *Server) zombieLocationsHandler(ctx *fiber.Ctx) error {
 		return fiber.ErrBadRequest
 	}
 
-	if payload.Lat <= 0 || payload.Lon <= 0 {
+	// NaN passes any range check, so non-finite values are rejected first
+	if !isFinite(payload.Lat) || !isFinite(payload.Lon) || !isFinite(payload.RadiusKm) {
+		log.Error("invalid query parameters", notFinite)
+		return fiber.ErrBadRequest
+	}
+	// zero is the valid coordinate, so the missing one is told apart by the query
+	if ctx.Query("lat") == "" || ctx.Query("lon") == "" ||
+		payload.Lat < -90 || payload.Lat > 90 || payload.Lon < -180 || payload.Lon > 180 {
 		log.Error("invalid query parameters", wrongPayload)
 		return fiber.ErrBadRequest
 	}
+	if payload.Limit < 0 || payload.RadiusKm < 0 {
+		log.Error("invalid query parameters", wrongLimit)
+		return fiber.ErrBadRequest
+	}
+	if payload.Limit == 0 {
+		payload.Limit = defaultZombiesLimit
+	}
+	if payload.Limit > maxZombiesLimit {
+		payload.Limit = maxZombiesLimit
+	}
 
-	data, err := s.service.Locate(ctx.UserContext(), payload.Lat, payload.Lon, payload.Limit)
+	data, err := s.service.Locate(ctx.UserContext(), payload.Lat, payload.Lon, payload.Limit, payload.RadiusKm)
 	if err != nil {
 		log.Error("failed to locate zombies", err)
 		return fiber.ErrInternalServerError
 	}
 	return ctx.JSON(data)
 }
+
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
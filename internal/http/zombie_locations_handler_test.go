@@ -1,15 +1,20 @@
 package http_test
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 	appServer "zombie_locator/internal/http"
 	"zombie_locator/internal/logger"
+	"zombie_locator/internal/repository/history"
 	"zombie_locator/internal/repository/zombie"
 	"zombie_locator/internal/service/locator"
 
 	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
 	"github.com/phayes/freeport"
 	"github.com/stretchr/testify/require"
 )
@@ -17,29 +22,108 @@ import (
 func TestServer_ZombieLocationsHandler(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	locatorService := locator.NewMockLocator(ctrl)
+	httpAddr := startServer(t, locatorService)
+
+	t.Run("limit and radius", func(t *testing.T) {
+		locatorService.EXPECT().Locate(gomock.Any(), float64(1), float64(2), 3, float64(4)).Return([]zombie.Location{}, nil)
+		requestEndpoint(t, httpAddr, "lat=1&lon=2&limit=3&radius_km=4", http.StatusOK)
+	})
+	t.Run("default limit without radius", func(t *testing.T) {
+		locatorService.EXPECT().Locate(gomock.Any(), float64(1), float64(2), 10, float64(0)).Return([]zombie.Location{}, nil)
+		requestEndpoint(t, httpAddr, "lat=1&lon=2", http.StatusOK)
+	})
+	t.Run("limit is capped", func(t *testing.T) {
+		locatorService.EXPECT().Locate(gomock.Any(), float64(1), float64(2), 100, float64(0)).Return([]zombie.Location{}, nil)
+		requestEndpoint(t, httpAddr, "lat=1&lon=2&limit=1000", http.StatusOK)
+	})
+	t.Run("southern and western hemispheres", func(t *testing.T) {
+		locatorService.EXPECT().Locate(gomock.Any(), float64(-33.9), float64(-70.6), 10, float64(0)).Return([]zombie.Location{}, nil)
+		requestEndpoint(t, httpAddr, "lat=-33.9&lon=-70.6", http.StatusOK)
+	})
+	t.Run("equator and prime meridian", func(t *testing.T) {
+		locatorService.EXPECT().Locate(gomock.Any(), float64(0), float64(0), 10, float64(0)).Return([]zombie.Location{}, nil)
+		requestEndpoint(t, httpAddr, "lat=0&lon=0", http.StatusOK)
+	})
+	t.Run("coordinates out of range", func(t *testing.T) {
+		requestEndpoint(t, httpAddr, "lat=90.1&lon=2", http.StatusBadRequest)
+		requestEndpoint(t, httpAddr, "lat=-90.1&lon=2", http.StatusBadRequest)
+		requestEndpoint(t, httpAddr, "lat=1&lon=180.1", http.StatusBadRequest)
+		requestEndpoint(t, httpAddr, "lat=1&lon=-180.1", http.StatusBadRequest)
+	})
+	t.Run("non-finite values", func(t *testing.T) {
+		requestEndpoint(t, httpAddr, "lat=NaN&lon=2", http.StatusBadRequest)
+		requestEndpoint(t, httpAddr, "lat=1&lon=-Inf", http.StatusBadRequest)
+		requestEndpoint(t, httpAddr, "lat=1&lon=2&radius_km=Inf", http.StatusBadRequest)
+		requestEndpoint(t, httpAddr, "lat=1&lon=2&radius_km=NaN", http.StatusBadRequest)
+	})
+	t.Run("missing coordinates", func(t *testing.T) {
+		requestEndpoint(t, httpAddr, "lat=1", http.StatusBadRequest)
+		requestEndpoint(t, httpAddr, "lon=2", http.StatusBadRequest)
+	})
+	t.Run("negative limit", func(t *testing.T) {
+		requestEndpoint(t, httpAddr, "lat=1&lon=2&limit=-1", http.StatusBadRequest)
+	})
+	t.Run("negative radius", func(t *testing.T) {
+		requestEndpoint(t, httpAddr, "lat=1&lon=2&radius_km=-1", http.StatusBadRequest)
+	})
+}
+
+func TestServer_ZombieLocationsHandlerWithMemoryStorage(t *testing.T) {
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+	repo := zombie.NewMemoryRepository()
+	httpAddr := startServer(t, locator.NewLocatorService(appLog, repo, history.NewMemoryHistory()))
+
+	nearest, farthest, captured := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now().Format(time.RFC3339)
+	require.NoError(t, repo.LocatedZombie(context.Background(), farthest, 48.9, 2.4, now))
+	require.NoError(t, repo.LocatedZombie(context.Background(), nearest, 48.873, 2.333, now))
+	require.NoError(t, repo.LocatedZombie(context.Background(), captured, 48.872544, 2.332298, now))
+	require.NoError(t, repo.CapturedZombie(context.Background(), captured, now))
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/zombies?lat=48.872544&lon=2.332298&limit=5", httpAddr))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var list []zombie.Location
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	require.Len(t, list, 2)
+	require.Equal(t, nearest, list[0].ZombieId)
+	require.Equal(t, farthest, list[1].ZombieId)
+}
+
+func startServer(t *testing.T, locatorService locator.Locator) string {
+	return startServerWithReadiness(t, locatorService, nil)
+}
+
+func startServerWithReadiness(t *testing.T, locatorService locator.Locator, readiness appServer.Readiness) string {
 	appLog, err := logger.NewAppLogger()
 	require.NoError(t, err)
 
-	httpAddr := fmt.Sprintf(":%d", freeport.GetPort())
-	appHTTPServer := appServer.NewServer(appLog, httpAddr, locatorService)
+	httpAddr := fmt.Sprintf("127.0.0.1:%d", freeport.GetPort())
+	appHTTPServer := appServer.NewServer(appLog, appServer.Config{Addr: httpAddr}, locatorService, nil, readiness, nil)
+	// address is bound by Start, so the server accepts connections right away
+	require.NoError(t, appHTTPServer.Start(context.Background()))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
 	go func() {
-		require.NoError(t, appHTTPServer.Run())
+		done <- appHTTPServer.Run(ctx)
 	}()
 	t.Cleanup(func() {
-		require.NoError(t, appHTTPServer.Shutdown())
+		cancel()
+		require.NoError(t, <-done)
 	})
-	locatorService.EXPECT().Locate(gomock.Any(), float64(1), float64(2), float64(3)).Return([]zombie.Location{}, nil)
-	requestEndpoint(t, httpAddr, 1, 2, 3)
+	return httpAddr
 }
 
-func requestEndpoint(t *testing.T, host string, lat, lot, limit float64) {
-	url := fmt.Sprintf("http://%s/zombies?lat=%f&lon=%f&limit=%f", host, lat, lot, limit)
+func requestEndpoint(t *testing.T, host, query string, expectedStatus int) {
+	url := fmt.Sprintf("http://%s/zombies?%s", host, query)
 	resp, err := http.Get(url)
-	if err != nil {
-		require.NoError(t, err)
-	}
+	require.NoError(t, err)
 	defer func() {
 		require.NoError(t, resp.Body.Close())
 	}()
-	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, expectedStatus, resp.StatusCode)
 }
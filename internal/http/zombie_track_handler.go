@@ -0,0 +1,142 @@
+package http
+
+import (
+	"errors"
+	"time"
+	"zombie_locator/internal/repository/history"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultTrackRange is used when the client does not provide the start of the track.
+	defaultTrackRange = 24 * time.Hour
+	// defaultTrackPoints is used when the client does not provide max_points, longer tracks are downsampled.
+	defaultTrackPoints = 1000
+	maxTrackPoints     = 10000
+	minTrackPoints     = 2
+
+	jsonFormat    = "json"
+	geoJSONFormat = "geojson"
+	geoJSONType   = "application/geo+json"
+)
+
+type zombieTrackPayload struct {
+	From      string `query:"from"`
+	To        string `query:"to"`
+	Format    string `query:"format"`
+	MaxPoints int    `query:"max_points"`
+}
+
+var (
+	wrongZombieID   = errors.New("zombie id must be uuid")
+	wrongTrackRange = errors.New("from and to must be RFC3339 time, from must not be after to")
+	wrongTrack      = errors.New("format must be json or geojson, max_points must not be negative")
+)
+
+// geoJSONTrack is the GeoJSON Feature with the track as the LineString, see RFC 7946.
+// Times of the positions are kept in the properties in the same order as the coordinates.
+type geoJSONTrack struct {
+	Type       string                 `json:"type"`
+	Geometry   *geoJSONLineString     `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONLineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+// zombieTrackHandler returns the positions of the zombie between from and to.
+func (s *Server) zombieTrackHandler(ctx *fiber.Ctx) error {
+	log := s.log.
+		With(zap.String("method", "zombieTrackHandler")).
+		With(zap.String("zombie_id", ctx.Params("id"))).
+		With(zap.ByteString("query", ctx.Request().URI().QueryString()))
+	zombieID, err := uuid.Parse(ctx.Params("id"))
+	if err != nil {
+		log.Error("invalid zombie id", wrongZombieID)
+		return fiber.ErrBadRequest
+	}
+	var payload zombieTrackPayload
+	if err = ctx.QueryParser(&payload); err != nil {
+		log.Error("failed to parse query", err)
+		return fiber.ErrBadRequest
+	}
+
+	to := time.Now()
+	if payload.To != "" {
+		if to, err = time.Parse(time.RFC3339, payload.To); err != nil {
+			log.Error("invalid query parameters", wrongTrackRange)
+			return fiber.ErrBadRequest
+		}
+	}
+	from := to.Add(-defaultTrackRange)
+	if payload.From != "" {
+		if from, err = time.Parse(time.RFC3339, payload.From); err != nil {
+			log.Error("invalid query parameters", wrongTrackRange)
+			return fiber.ErrBadRequest
+		}
+	}
+	if from.After(to) {
+		log.Error("invalid query parameters", wrongTrackRange)
+		return fiber.ErrBadRequest
+	}
+
+	if payload.Format == "" {
+		payload.Format = jsonFormat
+		if ctx.Accepts(fiber.MIMEApplicationJSON, geoJSONType) == geoJSONType {
+			payload.Format = geoJSONFormat
+		}
+	}
+	if (payload.Format != jsonFormat && payload.Format != geoJSONFormat) || payload.MaxPoints < 0 {
+		log.Error("invalid query parameters", wrongTrack)
+		return fiber.ErrBadRequest
+	}
+	switch {
+	case payload.MaxPoints == 0:
+		payload.MaxPoints = defaultTrackPoints
+	case payload.MaxPoints < minTrackPoints:
+		payload.MaxPoints = minTrackPoints
+	case payload.MaxPoints > maxTrackPoints:
+		payload.MaxPoints = maxTrackPoints
+	}
+
+	track, err := s.service.Track(ctx.UserContext(), zombieID, from, to, payload.MaxPoints)
+	if err != nil {
+		log.Error("failed to get zombie track", err)
+		return fiber.ErrInternalServerError
+	}
+	if payload.Format == geoJSONFormat {
+		if err = ctx.JSON(newGeoJSONTrack(zombieID, track)); err != nil {
+			return err
+		}
+		ctx.Set(fiber.HeaderContentType, geoJSONType)
+		return nil
+	}
+	return ctx.JSON(track)
+}
+
+// newGeoJSONTrack builds the feature of the track, the track of less than two positions is not a line, so it has no geometry.
+func newGeoJSONTrack(zombieID uuid.UUID, track []history.Point) geoJSONTrack {
+	feature := geoJSONTrack{
+		Type: "Feature",
+		Properties: map[string]interface{}{
+			"zombie_id": zombieID,
+		},
+	}
+	times := make([]time.Time, 0, len(track))
+	coordinates := make([][2]float64, 0, len(track))
+	for _, p := range track {
+		times = append(times, p.RecordedAt)
+		// GeoJSON position is longitude first
+		coordinates = append(coordinates, [2]float64{p.Longitude, p.Latitude})
+	}
+	feature.Properties["recorded_at"] = times
+	if len(track) >= minTrackPoints {
+		feature.Geometry = &geoJSONLineString{Type: "LineString", Coordinates: coordinates}
+	}
+	return feature
+}
@@ -0,0 +1,128 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/repository/history"
+	"zombie_locator/internal/repository/zombie"
+	"zombie_locator/internal/service/locator"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_ZombieTrackHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	locatorService := locator.NewMockLocator(ctrl)
+	httpAddr := startServer(t, locatorService)
+	zombieID := uuid.MustParse("47bf590c-b593-412a-a2a1-68d051cd220c")
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+	path := fmt.Sprintf("/zombies/%s/track", zombieID)
+
+	t.Run("range and max points", func(t *testing.T) {
+		locatorService.EXPECT().Track(gomock.Any(), zombieID, timeEq(from), timeEq(to), 50).Return([]history.Point{}, nil)
+		requestPath(t, httpAddr, path+"?from=2022-01-01T00:00:00Z&to=2022-01-02T00:00:00Z&max_points=50", http.StatusOK)
+	})
+	t.Run("default range and max points", func(t *testing.T) {
+		locatorService.EXPECT().Track(gomock.Any(), zombieID, gomock.Any(), gomock.Any(), 1000).
+			DoAndReturn(func(_ context.Context, _ uuid.UUID, from, to time.Time, _ int) ([]history.Point, error) {
+				require.Equal(t, 24*time.Hour, to.Sub(from))
+				require.WithinDuration(t, time.Now(), to, time.Minute)
+				return []history.Point{}, nil
+			})
+		requestPath(t, httpAddr, path, http.StatusOK)
+	})
+	t.Run("max points is capped", func(t *testing.T) {
+		locatorService.EXPECT().Track(gomock.Any(), zombieID, gomock.Any(), gomock.Any(), 10000).Return([]history.Point{}, nil)
+		requestPath(t, httpAddr, path+"?max_points=100000", http.StatusOK)
+	})
+	t.Run("invalid zombie id", func(t *testing.T) {
+		requestPath(t, httpAddr, "/zombies/zombie/track", http.StatusBadRequest)
+	})
+	t.Run("invalid time", func(t *testing.T) {
+		requestPath(t, httpAddr, path+"?from=yesterday", http.StatusBadRequest)
+	})
+	t.Run("from after to", func(t *testing.T) {
+		requestPath(t, httpAddr, path+"?from=2022-01-02T00:00:00Z&to=2022-01-01T00:00:00Z", http.StatusBadRequest)
+	})
+	t.Run("unknown format", func(t *testing.T) {
+		requestPath(t, httpAddr, path+"?format=gpx", http.StatusBadRequest)
+	})
+	t.Run("negative max points", func(t *testing.T) {
+		requestPath(t, httpAddr, path+"?max_points=-1", http.StatusBadRequest)
+	})
+}
+
+func TestServer_ZombieTrackHandlerWithMemoryStorage(t *testing.T) {
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+	zHistory := history.NewMemoryHistory()
+	httpAddr := startServer(t, locator.NewLocatorService(appLog, zombie.NewMemoryRepository(), zHistory))
+
+	zombieID := uuid.New()
+	require.NoError(t, zHistory.Append(context.Background(),
+		zombie.LocationUpdate{ZombieId: zombieID, Latitude: 48.86, Longitude: 2.3, UpdatedAt: "2022-01-01T10:00:00Z"},
+		zombie.LocationUpdate{ZombieId: zombieID, Latitude: 48.85, Longitude: 2.2, UpdatedAt: "2022-01-01T09:00:00Z"},
+	))
+	path := fmt.Sprintf("/zombies/%s/track?from=2022-01-01T00:00:00Z&to=2022-01-02T00:00:00Z", zombieID)
+
+	t.Run("json", func(t *testing.T) {
+		resp := requestPath(t, httpAddr, path, http.StatusOK)
+		var track []history.Point
+		require.NoError(t, json.Unmarshal(resp, &track))
+		require.Equal(t, []history.Point{
+			{RecordedAt: time.Date(2022, 1, 1, 9, 0, 0, 0, time.UTC), Latitude: 48.85, Longitude: 2.2},
+			{RecordedAt: time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC), Latitude: 48.86, Longitude: 2.3},
+		}, track)
+	})
+	t.Run("geojson", func(t *testing.T) {
+		resp := requestPath(t, httpAddr, path+"&format=geojson", http.StatusOK)
+		require.JSONEq(t, fmt.Sprintf(`{
+			"type": "Feature",
+			"geometry": {"type": "LineString", "coordinates": [[2.2, 48.85], [2.3, 48.86]]},
+			"properties": {
+				"zombie_id": "%s",
+				"recorded_at": ["2022-01-01T09:00:00Z", "2022-01-01T10:00:00Z"]
+			}
+		}`, zombieID), string(resp))
+	})
+	t.Run("single position is not a line", func(t *testing.T) {
+		resp := requestPath(t, httpAddr, path+"&format=geojson&to=2022-01-01T09:30:00Z", http.StatusOK)
+		var feature map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp, &feature))
+		require.Nil(t, feature["geometry"])
+	})
+}
+
+// timeEq matches the time by the instant, parsed times keep the zone of the request.
+type timeEq time.Time
+
+func (e timeEq) Matches(x interface{}) bool {
+	actual, ok := x.(time.Time)
+	return ok && actual.Equal(time.Time(e))
+}
+
+func (e timeEq) String() string {
+	return fmt.Sprintf("is equal to %v", time.Time(e))
+}
+
+func requestPath(t *testing.T, host, path string, expectedStatus int) []byte {
+	resp, err := http.Get(fmt.Sprintf("http://%s%s", host, path))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+	require.Equal(t, expectedStatus, resp.StatusCode)
+	var body json.RawMessage
+	if expectedStatus == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	}
+	return body
+}
@@ -0,0 +1,190 @@
+// Package metrics defines the Prometheus metrics of the service. Metrics are grouped by the layer which records
+// them, each group is created registered by New or unregistered on its own, so components work without metrics.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "zombie_tracker"
+
+// Metrics keeps all the metrics of the service in its own registry.
+type Metrics struct {
+	registry *prometheus.Registry
+	Consumer *Consumer
+	Observer *Observer
+	Storage  *Storage
+	HTTP     *HTTP
+}
+
+// New creates the metrics of the service along with the go runtime and process metrics.
+func New() *Metrics {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return &Metrics{
+		registry: registry,
+		Consumer: NewConsumer(registry),
+		Observer: NewObserver(registry),
+		Storage:  NewStorage(registry),
+		HTTP:     NewHTTP(registry),
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Consumer is recorded by the kafka consumers.
+type Consumer struct {
+	// Consumed counts the processed messages, including the ones put in the dead letter queue.
+	Consumed *prometheus.CounterVec
+	// Failed counts the messages which were not handled and went to the dead letter queue.
+	Failed *prometheus.CounterVec
+	// DeadLetterWrites counts the writes to the dead letter queue by the result.
+	DeadLetterWrites *prometheus.CounterVec
+	// Retries counts the handler calls retried after the transient failure.
+	Retries *prometheus.CounterVec
+	// HandlerDuration is the time of the single handler call, of the message or of the batch.
+	HandlerDuration *prometheus.HistogramVec
+}
+
+// NewConsumer creates the consumer metrics, they are not registered when reg is nil.
+func NewConsumer(reg prometheus.Registerer) *Consumer {
+	m := &Consumer{
+		Consumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "messages_consumed_total",
+			Help:      "Messages processed by the consumer, including the ones put in the dead letter queue.",
+		}, []string{"topic", "version"}),
+		Failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "messages_failed_total",
+			Help:      "Messages which were not handled and went to the dead letter queue.",
+		}, []string{"topic", "version"}),
+		DeadLetterWrites: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "dead_letter_writes_total",
+			Help:      "Writes to the dead letter queue by the result, ok or error.",
+		}, []string{"topic", "result"}),
+		Retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "handler_retries_total",
+			Help:      "Handler calls retried after the transient failure.",
+		}, []string{"topic"}),
+		HandlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "handler_duration_seconds",
+			Help:      "Time of the single handler call by the handler kind, message or batch.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"topic", "handler"}),
+	}
+	register(reg, m.Consumed, m.Failed, m.DeadLetterWrites, m.Retries, m.HandlerDuration)
+	return m
+}
+
+// Observer is recorded by the observer service.
+type Observer struct {
+	// EventLag is the time from the event updated_at to its persistence.
+	EventLag *prometheus.HistogramVec
+	// StaleEvents counts events skipped because a fresher zombie state was already stored.
+	StaleEvents *prometheus.CounterVec
+}
+
+// NewObserver creates the observer metrics, they are not registered when reg is nil.
+func NewObserver(reg prometheus.Registerer) *Observer {
+	m := &Observer{
+		EventLag: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "observer",
+			Name:      "event_lag_seconds",
+			Help:      "Time from the event updated_at to its persistence by the event kind, location or capture.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+		}, []string{"event"}),
+		StaleEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "observer",
+			Name:      "stale_events_total",
+			Help:      "Events skipped because a fresher zombie state was already stored by the event kind, location or capture.",
+		}, []string{"event"}),
+	}
+	register(reg, m.EventLag, m.StaleEvents)
+	return m
+}
+
+// Storage is recorded by the zombie storage.
+type Storage struct {
+	// Duration is the time of the operation of the backend, postgres or tile38.
+	Duration *prometheus.HistogramVec
+	// Errors counts the failed operations of the backend.
+	Errors *prometheus.CounterVec
+}
+
+// NewStorage creates the storage metrics, they are not registered when reg is nil.
+func NewStorage(reg prometheus.Registerer) *Storage {
+	m := &Storage{
+		Duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "operation_duration_seconds",
+			Help:      "Time of the storage operation by the backend, postgres or tile38.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"backend", "operation"}),
+		Errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "operation_errors_total",
+			Help:      "Failed storage operations by the backend, postgres or tile38.",
+		}, []string{"backend", "operation"}),
+	}
+	register(reg, m.Duration, m.Errors)
+	return m
+}
+
+// HTTP is recorded by the http server.
+type HTTP struct {
+	// Requests counts the served requests by the route pattern and the response status.
+	Requests *prometheus.CounterVec
+	// Duration is the time of the request by the route pattern.
+	Duration *prometheus.HistogramVec
+}
+
+// NewHTTP creates the http server metrics, they are not registered when reg is nil.
+func NewHTTP(reg prometheus.Registerer) *HTTP {
+	m := &HTTP{
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Served requests by the route pattern and the response status.",
+		}, []string{"method", "route", "status"}),
+		Duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Time of the request by the route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+	}
+	register(reg, m.Requests, m.Duration)
+	return m
+}
+
+func register(reg prometheus.Registerer, collectors ...prometheus.Collector) {
+	if reg == nil {
+		return
+	}
+	reg.MustRegister(collectors...)
+}
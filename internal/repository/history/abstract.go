@@ -0,0 +1,26 @@
+package history
+
+import (
+	"context"
+	"time"
+	"zombie_locator/internal/repository/zombie"
+
+	"github.com/google/uuid"
+)
+
+// Point is the zombie position at the event time.
+type Point struct {
+	RecordedAt time.Time `json:"recorded_at"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+}
+
+//go:generate mockgen -source=abstract.go -destination=abstract_historian_mock.go -package=history
+type Historian interface {
+	// Append records the locations. Locations are kept whatever their order is,
+	// the same location recorded twice is kept once.
+	Append(ctx context.Context, updates ...zombie.LocationUpdate) error
+	// Track returns the zombie positions recorded between from and to inclusive, ordered by time.
+	// Long tracks are downsampled to maxPoints evenly spaced positions, the first and the last ones are always kept.
+	Track(ctx context.Context, zombieID uuid.UUID, from, to time.Time, maxPoints int) ([]Point, error)
+}
@@ -0,0 +1,72 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: abstract.go
+
+// Package history is a generated GoMock package.
+package history
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+	zombie "zombie_locator/internal/repository/zombie"
+
+	gomock "github.com/golang/mock/gomock"
+	uuid "github.com/google/uuid"
+)
+
+// MockHistorian is a mock of Historian interface.
+type MockHistorian struct {
+	ctrl     *gomock.Controller
+	recorder *MockHistorianMockRecorder
+}
+
+// MockHistorianMockRecorder is the mock recorder for MockHistorian.
+type MockHistorianMockRecorder struct {
+	mock *MockHistorian
+}
+
+// NewMockHistorian creates a new mock instance.
+func NewMockHistorian(ctrl *gomock.Controller) *MockHistorian {
+	mock := &MockHistorian{ctrl: ctrl}
+	mock.recorder = &MockHistorianMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockHistorian) EXPECT() *MockHistorianMockRecorder {
+	return m.recorder
+}
+
+// Append mocks base method.
+func (m *MockHistorian) Append(ctx context.Context, updates ...zombie.LocationUpdate) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx}
+	for _, a := range updates {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Append", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Append indicates an expected call of Append.
+func (mr *MockHistorianMockRecorder) Append(ctx interface{}, updates ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx}, updates...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Append", reflect.TypeOf((*MockHistorian)(nil).Append), varargs...)
+}
+
+// Track mocks base method.
+func (m *MockHistorian) Track(ctx context.Context, zombieID uuid.UUID, from, to time.Time, maxPoints int) ([]Point, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Track", ctx, zombieID, from, to, maxPoints)
+	ret0, _ := ret[0].([]Point)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Track indicates an expected call of Track.
+func (mr *MockHistorianMockRecorder) Track(ctx, zombieID, from, to, maxPoints interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Track", reflect.TypeOf((*MockHistorian)(nil).Track), ctx, zombieID, from, to, maxPoints)
+}
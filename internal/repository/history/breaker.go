@@ -0,0 +1,35 @@
+package history
+
+import (
+	"context"
+	"time"
+	"zombie_locator/internal/repository/zombie"
+	"zombie_locator/internal/utils/circuit"
+
+	"github.com/google/uuid"
+)
+
+// BreakerHistory guards appends with the circuit breaker shared with the zombie storage, so postgres outage
+// stops the consumers instead of failing each event while the history is appended.
+type BreakerHistory struct {
+	history Historian
+	breaker *circuit.Breaker
+}
+
+func NewBreakerHistory(history Historian, breaker *circuit.Breaker) *BreakerHistory {
+	return &BreakerHistory{
+		history: history,
+		breaker: breaker,
+	}
+}
+
+func (b *BreakerHistory) Append(ctx context.Context, updates ...zombie.LocationUpdate) error {
+	return b.breaker.Execute(func() error {
+		return b.history.Append(ctx, updates...)
+	})
+}
+
+// Track is not guarded: reads are served by the http api, which reports own errors.
+func (b *BreakerHistory) Track(ctx context.Context, zombieID uuid.UUID, from, to time.Time, maxPoints int) ([]Point, error) {
+	return b.history.Track(ctx, zombieID, from, to, maxPoints)
+}
@@ -0,0 +1,32 @@
+package history
+
+// minTrackPoints is the smallest track which keeps both ends.
+const minTrackPoints = 2
+
+// stride returns the step between the kept positions of the track of total positions, so that
+// the positions 0, stride, 2*stride... and the last one are at most maxPoints.
+func stride(total, maxPoints int) int {
+	if maxPoints < minTrackPoints {
+		maxPoints = minTrackPoints
+	}
+	if total <= maxPoints {
+		return 1
+	}
+	return (total - 1 + maxPoints - 2) / (maxPoints - 1)
+}
+
+// downsample keeps every stride position of the ordered track and the last one.
+func downsample(points []Point, maxPoints int) []Point {
+	step := stride(len(points), maxPoints)
+	if step == 1 {
+		return points
+	}
+	result := make([]Point, 0, maxPoints)
+	for i := 0; i < len(points); i += step {
+		result = append(result, points[i])
+	}
+	if (len(points)-1)%step != 0 {
+		result = append(result, points[len(points)-1])
+	}
+	return result
+}
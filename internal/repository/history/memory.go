@@ -0,0 +1,70 @@
+package history
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+	"zombie_locator/internal/repository/zombie"
+
+	"github.com/google/uuid"
+)
+
+// MemoryHistory keeps zombie tracks in memory, it goes along with the memory zombie storage.
+type MemoryHistory struct {
+	mu     sync.RWMutex
+	tracks map[uuid.UUID][]Point
+}
+
+func NewMemoryHistory() *MemoryHistory {
+	return &MemoryHistory{
+		tracks: make(map[uuid.UUID][]Point),
+	}
+}
+
+// Append inserts the locations keeping each track ordered by time, already recorded ones are skipped.
+func (m *MemoryHistory) Append(ctx context.Context, updates ...zombie.LocationUpdate) error {
+	points := make([]Point, 0, len(updates))
+	for _, u := range updates {
+		recordedAt, err := parseRecordedAt(u.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		points = append(points, Point{RecordedAt: recordedAt, Latitude: u.Latitude, Longitude: u.Longitude})
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, u := range updates {
+		track := m.tracks[u.ZombieId]
+		at := sort.Search(len(track), func(j int) bool {
+			return !track[j].RecordedAt.Before(points[i].RecordedAt)
+		})
+		if at < len(track) && track[at].RecordedAt.Equal(points[i].RecordedAt) {
+			continue
+		}
+		track = append(track, Point{})
+		copy(track[at+1:], track[at:])
+		track[at] = points[i]
+		m.tracks[u.ZombieId] = track
+	}
+	return nil
+}
+
+// Track returns the zombie positions between from and to.
+func (m *MemoryHistory) Track(ctx context.Context, zombieID uuid.UUID, from, to time.Time, maxPoints int) ([]Point, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	track := m.tracks[zombieID]
+	start := sort.Search(len(track), func(i int) bool {
+		return !track[i].RecordedAt.Before(from)
+	})
+	end := sort.Search(len(track), func(i int) bool {
+		return track[i].RecordedAt.After(to)
+	})
+	if start >= end {
+		return []Point{}, nil
+	}
+	points := make([]Point, end-start)
+	copy(points, track[start:end])
+	return downsample(points, maxPoints), nil
+}
@@ -0,0 +1,107 @@
+package history_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	"zombie_locator/internal/repository/history"
+	"zombie_locator/internal/repository/zombie"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryHistory_Track(t *testing.T) {
+	checkTrack(t, history.NewMemoryHistory())
+}
+
+func TestMemoryHistory_Downsample(t *testing.T) {
+	checkDownsample(t, history.NewMemoryHistory())
+}
+
+// checkTrack checks the track is ordered by time, bounded by the range and has no duplicates.
+func checkTrack(t *testing.T, repo history.Historian) {
+	zombieID, other := uuid.New(), uuid.New()
+	start := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.Append(context.Background(),
+		location(zombieID, start.Add(2*time.Minute), 3),
+		location(zombieID, start, 1),
+		location(other, start.Add(time.Minute), 10),
+		location(zombieID, start.Add(time.Minute), 2),
+	))
+	// redelivered location is kept once
+	require.NoError(t, repo.Append(context.Background(), location(zombieID, start.Add(time.Minute), 2)))
+
+	track, err := repo.Track(context.Background(), zombieID, start, start.Add(time.Hour), 100)
+	require.NoError(t, err)
+	require.Equal(t, []history.Point{
+		{RecordedAt: start, Latitude: 1, Longitude: 1},
+		{RecordedAt: start.Add(time.Minute), Latitude: 2, Longitude: 2},
+		{RecordedAt: start.Add(2 * time.Minute), Latitude: 3, Longitude: 3},
+	}, track)
+
+	// range is inclusive
+	track, err = repo.Track(context.Background(), zombieID, start.Add(time.Minute), start.Add(2*time.Minute), 100)
+	require.NoError(t, err)
+	require.Len(t, track, 2)
+
+	track, err = repo.Track(context.Background(), zombieID, start.Add(time.Hour), start.Add(2*time.Hour), 100)
+	require.NoError(t, err)
+	require.Empty(t, track)
+
+	require.Error(t, repo.Append(context.Background(), zombie.LocationUpdate{ZombieId: zombieID, UpdatedAt: "now"}))
+}
+
+// checkDownsample checks long tracks keep both ends and evenly spaced positions between them.
+func checkDownsample(t *testing.T, repo history.Historian) {
+	zombieID := uuid.New()
+	start := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	updates := make([]zombie.LocationUpdate, 0, 100)
+	for i := 0; i < 100; i++ {
+		updates = append(updates, location(zombieID, start.Add(time.Duration(i)*time.Second), float64(i)))
+	}
+	require.NoError(t, repo.Append(context.Background(), updates...))
+
+	for _, tc := range []struct {
+		maxPoints int
+		expected  []float64
+	}{
+		{maxPoints: 100, expected: sequence(0, 99, 1)},
+		{maxPoints: 1000, expected: sequence(0, 99, 1)},
+		{maxPoints: 10, expected: sequence(0, 99, 11)},
+		{maxPoints: 8, expected: append(sequence(0, 99, 15), 99)},
+		{maxPoints: 2, expected: []float64{0, 99}},
+		{maxPoints: 1, expected: []float64{0, 99}},
+	} {
+		t.Run(fmt.Sprintf("max %d", tc.maxPoints), func(t *testing.T) {
+			track, err := repo.Track(context.Background(), zombieID, start, start.Add(time.Hour), tc.maxPoints)
+			require.NoError(t, err)
+			lats := make([]float64, 0, len(track))
+			for _, p := range track {
+				lats = append(lats, p.Latitude)
+			}
+			require.Equal(t, tc.expected, lats)
+			require.LessOrEqual(t, len(track), max(tc.maxPoints, 2))
+		})
+	}
+}
+
+func location(zombieID uuid.UUID, at time.Time, value float64) zombie.LocationUpdate {
+	return zombie.LocationUpdate{ZombieId: zombieID, Latitude: value, Longitude: value, UpdatedAt: at.Format(time.RFC3339)}
+}
+
+func sequence(from, to, step int) []float64 {
+	var result []float64
+	for i := from; i <= to; i += step {
+		result = append(result, float64(i))
+	}
+	return result
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,104 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"zombie_locator/internal/repository/zombie"
+	"zombie_locator/internal/storage/db"
+	"zombie_locator/internal/utils/failure"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// pgTimestampLayout formats event time for the timestamp array parameter.
+const pgTimestampLayout = "2006-01-02 15:04:05.999999"
+
+// History keeps zombie locations in the append only postgres table.
+type History struct {
+	dbConnect db.Connector
+}
+
+func NewHistoryRepository(dbConnect db.Connector) *History {
+	return &History{
+		dbConnect: dbConnect,
+	}
+}
+
+// Append records the locations with a single insert, already recorded ones are skipped,
+// so redelivered events do not duplicate the track.
+func (h *History) Append(ctx context.Context, updates ...zombie.LocationUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(updates))
+	times := make([]string, 0, len(updates))
+	lats := make([]float64, 0, len(updates))
+	lons := make([]float64, 0, len(updates))
+	for _, u := range updates {
+		recordedAt, err := parseRecordedAt(u.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, u.ZombieId.String())
+		times = append(times, recordedAt.Format(pgTimestampLayout))
+		lats = append(lats, u.Latitude)
+		lons = append(lons, u.Longitude)
+	}
+	if _, err := h.dbConnect.Client().ExecContext(ctx, `
+		INSERT INTO zombie_locations_history(zombie_id, recorded_at, lat, lon)
+		SELECT * FROM unnest($1::uuid[], $2::timestamp[], $3::float8[], $4::float8[])
+		ON CONFLICT (zombie_id, recorded_at) DO NOTHING;
+	`, pq.Array(ids), pq.Array(times), pq.Array(lats), pq.Array(lons)); err != nil {
+		return failure.Transient(fmt.Errorf("unable to append zombie locations: %w", err))
+	}
+	return nil
+}
+
+// Track returns the zombie positions between from and to, the track is downsampled by the database,
+// so long tracks are not loaded to memory.
+func (h *History) Track(ctx context.Context, zombieID uuid.UUID, from, to time.Time, maxPoints int) ([]Point, error) {
+	if maxPoints < minTrackPoints {
+		maxPoints = minTrackPoints
+	}
+	var rows []struct {
+		RecordedAt time.Time `db:"recorded_at"`
+		Latitude   float64   `db:"lat"`
+		Longitude  float64   `db:"lon"`
+	}
+	// the kept positions are the same as the ones kept by downsample
+	err := h.dbConnect.Client().SelectContext(ctx, &rows, `
+		SELECT recorded_at, lat, lon FROM (
+			SELECT recorded_at, lat, lon,
+				row_number() OVER (ORDER BY recorded_at) - 1 AS n,
+				count(*) OVER () AS total
+			FROM zombie_locations_history
+			WHERE zombie_id = $1 AND recorded_at BETWEEN $2 AND $3
+		) track
+		WHERE total <= $4 OR n % ((total - 1 + $4 - 2) / ($4 - 1)) = 0 OR n = total - 1
+		ORDER BY recorded_at;
+	`, zombieID, from.UTC().Format(pgTimestampLayout), to.UTC().Format(pgTimestampLayout), maxPoints)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get zombie track: %w", err)
+	}
+	result := make([]Point, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, Point{
+			// timestamp column has no zone, it is stored in UTC
+			RecordedAt: r.RecordedAt.UTC(),
+			Latitude:   r.Latitude,
+			Longitude:  r.Longitude,
+		})
+	}
+	return result, nil
+}
+
+// parseRecordedAt parses the event time to UTC, the way zombie storages do.
+func parseRecordedAt(updatedAt string) (time.Time, error) {
+	recordedAt, err := time.Parse(time.RFC3339, updatedAt)
+	if err != nil {
+		return time.Time{}, failure.Permanent(fmt.Errorf("unable to parse time: %w", err))
+	}
+	return recordedAt.UTC(), nil
+}
@@ -0,0 +1,94 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: astract.go
+
+// Package zombie is a generated GoMock package.
+package zombie
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	uuid "github.com/google/uuid"
+)
+
+// MockZombier is a mock of Zombier interface.
+type MockZombier struct {
+	ctrl     *gomock.Controller
+	recorder *MockZombierMockRecorder
+}
+
+// MockZombierMockRecorder is the mock recorder for MockZombier.
+type MockZombierMockRecorder struct {
+	mock *MockZombier
+}
+
+// NewMockZombier creates a new mock instance.
+func NewMockZombier(ctrl *gomock.Controller) *MockZombier {
+	mock := &MockZombier{ctrl: ctrl}
+	mock.recorder = &MockZombierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockZombier) EXPECT() *MockZombierMockRecorder {
+	return m.recorder
+}
+
+// CapturedZombie mocks base method.
+func (m *MockZombier) CapturedZombie(ctx context.Context, zombieId uuid.UUID, updatedAt string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CapturedZombie", ctx, zombieId, updatedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CapturedZombie indicates an expected call of CapturedZombie.
+func (mr *MockZombierMockRecorder) CapturedZombie(ctx, zombieId, updatedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CapturedZombie", reflect.TypeOf((*MockZombier)(nil).CapturedZombie), ctx, zombieId, updatedAt)
+}
+
+// LocateZombieList mocks base method.
+func (m *MockZombier) LocateZombieList(ctx context.Context, lat, lon float64, limit int, radiusKm float64) ([]Location, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LocateZombieList", ctx, lat, lon, limit, radiusKm)
+	ret0, _ := ret[0].([]Location)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LocateZombieList indicates an expected call of LocateZombieList.
+func (mr *MockZombierMockRecorder) LocateZombieList(ctx, lat, lon, limit, radiusKm interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LocateZombieList", reflect.TypeOf((*MockZombier)(nil).LocateZombieList), ctx, lat, lon, limit, radiusKm)
+}
+
+// LocatedZombie mocks base method.
+func (m *MockZombier) LocatedZombie(ctx context.Context, zombieId uuid.UUID, lat, lon float64, updatedAt string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LocatedZombie", ctx, zombieId, lat, lon, updatedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LocatedZombie indicates an expected call of LocatedZombie.
+func (mr *MockZombierMockRecorder) LocatedZombie(ctx, zombieId, lat, lon, updatedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LocatedZombie", reflect.TypeOf((*MockZombier)(nil).LocatedZombie), ctx, zombieId, lat, lon, updatedAt)
+}
+
+// LocatedZombies mocks base method.
+func (m *MockZombier) LocatedZombies(ctx context.Context, updates []LocationUpdate) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LocatedZombies", ctx, updates)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LocatedZombies indicates an expected call of LocatedZombies.
+func (mr *MockZombierMockRecorder) LocatedZombies(ctx, updates interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LocatedZombies", reflect.TypeOf((*MockZombier)(nil).LocatedZombies), ctx, updates)
+}
@@ -2,18 +2,40 @@ package zombie
 
 import (
 	"context"
+	"errors"
 
 	"github.com/google/uuid"
 )
 
+// StaleEvent is returned when the event is older than the stored zombie state, so it was skipped.
+var StaleEvent = errors.New("stale event")
+
+// IndexDeferred is returned when the update is stored, but the search index is not updated yet.
+// The outbox relay applies it later, so the update should not be retried.
+var IndexDeferred = errors.New("search index update is deferred")
+
 type Location struct {
 	ZombieId  uuid.UUID `json:"zombie_id"`
 	Latitude  float64   `json:"latitude"`
 	Longitude float64   `json:"longitude"`
 }
 
+// LocationUpdate is the zombie location event.
+type LocationUpdate struct {
+	ZombieId  uuid.UUID
+	Latitude  float64
+	Longitude float64
+	UpdatedAt string
+}
+
+//go:generate mockgen -source=astract.go -destination=abstract_zombier_mock.go -package=zombie
 type Zombier interface {
 	CapturedZombie(ctx context.Context, zombieId uuid.UUID, updatedAt string) error
 	LocatedZombie(ctx context.Context, zombieId uuid.UUID, lat, lon float64, updatedAt string) error
-	LocateZombieList(ctx context.Context, lat, lon, limitKm float64) ([]Location, error)
+	// LocatedZombies stores the batch of locations at once, only the latest location of each zombie is kept.
+	// It returns the number of stale locations skipped.
+	LocatedZombies(ctx context.Context, updates []LocationUpdate) (int, error)
+	// LocateZombieList returns up to limit zombies nearest to the given point, ordered by distance.
+	// radiusKm restricts the search area, zero means the search is not bounded by distance.
+	LocateZombieList(ctx context.Context, lat, lon float64, limit int, radiusKm float64) ([]Location, error)
 }
@@ -0,0 +1,50 @@
+package zombie
+
+import (
+	"context"
+	"zombie_locator/internal/utils/circuit"
+
+	"github.com/google/uuid"
+)
+
+// BreakerZombie guards zombie updates with the circuit breaker, so storage outage stops
+// the updates instead of failing each of them. IndexDeferred counts as the failure too,
+// so tile38 outage stops the updates although postgres keeps storing them.
+type BreakerZombie struct {
+	repo    Zombier
+	breaker *circuit.Breaker
+}
+
+func NewBreakerRepository(repo Zombier, breaker *circuit.Breaker) *BreakerZombie {
+	return &BreakerZombie{
+		repo:    repo,
+		breaker: breaker,
+	}
+}
+
+func (b *BreakerZombie) CapturedZombie(ctx context.Context, zombieID uuid.UUID, updatedAt string) error {
+	return b.breaker.Execute(func() error {
+		return b.repo.CapturedZombie(ctx, zombieID, updatedAt)
+	})
+}
+
+func (b *BreakerZombie) LocatedZombie(ctx context.Context, zombieID uuid.UUID, lat, lon float64, updatedAt string) error {
+	return b.breaker.Execute(func() error {
+		return b.repo.LocatedZombie(ctx, zombieID, lat, lon, updatedAt)
+	})
+}
+
+func (b *BreakerZombie) LocatedZombies(ctx context.Context, updates []LocationUpdate) (int, error) {
+	var stale int
+	err := b.breaker.Execute(func() error {
+		var err error
+		stale, err = b.repo.LocatedZombies(ctx, updates)
+		return err
+	})
+	return stale, err
+}
+
+// LocateZombieList is not guarded: reads are served by the http api, which reports own errors.
+func (b *BreakerZombie) LocateZombieList(ctx context.Context, lat, lon float64, limit int, radiusKm float64) ([]Location, error) {
+	return b.repo.LocateZombieList(ctx, lat, lon, limit, radiusKm)
+}
@@ -0,0 +1,44 @@
+package zombie_test
+
+import (
+	"testing"
+	"zombie_locator/internal/repository/zombie"
+	"zombie_locator/internal/repository/zombie/zombietest"
+	"zombie_locator/internal/storage/db"
+
+	"github.com/xjem/t38c"
+)
+
+func TestMemoryZombie_Conformance(t *testing.T) {
+	zombietest.RunConformance(t, func(t *testing.T) zombie.Zombier {
+		return zombie.NewMemoryRepository()
+	})
+}
+
+func TestZombie_Conformance(t *testing.T) {
+	zombietest.RunConformance(t, func(t *testing.T) zombie.Zombier {
+		return zombie.NewZombieRepository(postgresOrSkip(t), tile38OrSkip(t), nil)
+	})
+}
+
+func TestPostgisZombie_Conformance(t *testing.T) {
+	zombietest.RunConformance(t, func(t *testing.T) zombie.Zombier {
+		return zombie.NewPostgisRepository(postgresOrSkip(t))
+	})
+}
+
+func postgresOrSkip(t *testing.T) *db.Connect {
+	pgConnect, err := db.NewPostgresConnection(db.PostgresConfig{URL: postgresqlURL})
+	if err != nil {
+		t.Skipf("postgres is not available: %v", err)
+	}
+	return pgConnect
+}
+
+func tile38OrSkip(t *testing.T) *t38c.Client {
+	connect, err := db.NewTile38Connection(db.Tile38Config{Addr: tile38URL})
+	if err != nil {
+		t.Skipf("tile38 is not available: %v", err)
+	}
+	return connect
+}
@@ -0,0 +1,310 @@
+package zombie
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// memoryCellDegrees is the size of the grid cell the located zombies are indexed by.
+	memoryCellDegrees = 1.0
+	earthRadiusKm     = 6371.0088
+)
+
+// MemoryZombie keeps zombies in memory, it is meant for the single node mode and for tests.
+// Located zombies are indexed by the latitude/longitude grid, the search visits cells ring by ring
+// around the origin and stops once no closer zombie can be found in the farther rings.
+// It follows the same rules as the durable storages: last write wins by the event time and capture is terminal.
+type MemoryZombie struct {
+	mu      sync.RWMutex
+	zombies map[uuid.UUID]*memoryZombie
+	// cells keeps the ids of located and not captured zombies per grid cell.
+	cells map[gridCell]map[uuid.UUID]struct{}
+	// indexed is the number of zombies in cells.
+	indexed int
+	// lastUpdate is the time of the newest stored event.
+	lastUpdate time.Time
+}
+
+type memoryZombie struct {
+	status    string
+	updatedAt time.Time
+	located   bool
+	lat, lon  float64
+	cell      gridCell
+}
+
+type gridCell struct {
+	lat, lon int
+}
+
+func NewMemoryRepository() *MemoryZombie {
+	return &MemoryZombie{
+		zombies: make(map[uuid.UUID]*memoryZombie),
+		cells:   make(map[gridCell]map[uuid.UUID]struct{}),
+	}
+}
+
+// CapturedZombie marks the zombie as captured and removes it from the hunting list.
+// Capture of already captured zombie is skipped with StaleEvent error.
+func (m *MemoryZombie) CapturedZombie(ctx context.Context, zombieID uuid.UUID, updatedAt string) error {
+	data, err := parseUpdatedAt(updatedAt)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, ok := m.zombies[zombieID]
+	if !ok {
+		m.zombies[zombieID] = &memoryZombie{status: capturedStatus, updatedAt: data}
+		m.touch(data)
+		return nil
+	}
+	if z.status == capturedStatus {
+		return StaleEvent
+	}
+	m.touch(data)
+	m.unindex(zombieID, z)
+	z.status = capturedStatus
+	if data.After(z.updatedAt) {
+		z.updatedAt = data
+	}
+	return nil
+}
+
+// LocatedZombie stores the last known zombie location, captured zombie stays out of the hunting list.
+// Location older than the stored zombie state is skipped with StaleEvent error.
+func (m *MemoryZombie) LocatedZombie(ctx context.Context, zombieID uuid.UUID, lat, lon float64, updatedAt string) error {
+	data, err := parseUpdatedAt(updatedAt)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.locate(zombieID, lat, lon, data) {
+		return StaleEvent
+	}
+	return nil
+}
+
+// LocatedZombies stores the batch of locations, only the latest location of each zombie is kept.
+// It returns the number of stale locations skipped.
+func (m *MemoryZombie) LocatedZombies(ctx context.Context, updates []LocationUpdate) (int, error) {
+	parsed := make([]time.Time, 0, len(updates))
+	for _, u := range updates {
+		data, err := parseUpdatedAt(u.UpdatedAt)
+		if err != nil {
+			return 0, err
+		}
+		parsed = append(parsed, data)
+	}
+	// the batch is collapsed to the latest location per zombie as the postgres storages do,
+	// so the superseded events are stale whatever their order
+	latest := make(map[uuid.UUID]int, len(updates))
+	for i, u := range updates {
+		if j, ok := latest[u.ZombieId]; ok && parsed[j].After(parsed[i]) {
+			continue
+		}
+		latest[u.ZombieId] = i
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stale := len(updates) - len(latest)
+	for _, i := range latest {
+		u := updates[i]
+		if !m.locate(u.ZombieId, u.Latitude, u.Longitude, parsed[i]) {
+			stale++
+		}
+	}
+	return stale, nil
+}
+
+// locate applies the location unless it is older than the stored one, it must be called with the lock held.
+func (m *MemoryZombie) locate(zombieID uuid.UUID, lat, lon float64, updatedAt time.Time) bool {
+	z, ok := m.zombies[zombieID]
+	if !ok {
+		z = &memoryZombie{status: locatedStatus}
+		m.zombies[zombieID] = z
+	} else if z.updatedAt.After(updatedAt) {
+		return false
+	}
+	m.touch(updatedAt)
+	m.unindex(zombieID, z)
+	z.updatedAt = updatedAt
+	z.located = true
+	z.lat, z.lon = lat, lon
+	if z.status != capturedStatus {
+		z.cell = cellOf(lat, lon)
+		ids, ok := m.cells[z.cell]
+		if !ok {
+			ids = make(map[uuid.UUID]struct{})
+			m.cells[z.cell] = ids
+		}
+		ids[zombieID] = struct{}{}
+		m.indexed++
+	}
+	return true
+}
+
+// touch keeps the time of the newest stored event, it must be called with the lock held.
+func (m *MemoryZombie) touch(updatedAt time.Time) {
+	if updatedAt.After(m.lastUpdate) {
+		m.lastUpdate = updatedAt
+	}
+}
+
+func (m *MemoryZombie) unindex(zombieID uuid.UUID, z *memoryZombie) {
+	if !z.located || z.status == capturedStatus {
+		return
+	}
+	ids := m.cells[z.cell]
+	delete(ids, zombieID)
+	m.indexed--
+	if len(ids) == 0 {
+		delete(m.cells, z.cell)
+	}
+}
+
+type memoryCandidate struct {
+	id       uuid.UUID
+	lat, lon float64
+	distance float64
+}
+
+// LocateZombieList returns up to limit zombies nearest to the given point by the great-circle distance.
+// radiusKm restricts the search area, zero means the search is not bounded by distance.
+func (m *MemoryZombie) LocateZombieList(ctx context.Context, lat, lon float64, limit int, radiusKm float64) ([]Location, error) {
+	if limit <= 0 {
+		return []Location{}, nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	origin := cellOf(lat, lon)
+	latCells := int(180 / memoryCellDegrees)
+	lonCells := int(360 / memoryCellDegrees)
+	visited := make(map[gridCell]struct{})
+	seen := 0
+	var candidates []memoryCandidate
+	for ring := 0; seen < m.indexed && len(visited) < latCells*lonCells; ring++ {
+		for dLat := -ring; dLat <= ring; dLat++ {
+			// inner cells were visited by the previous rings
+			step := 2 * ring
+			if abs(dLat) == ring || step == 0 {
+				step = 1
+			}
+			for dLon := -ring; dLon <= ring; dLon += step {
+				cell := gridCell{lat: origin.lat + dLat, lon: mod(origin.lon+dLon, lonCells)}
+				if cell.lat < 0 || cell.lat >= latCells {
+					continue
+				}
+				if _, ok := visited[cell]; ok {
+					continue
+				}
+				visited[cell] = struct{}{}
+				seen += len(m.cells[cell])
+				for id := range m.cells[cell] {
+					z := m.zombies[id]
+					distance := haversineKm(lat, lon, z.lat, z.lon)
+					if radiusKm > 0 && distance > radiusKm {
+						continue
+					}
+					candidates = append(candidates, memoryCandidate{id: id, lat: z.lat, lon: z.lon, distance: distance})
+				}
+			}
+		}
+		// zombies of the cells out of the visited rings are at least this far
+		bound := ringDistanceKm(lat, ring)
+		if radiusKm > 0 && bound > radiusKm {
+			break
+		}
+		if len(candidates) >= limit {
+			sortCandidates(candidates)
+			if candidates[limit-1].distance <= bound {
+				break
+			}
+		}
+	}
+
+	sortCandidates(candidates)
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	result := make([]Location, 0, len(candidates))
+	for _, c := range candidates {
+		result = append(result, Location{
+			ZombieId:  c.id,
+			Latitude:  c.lat,
+			Longitude: c.lon,
+		})
+	}
+	return result, nil
+}
+
+// Ping always succeeds, memory is always reachable.
+func (m *MemoryZombie) Ping(ctx context.Context) error {
+	return nil
+}
+
+// LastUpdate returns the time of the newest stored event, zero time when nothing is stored yet.
+func (m *MemoryZombie) LastUpdate(ctx context.Context) (time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastUpdate, nil
+}
+
+func sortCandidates(candidates []memoryCandidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].id.String() < candidates[j].id.String()
+	})
+}
+
+func cellOf(lat, lon float64) gridCell {
+	latCells := int(180 / memoryCellDegrees)
+	lonCells := int(360 / memoryCellDegrees)
+	cell := gridCell{
+		lat: int(math.Floor((lat + 90) / memoryCellDegrees)),
+		lon: mod(int(math.Floor((lon+180)/memoryCellDegrees)), lonCells),
+	}
+	// the north pole belongs to the last row
+	if cell.lat >= latCells {
+		cell.lat = latCells - 1
+	}
+	return cell
+}
+
+// ringDistanceKm returns the lower bound of the distance from the point to any cell out of the rings
+// visited so far. Such cell is at least ring cells away by latitude or longitude, the bound by longitude
+// is the distance to the meridian, it is never greater than the bound by latitude.
+func ringDistanceKm(lat float64, ring int) float64 {
+	angle := math.Min(float64(ring)*memoryCellDegrees*math.Pi/180, math.Pi/2)
+	return earthRadiusKm * math.Asin(math.Cos(lat*math.Pi/180)*math.Sin(angle))
+}
+
+// haversineKm returns the great-circle distance between two points.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	φ1, φ2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dφ := φ2 - φ1
+	dλ := (lon2 - lon1) * math.Pi / 180
+	a := math.Sin(dφ/2)*math.Sin(dφ/2) + math.Cos(φ1)*math.Cos(φ2)*math.Sin(dλ/2)*math.Sin(dλ/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func mod(v, m int) int {
+	return ((v % m) + m) % m
+}
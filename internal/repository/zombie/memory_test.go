@@ -0,0 +1,159 @@
+package zombie_test
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"zombie_locator/internal/repository/zombie"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryZombie_LocatedAndCapturedZombie(t *testing.T) {
+	repo := zombie.NewMemoryRepository()
+	zombieID := uuid.New()
+	now := time.Now()
+
+	require.NoError(t, repo.LocatedZombie(context.Background(), zombieID, 48.85905, 2.294533, now.Format(time.RFC3339)))
+	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, true)
+
+	// older location is skipped
+	err := repo.LocatedZombie(context.Background(), zombieID, 10, 10, now.Add(-time.Minute).Format(time.RFC3339))
+	require.ErrorIs(t, err, zombie.StaleEvent)
+	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, true)
+
+	require.NoError(t, repo.CapturedZombie(context.Background(), zombieID, now.Format(time.RFC3339)))
+	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, false)
+	require.ErrorIs(t, repo.CapturedZombie(context.Background(), zombieID, now.Format(time.RFC3339)), zombie.StaleEvent)
+
+	// captured zombie keeps sending locations
+	require.NoError(t, repo.LocatedZombie(context.Background(), zombieID, 48.86, 2.3, now.Add(time.Minute).Format(time.RFC3339)))
+	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, false)
+
+	// capture without any known location
+	capturedID := uuid.New()
+	require.NoError(t, repo.CapturedZombie(context.Background(), capturedID, now.Format(time.RFC3339)))
+	require.NoError(t, repo.LocatedZombie(context.Background(), capturedID, 48.86, 2.3, now.Format(time.RFC3339)))
+	checkZombie(t, repo, capturedID, 48.872544, 2.332298, 5, false)
+}
+
+func TestMemoryZombie_LocatedZombies(t *testing.T) {
+	repo := zombie.NewMemoryRepository()
+	first, second := uuid.New(), uuid.New()
+	now := time.Now()
+
+	stale, err := repo.LocatedZombies(context.Background(), []zombie.LocationUpdate{
+		{ZombieId: first, Latitude: 48.86, Longitude: 2.3, UpdatedAt: now.Format(time.RFC3339)},
+		{ZombieId: first, Latitude: 10, Longitude: 10, UpdatedAt: now.Add(-time.Minute).Format(time.RFC3339)},
+		{ZombieId: second, Latitude: 48.86, Longitude: 2.3, UpdatedAt: now.Format(time.RFC3339)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, stale)
+	checkZombie(t, repo, first, 48.872544, 2.332298, 5, true)
+	checkZombie(t, repo, second, 48.872544, 2.332298, 5, true)
+
+	_, err = repo.LocatedZombies(context.Background(), []zombie.LocationUpdate{{ZombieId: first, UpdatedAt: "yesterday"}})
+	require.Error(t, err)
+}
+
+func TestMemoryZombie_LastUpdate(t *testing.T) {
+	repo := zombie.NewMemoryRepository()
+	lastUpdate, err := repo.LastUpdate(context.Background())
+	require.NoError(t, err)
+	require.True(t, lastUpdate.IsZero())
+
+	now := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, repo.LocatedZombie(context.Background(), uuid.New(), 48.86, 2.3, now.Format(time.RFC3339)))
+	require.NoError(t, repo.CapturedZombie(context.Background(), uuid.New(), now.Add(-time.Hour).Format(time.RFC3339)))
+	lastUpdate, err = repo.LastUpdate(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, now, lastUpdate)
+
+	require.NoError(t, repo.CapturedZombie(context.Background(), uuid.New(), now.Add(time.Minute).Format(time.RFC3339)))
+	lastUpdate, err = repo.LastUpdate(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, now.Add(time.Minute), lastUpdate)
+}
+
+func TestMemoryZombie_LocateZombieListNearest(t *testing.T) {
+	repo := zombie.NewMemoryRepository()
+	rnd := rand.New(rand.NewSource(1))
+	type point struct {
+		id       uuid.UUID
+		lat, lon float64
+	}
+	var points []point
+	for i := 0; i < 2000; i++ {
+		p := point{id: uuid.New(), lat: rnd.Float64()*180 - 90, lon: rnd.Float64()*360 - 180}
+		if i%2 == 0 {
+			// dense area around Paris
+			p.lat, p.lon = 48+rnd.Float64()*2, 2+rnd.Float64()*2
+		}
+		points = append(points, p)
+		require.NoError(t, repo.LocatedZombie(context.Background(), p.id, p.lat, p.lon, time.Now().Format(time.RFC3339)))
+	}
+
+	// nearest zombies are the same as found by the full scan, also across the antimeridian and near the poles
+	for _, origin := range [][2]float64{{48.872544, 2.332298}, {0, 179.9}, {89.9, 0}, {-89.5, 120}, {-48.876667, -123.393333}} {
+		for _, radiusKm := range []float64{0, 500} {
+			var expected []uuid.UUID
+			sort.Slice(points, func(i, j int) bool {
+				return distanceKm(origin[0], origin[1], points[i].lat, points[i].lon) <
+					distanceKm(origin[0], origin[1], points[j].lat, points[j].lon)
+			})
+			for _, p := range points[:20] {
+				if radiusKm == 0 || distanceKm(origin[0], origin[1], p.lat, p.lon) <= radiusKm {
+					expected = append(expected, p.id)
+				}
+			}
+
+			list, err := repo.LocateZombieList(context.Background(), origin[0], origin[1], 20, radiusKm)
+			require.NoError(t, err)
+			actual := make([]uuid.UUID, 0, len(list))
+			for _, l := range list {
+				actual = append(actual, l.ZombieId)
+			}
+			require.Equal(t, len(expected), len(actual), "origin %v radius %v", origin, radiusKm)
+			if len(expected) > 0 {
+				require.Equal(t, expected, actual, "origin %v radius %v", origin, radiusKm)
+			}
+		}
+	}
+
+	list, err := repo.LocateZombieList(context.Background(), 0, 0, 0, 0)
+	require.NoError(t, err)
+	require.Empty(t, list)
+}
+
+func TestMemoryZombie_ConcurrentAccess(t *testing.T) {
+	repo := zombie.NewMemoryRepository()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id := uuid.New()
+				require.NoError(t, repo.LocatedZombie(context.Background(), id, 48.86, 2.3, time.Now().Format(time.RFC3339)))
+				_, err := repo.LocateZombieList(context.Background(), 48.86, 2.3, 10, 0)
+				require.NoError(t, err)
+				require.NoError(t, repo.CapturedZombie(context.Background(), id, time.Now().Format(time.RFC3339)))
+			}
+		}()
+	}
+	wg.Wait()
+	list, err := repo.LocateZombieList(context.Background(), 48.86, 2.3, 10, 0)
+	require.NoError(t, err)
+	require.Empty(t, list)
+}
+
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	cos := math.Sin(lat1*rad)*math.Sin(lat2*rad) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Cos((lon2-lon1)*rad)
+	return 6371.0088 * math.Acos(math.Max(-1, math.Min(1, cos)))
+}
@@ -0,0 +1,148 @@
+package zombie
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"zombie_locator/internal/utils/failure"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// outboxBatchSize is the max number of outbox entries applied to tile38 per transaction.
+const outboxBatchSize = 500
+
+// enqueueOutbox records in the zombie update transaction that tile38 has to catch up with the zombies.
+func enqueueOutbox(ctx context.Context, tx *sqlx.Tx, zombieIDs ...uuid.UUID) error {
+	if len(zombieIDs) == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO zombies_outbox(zombie_id)
+		SELECT unnest($1::uuid[]);
+	`, pq.Array(uuidStrings(zombieIDs))); err != nil {
+		return storageError(fmt.Errorf("unable to enqueue zombie to outbox: %w", err))
+	}
+	return nil
+}
+
+// applyOutbox applies the just committed updates to tile38 right away, so the hunting list is fresh
+// in the common case. Failure is left to RelayOutbox and reported as the transient IndexDeferred,
+// so the breaker sees tile38 outage, while the caller knows the update itself is stored.
+func (z *Zombie) applyOutbox(ctx context.Context, zombieIDs ...uuid.UUID) error {
+	if len(zombieIDs) == 0 {
+		return nil
+	}
+	if _, err := z.relay(ctx, "apply_outbox", zombieIDs); err != nil {
+		return failure.Transient(fmt.Errorf("%w: %v", IndexDeferred, err))
+	}
+	return nil
+}
+
+// RelayOutbox applies the pending outbox entries to tile38 and returns the number of applied entries.
+// It is safe to run concurrently, entries taken by another relay are skipped.
+func (z *Zombie) RelayOutbox(ctx context.Context) (int, error) {
+	relayed := 0
+	for {
+		n, err := z.relay(ctx, "relay", nil)
+		relayed += n
+		if err != nil || n < outboxBatchSize {
+			return relayed, err
+		}
+	}
+}
+
+type outboxZombie struct {
+	ID        uuid.UUID `db:"id"`
+	Status    string    `db:"status"`
+	UpdatedAt time.Time `db:"updated_at"`
+	Latitude  float64   `db:"lat"`
+	Longitude float64   `db:"lon"`
+}
+
+// relay applies a batch of outbox entries, all entries when zombieIDs is empty. Entries only point to the
+// zombie, tile38 gets its current state read under the row lock, so applying is idempotent and
+// entries of the same zombie can be applied in any order. operation names the relay in metrics and traces.
+func (z *Zombie) relay(ctx context.Context, operation string, zombieIDs []uuid.UUID) (int, error) {
+	var entries []int64
+	err := z.withTx(ctx, operation, func(ctx context.Context, tx *sqlx.Tx) error {
+		var rows []struct {
+			ID       int64     `db:"id"`
+			ZombieID uuid.UUID `db:"zombie_id"`
+		}
+		var err error
+		if len(zombieIDs) == 0 {
+			err = tx.SelectContext(ctx, &rows, `
+				SELECT id, zombie_id FROM zombies_outbox
+				ORDER BY id
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED;
+			`, outboxBatchSize)
+		} else {
+			err = tx.SelectContext(ctx, &rows, `
+				SELECT id, zombie_id FROM zombies_outbox
+				WHERE zombie_id = ANY($1::uuid[])
+				ORDER BY id
+				FOR UPDATE SKIP LOCKED;
+			`, pq.Array(uuidStrings(zombieIDs)))
+		}
+		if err != nil {
+			return storageError(fmt.Errorf("unable to read outbox: %w", err))
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		statuses := make(map[uuid.UUID]string, len(rows))
+		for _, r := range rows {
+			entries = append(entries, r.ID)
+			// zombie unknown to postgres is removed from the hunting list
+			statuses[r.ZombieID] = ""
+		}
+		ids := make([]uuid.UUID, 0, len(statuses))
+		for id := range statuses {
+			ids = append(ids, id)
+		}
+
+		var stored []outboxZombie
+		if err = tx.SelectContext(ctx, &stored, `
+			SELECT id, status, COALESCE(updated_at, 'epoch') AS updated_at,
+				COALESCE(point[0], 0) AS lat, COALESCE(point[1], 0) AS lon
+			FROM zombies
+			WHERE id = ANY($1::uuid[])
+			ORDER BY id
+			FOR SHARE;
+		`, pq.Array(uuidStrings(ids))); err != nil {
+			return storageError(fmt.Errorf("unable to read outbox zombies: %w", err))
+		}
+		updates := make(map[uuid.UUID]locationUpdate, len(stored))
+		for _, s := range stored {
+			updates[s.ID] = locationUpdate{
+				LocationUpdate: LocationUpdate{ZombieId: s.ID, Latitude: s.Latitude, Longitude: s.Longitude},
+				updatedAt:      s.UpdatedAt,
+			}
+			statuses[s.ID] = s.Status
+		}
+		if err = z.indexZombies(ctx, updates, statuses); err != nil {
+			return err
+		}
+
+		if _, err = tx.ExecContext(ctx, `DELETE FROM zombies_outbox WHERE id = ANY($1);`, pq.Array(entries)); err != nil {
+			return storageError(fmt.Errorf("unable to delete outbox entries: %w", err))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to relay outbox: %w", err)
+	}
+	return len(entries), nil
+}
+
+func uuidStrings(ids []uuid.UUID) []string {
+	res := make([]string, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, id.String())
+	}
+	return res
+}
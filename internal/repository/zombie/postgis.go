@@ -0,0 +1,166 @@
+package zombie
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"zombie_locator/internal/storage/db"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// PostgisZombie keeps zombies and serves the nearest ones from postgres only, using the PostGIS extension.
+// Location is stored both as the geography column for search and as the plain point, which tile38 is
+// rehydrated from, so the deployment can be switched to tile38. The tile38 storage keeps only the point,
+// so the geography column has to be rebuilt by RebuildLocations before the deployment is switched back.
+type PostgisZombie struct {
+	dbConnect db.Connector
+}
+
+func NewPostgisRepository(dbConnect db.Connector) *PostgisZombie {
+	return &PostgisZombie{
+		dbConnect: dbConnect,
+	}
+}
+
+// CapturedZombie marks the zombie as captured, it is not returned by the search anymore.
+// Capture of already captured zombie is skipped with StaleEvent error.
+func (z *PostgisZombie) CapturedZombie(ctx context.Context, zombieID uuid.UUID, updatedAt string) error {
+	data, err := parseUpdatedAt(updatedAt)
+	if err != nil {
+		return err
+	}
+	var id uuid.UUID
+	err = z.dbConnect.Client().QueryRowxContext(ctx, `
+		INSERT INTO zombies(id, updated_at, status)
+		VALUES($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET updated_at = GREATEST(zombies.updated_at, EXCLUDED.updated_at), status = EXCLUDED.status
+		WHERE zombies.status IS DISTINCT FROM EXCLUDED.status
+		RETURNING id;
+	`, zombieID, data, capturedStatus).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return StaleEvent
+	}
+	if err != nil {
+		return storageError(fmt.Errorf("unable to capture zombie: %w", err))
+	}
+	return nil
+}
+
+// LocatedZombie stores the last known zombie location, captured zombie stays captured.
+// Location older than the stored zombie state is skipped with StaleEvent error.
+func (z *PostgisZombie) LocatedZombie(ctx context.Context, zombieID uuid.UUID, lat, lon float64, updatedAt string) error {
+	data, err := parseUpdatedAt(updatedAt)
+	if err != nil {
+		return err
+	}
+	var id uuid.UUID
+	err = z.dbConnect.Client().QueryRowxContext(ctx, `
+		INSERT INTO zombies(id, updated_at, point, location, status)
+		VALUES($1, $2, point($3, $4), ST_SetSRID(ST_MakePoint($4, $3), 4326)::geography, $5)
+		ON CONFLICT (id) DO UPDATE SET updated_at = EXCLUDED.updated_at, point = EXCLUDED.point, location = EXCLUDED.location
+		WHERE zombies.updated_at IS NULL OR zombies.updated_at <= EXCLUDED.updated_at
+		RETURNING id;
+	`, zombieID, data, lat, lon, locatedStatus).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return StaleEvent
+	}
+	if err != nil {
+		return storageError(fmt.Errorf("unable to locate zombie: %w", err))
+	}
+	return nil
+}
+
+// LocatedZombies stores the batch of locations with a single upsert, only the latest location of each zombie is kept.
+// It returns the number of stale locations skipped.
+func (z *PostgisZombie) LocatedZombies(ctx context.Context, updates []LocationUpdate) (int, error) {
+	b, err := newLocationBatch(updates)
+	if err != nil || len(b.ids) == 0 {
+		return 0, err
+	}
+
+	var stored []uuid.UUID
+	if err = z.dbConnect.Client().SelectContext(ctx, &stored, `
+		INSERT INTO zombies(id, updated_at, point, location, status)
+		SELECT u.id, u.updated_at, point(u.lat, u.lon), ST_SetSRID(ST_MakePoint(u.lon, u.lat), 4326)::geography, $5
+		FROM unnest($1::uuid[], $2::timestamp[], $3::float8[], $4::float8[]) AS u(id, updated_at, lat, lon)
+		ORDER BY u.id
+		ON CONFLICT (id) DO UPDATE SET updated_at = EXCLUDED.updated_at, point = EXCLUDED.point, location = EXCLUDED.location
+		WHERE zombies.updated_at IS NULL OR zombies.updated_at <= EXCLUDED.updated_at
+		RETURNING id;
+	`, pq.Array(b.ids), pq.Array(b.times), pq.Array(b.lats), pq.Array(b.lons), locatedStatus); err != nil {
+		return 0, storageError(fmt.Errorf("unable to locate zombies: %w", err))
+	}
+	return len(updates) - len(stored), nil
+}
+
+// LocateZombieList returns up to limit located zombies nearest to the given point, ordered by distance.
+// Candidates are found with the index assisted KNN ordering, then ordered by the exact distance.
+func (z *PostgisZombie) LocateZombieList(ctx context.Context, lat, lon float64, limit int, radiusKm float64) ([]Location, error) {
+	if limit <= 0 {
+		return []Location{}, nil
+	}
+	var rows []struct {
+		ID        uuid.UUID `db:"id"`
+		Latitude  float64   `db:"lat"`
+		Longitude float64   `db:"lon"`
+	}
+	err := z.dbConnect.Client().SelectContext(ctx, &rows, `
+		SELECT id, lat, lon FROM (
+			SELECT z.id, ST_Y(z.location::geometry) AS lat, ST_X(z.location::geometry) AS lon,
+				ST_Distance(z.location, o.origin) AS distance
+			FROM zombies z, (SELECT ST_SetSRID(ST_MakePoint($2, $1), 4326)::geography AS origin) o
+			WHERE z.status = $3 AND z.location IS NOT NULL
+				AND ($4::float8 <= 0 OR ST_DWithin(z.location, o.origin, $4::float8))
+			ORDER BY z.location <-> o.origin
+			LIMIT $5
+		) nearest
+		ORDER BY distance, id;
+	`, lat, lon, locatedStatus, radiusKm*1000, limit)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get nearby zombies: %w", err)
+	}
+	result := make([]Location, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, Location{
+			ZombieId:  r.ID,
+			Latitude:  r.Latitude,
+			Longitude: r.Longitude,
+		})
+	}
+	return result, nil
+}
+
+// RebuildLocations copies the point of each zombie to the geography column, where they differ, e.g. the zombie
+// was located while the deployment used tile38. It returns the number of rebuilt locations.
+func (z *PostgisZombie) RebuildLocations(ctx context.Context) (int, error) {
+	res, err := z.dbConnect.Client().ExecContext(ctx, `
+		UPDATE zombies SET location = ST_SetSRID(ST_MakePoint(point[1], point[0]), 4326)::geography
+		WHERE point IS NOT NULL
+			AND (location IS NULL OR ST_Y(location::geometry) <> point[0] OR ST_X(location::geometry) <> point[1]);
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("unable to rebuild zombie locations: %w", err)
+	}
+	rebuilt, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("unable to count rebuilt zombie locations: %w", err)
+	}
+	return int(rebuilt), nil
+}
+
+// Ping checks that postgres is reachable.
+func (z *PostgisZombie) Ping(ctx context.Context) error {
+	if err := z.dbConnect.Client().PingContext(ctx); err != nil {
+		return fmt.Errorf("postgres is not reachable: %w", err)
+	}
+	return nil
+}
+
+// LastUpdate returns the time of the newest stored event, zero time when nothing is stored yet.
+func (z *PostgisZombie) LastUpdate(ctx context.Context) (time.Time, error) {
+	return lastUpdate(ctx, z.dbConnect)
+}
@@ -0,0 +1,92 @@
+package zombie_test
+
+import (
+	"context"
+	"testing"
+	"time"
+	"zombie_locator/internal/repository/zombie"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgisZombie_LocatedAndCapturedZombie(t *testing.T) {
+	pgConnect := postgresOrSkip(t)
+
+	repo := zombie.NewPostgisRepository(pgConnect)
+
+	zombieID := uuid.New()
+	now := time.Now()
+	require.NoError(t, repo.LocatedZombie(context.Background(), zombieID, 48.85905, 2.294533, now.Format(time.RFC3339)))
+	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, true)
+
+	// older location is skipped
+	err := repo.LocatedZombie(context.Background(), zombieID, 10, 10, now.Add(-time.Minute).Format(time.RFC3339))
+	require.ErrorIs(t, err, zombie.StaleEvent)
+	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, true)
+
+	require.NoError(t, repo.CapturedZombie(context.Background(), zombieID, now.Format(time.RFC3339)))
+	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, false)
+	require.ErrorIs(t, repo.CapturedZombie(context.Background(), zombieID, now.Format(time.RFC3339)), zombie.StaleEvent)
+
+	// captured zombie keeps sending locations
+	require.NoError(t, repo.LocatedZombie(context.Background(), zombieID, 48.86, 2.3, time.Now().Add(time.Second).Format(time.RFC3339)))
+	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, false)
+	checkStoredZombie(t, pgConnect, zombieID, "captured", true)
+}
+
+func TestPostgisZombie_LocateZombieListNearest(t *testing.T) {
+	pgConnect := postgresOrSkip(t)
+
+	repo := zombie.NewPostgisRepository(pgConnect)
+
+	// isolated spot in the middle of the ocean, so other zombies do not interfere
+	lat, lon := -48.876667, -123.393333
+	nearest, middle, farthest := uuid.New(), uuid.New(), uuid.New()
+	stale, err := repo.LocatedZombies(context.Background(), []zombie.LocationUpdate{
+		{ZombieId: farthest, Latitude: lat + 0.03, Longitude: lon, UpdatedAt: time.Now().Format(time.RFC3339)},
+		{ZombieId: nearest, Latitude: lat + 0.5, Longitude: lon, UpdatedAt: time.Now().Add(-time.Minute).Format(time.RFC3339)},
+		{ZombieId: nearest, Latitude: lat + 0.01, Longitude: lon, UpdatedAt: time.Now().Format(time.RFC3339)},
+		{ZombieId: middle, Latitude: lat + 0.02, Longitude: lon, UpdatedAt: time.Now().Format(time.RFC3339)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, stale)
+	t.Cleanup(func() {
+		// remove zombies from the hunting list, so next runs start from the clean state
+		for _, id := range []uuid.UUID{nearest, middle, farthest} {
+			require.NoError(t, repo.CapturedZombie(context.Background(), id, time.Now().Format(time.RFC3339)))
+		}
+	})
+
+	list, err := repo.LocateZombieList(context.Background(), lat, lon, 2, 0)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, nearest, list[0].ZombieId)
+	require.Equal(t, middle, list[1].ZombieId)
+	require.InDelta(t, lat+0.01, list[0].Latitude, 0.000001)
+	require.InDelta(t, lon, list[0].Longitude, 0.000001)
+
+	// radius excludes the farthest ones
+	list, err = repo.LocateZombieList(context.Background(), lat, lon, 10, 1.5)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, nearest, list[0].ZombieId)
+}
+
+func TestPostgisZombie_RebuildLocations(t *testing.T) {
+	pgConnect := postgresOrSkip(t)
+	repo := zombie.NewPostgisRepository(pgConnect)
+
+	// zombie located while the deployment used tile38 has no geography location
+	zombieID := uuid.New()
+	_, err := pgConnect.Client().ExecContext(context.Background(), `
+		INSERT INTO zombies(id, updated_at, point, status) VALUES($1, now(), point(48.85905, 2.294533), 'located');
+	`, zombieID)
+	require.NoError(t, err)
+	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, false)
+
+	rebuilt, err := repo.RebuildLocations(context.Background())
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, rebuilt, 1)
+	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, true)
+}
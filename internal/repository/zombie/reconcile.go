@@ -0,0 +1,165 @@
+package zombie
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/xjem/t38c"
+)
+
+// reconcileBatchSize is the number of zombies compared between postgres and tile38 at once.
+const reconcileBatchSize = 1000
+
+// Reconcile compares the hunting list in tile38 with postgres and enqueues the drifted zombies
+// to the outbox, so RelayOutbox repairs them. It returns the number of drifted zombies.
+func (z *Zombie) Reconcile(ctx context.Context) (int, error) {
+	missing, err := z.reconcileStored(ctx)
+	if err != nil {
+		return 0, err
+	}
+	extra, err := z.reconcileIndexed(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return missing + extra, nil
+}
+
+// reconcileStored finds located zombies which are missing in tile38 or have a different location there.
+func (z *Zombie) reconcileStored(ctx context.Context) (int, error) {
+	drifted := 0
+	after := uuid.Nil
+	for {
+		var batch []storedZombie
+		if err := z.dbConnect.Client().SelectContext(ctx, &batch, `
+			SELECT id, status, updated_at, point[0] AS lat, point[1] AS lon
+			FROM zombies
+			WHERE id > $1 AND status = $2 AND point IS NOT NULL
+			ORDER BY id
+			LIMIT $3;
+		`, after, locatedStatus, reconcileBatchSize); err != nil {
+			return drifted, storageError(fmt.Errorf("unable to load zombies: %w", err))
+		}
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			firstErr error
+			ids      []uuid.UUID
+		)
+		sem := make(chan struct{}, t38WriteConcurrency)
+		for _, s := range batch {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(s storedZombie) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				ok, err := z.isIndexed(s)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if err == nil && !ok {
+					ids = append(ids, s.ID)
+				}
+			}(s)
+		}
+		wg.Wait()
+		if firstErr != nil {
+			return drifted, firstErr
+		}
+		if err := z.enqueueDrifted(ctx, ids); err != nil {
+			return drifted, err
+		}
+		drifted += len(ids)
+		if len(batch) < reconcileBatchSize {
+			return drifted, nil
+		}
+		after = batch[len(batch)-1].ID
+	}
+}
+
+// isIndexed reports whether tile38 keeps the zombie with the same update time as postgres.
+func (z *Zombie) isIndexed(s storedZombie) (bool, error) {
+	res, err := z.t38Connect.Keys.Get(t38Key, s.ID.String()).WithFields().Point()
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to get zombie from tile38: %w", err)
+	}
+	return res.Fields[t38UpdatedAtField] == float64(s.UpdatedAt.UnixMilli()), nil
+}
+
+// reconcileIndexed finds zombies in tile38 which are not located in postgres, e.g. captured ones.
+func (z *Zombie) reconcileIndexed(ctx context.Context) (int, error) {
+	drifted := 0
+	cursor := 0
+	for {
+		res, err := z.t38Connect.Search.Scan(t38Key).
+			Cursor(cursor).
+			Limit(reconcileBatchSize).
+			Format(t38c.FormatIDs).
+			Do()
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				// nothing is indexed yet
+				return drifted, nil
+			}
+			return drifted, fmt.Errorf("unable to scan tile38 zombies: %w", err)
+		}
+		indexed := make([]uuid.UUID, 0, len(res.IDs))
+		for _, id := range res.IDs {
+			zombieID, err := uuid.Parse(id)
+			if err != nil {
+				// not a zombie at all, nothing to repair it from
+				if err = z.t38Connect.Keys.Del(t38Key, id); err != nil {
+					return drifted, fmt.Errorf("unable to delete zombie from tile38: %w", err)
+				}
+				drifted++
+				continue
+			}
+			indexed = append(indexed, zombieID)
+		}
+		var located []uuid.UUID
+		if err = z.dbConnect.Client().SelectContext(ctx, &located, `
+			SELECT id FROM zombies
+			WHERE id = ANY($1::uuid[]) AND status = $2 AND point IS NOT NULL;
+		`, pq.Array(uuidStrings(indexed)), locatedStatus); err != nil {
+			return drifted, storageError(fmt.Errorf("unable to load indexed zombies: %w", err))
+		}
+		isLocated := make(map[uuid.UUID]struct{}, len(located))
+		for _, id := range located {
+			isLocated[id] = struct{}{}
+		}
+		var ids []uuid.UUID
+		for _, id := range indexed {
+			if _, ok := isLocated[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		if err = z.enqueueDrifted(ctx, ids); err != nil {
+			return drifted, err
+		}
+		drifted += len(ids)
+		if res.Cursor == 0 {
+			return drifted, nil
+		}
+		cursor = res.Cursor
+	}
+}
+
+func (z *Zombie) enqueueDrifted(ctx context.Context, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return z.withTx(ctx, "enqueue_drifted", func(ctx context.Context, tx *sqlx.Tx) error {
+		return enqueueOutbox(ctx, tx, ids...)
+	})
+}
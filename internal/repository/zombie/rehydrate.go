@@ -0,0 +1,96 @@
+package zombie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"zombie_locator/internal/utils/failure"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// rehydrateBatchSize is the number of zombies loaded to tile38 per transaction.
+const rehydrateBatchSize = 1000
+
+// NotRehydrated is returned while tile38 is not loaded from postgres, e.g. after tile38 data loss.
+var NotRehydrated = errors.New("tile38 is not rehydrated")
+
+type storedZombie struct {
+	ID        uuid.UUID `db:"id"`
+	Status    string    `db:"status"`
+	UpdatedAt time.Time `db:"updated_at"`
+	Latitude  float64   `db:"lat"`
+	Longitude float64   `db:"lon"`
+}
+
+// Rehydrate loads not captured zombies from postgres to tile38, so the hunting list survives tile38 data loss.
+// Zombies are loaded in batches by id, rows of the batch are locked while they are written to tile38,
+// so concurrent updates are not overwritten by the older state. Once all of them are loaded, tile38 is marked
+// as rehydrated. It returns the number of loaded zombies.
+func (z *Zombie) Rehydrate(ctx context.Context) (int, error) {
+	loaded := 0
+	after := uuid.Nil
+	for {
+		var batch []storedZombie
+		err := z.withTx(ctx, "rehydrate", func(ctx context.Context, tx *sqlx.Tx) error {
+			if err := tx.SelectContext(ctx, &batch, `
+				SELECT id, status, updated_at, point[0] AS lat, point[1] AS lon
+				FROM zombies
+				WHERE id > $1 AND status IS DISTINCT FROM $2 AND point IS NOT NULL
+				ORDER BY id
+				LIMIT $3
+				FOR SHARE;
+			`, after, capturedStatus, rehydrateBatchSize); err != nil {
+				return storageError(fmt.Errorf("unable to load zombies: %w", err))
+			}
+			updates := make(map[uuid.UUID]locationUpdate, len(batch))
+			statuses := make(map[uuid.UUID]string, len(batch))
+			for _, s := range batch {
+				updates[s.ID] = locationUpdate{
+					LocationUpdate: LocationUpdate{ZombieId: s.ID, Latitude: s.Latitude, Longitude: s.Longitude},
+					updatedAt:      s.UpdatedAt,
+				}
+				statuses[s.ID] = s.Status
+			}
+			return z.indexZombies(ctx, updates, statuses)
+		})
+		if err != nil {
+			return loaded, fmt.Errorf("unable to rehydrate zombies after %s: %w", after, err)
+		}
+		loaded += len(batch)
+		if len(batch) < rehydrateBatchSize {
+			return loaded, z.markRehydrated(time.Now())
+		}
+		after = batch[len(batch)-1].ID
+	}
+}
+
+// Rehydrated returns the time tile38 was rehydrated at. The mark is kept in tile38 itself,
+// so NotRehydrated is returned after tile38 data loss until it is rehydrated again.
+func (z *Zombie) Rehydrated(ctx context.Context) (time.Time, error) {
+	res, err := z.t38Connect.Keys.Get(t38MetaKey, t38RehydratedID).Object()
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return time.Time{}, NotRehydrated
+		}
+		return time.Time{}, fmt.Errorf("unable to get rehydration mark from tile38: %w", err)
+	}
+	if res.Object.String == nil {
+		return time.Time{}, fmt.Errorf("%w: rehydration mark is not a string", NotRehydrated)
+	}
+	rehydratedAt, err := time.Parse(time.RFC3339Nano, *res.Object.String)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: unable to parse rehydration mark: %v", NotRehydrated, err)
+	}
+	return rehydratedAt, nil
+}
+
+func (z *Zombie) markRehydrated(at time.Time) error {
+	if err := z.t38Connect.Keys.Set(t38MetaKey, t38RehydratedID).String(at.UTC().Format(time.RFC3339Nano)).Do(); err != nil {
+		return failure.Transient(fmt.Errorf("unable to mark tile38 as rehydrated: %w", err))
+	}
+	return nil
+}
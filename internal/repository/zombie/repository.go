@@ -2,99 +2,422 @@ package zombie
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"sync"
 	"time"
+	"zombie_locator/internal/metrics"
 	"zombie_locator/internal/storage/db"
+	"zombie_locator/internal/tracing"
+	"zombie_locator/internal/utils/failure"
 
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/xjem/t38c"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/google/uuid"
 )
 
 const (
 	t38Key         = "zombies"
-	capturedStatus = "status"
+	capturedStatus = "captured"
 	locatedStatus  = "located"
+
+	// t38MetaKey keeps the state of the index itself, apart from zombies.
+	t38MetaKey = "zombies_meta"
+	// t38RehydratedID keeps the time the index was rehydrated, it is lost along with the tile38 data.
+	t38RehydratedID = "rehydrated"
+
+	// t38UpdatedAtField keeps the event time (unix milliseconds) of the stored zombie location.
+	t38UpdatedAtField = "updated_at"
+
+	// postgres error classes caused by the data itself, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+	dataExceptionClass      = "22"
+	integrityViolationClass = "23"
+
+	// t38WriteConcurrency bounds the concurrent tile38 writes of a batch. Each zombie is still a separate SET,
+	// the radix pool behind t38c only batches the commands issued within its pipeline window into one write.
+	t38WriteConcurrency = 16
+	// pgTimestampLayout formats event time for the timestamp array parameter.
+	pgTimestampLayout = "2006-01-02 15:04:05.999999"
+
+	postgresBackend = "postgres"
+	tile38Backend   = "tile38"
+
+	// maxSearchRadiusMeters is half of the earth circumference, any point on the globe is within it.
+	maxSearchRadiusMeters = 20_037_508.0
 )
 
+var tracer = otel.Tracer("zombie_locator/internal/repository/zombie")
+
 type Zombie struct {
 	dbConnect  db.Connector
 	t38Connect *t38c.Client
+	metrics    *metrics.Storage
 }
 
-func NewZombieRepository(dbConnect db.Connector, connect *t38c.Client) *Zombie {
-	// TODO load from postgres to tile38 actual zombie list on server start
+// NewZombieRepository sets up the repository, use Rehydrate to load the hunting list to the empty tile38.
+// storageMetrics records the postgres and tile38 operations, optional.
+func NewZombieRepository(dbConnect db.Connector, connect *t38c.Client, storageMetrics *metrics.Storage) *Zombie {
+	if storageMetrics == nil {
+		storageMetrics = metrics.NewStorage(nil)
+	}
 	return &Zombie{
 		t38Connect: connect,
 		dbConnect:  dbConnect,
+		metrics:    storageMetrics,
 	}
 }
 
+// CapturedZombie marks the zombie as captured. Capture is a terminal state: the zombie is removed from
+// the hunting list and never comes back, even if it was not located before.
+// Capture of already captured zombie is skipped with StaleEvent error.
 func (z *Zombie) CapturedZombie(ctx context.Context, zombieID uuid.UUID, updatedAt string) error {
-	data, err := time.Parse(time.RFC3339, updatedAt)
+	data, err := parseUpdatedAt(updatedAt)
 	if err != nil {
-		return fmt.Errorf("unable to parse time: %w", err)
-	}
-	if _, err = z.dbConnect.Client().NamedExec(`
-		INSERT INTO zombies(id, updated_at, status)
-		VALUES(:id, :date, :status)
-		ON CONFLICT (id) DO UPDATE SET updated_at = :date, status = :status;
-	`, map[string]interface{}{
-		"id":     zombieID,
-		"date":   data,
-		"status": capturedStatus,
-	}); err != nil {
-		return fmt.Errorf("unable to capture zombie: %w", err)
-	}
-	if err = z.t38Connect.Keys.Del(t38Key, zombieID.String()); err != nil {
-		return fmt.Errorf("unable to delete zombie from tile38: %w", err)
+		return err
 	}
-	return nil
+	err = z.withTx(ctx, "capture", func(ctx context.Context, tx *sqlx.Tx) error {
+		// capture is applied whatever the event time is, but updated_at never goes back.
+		var id uuid.UUID
+		err = tx.QueryRowxContext(ctx, `
+			INSERT INTO zombies(id, updated_at, status)
+			VALUES($1, $2, $3)
+			ON CONFLICT (id) DO UPDATE SET updated_at = GREATEST(zombies.updated_at, EXCLUDED.updated_at), status = EXCLUDED.status
+			WHERE zombies.status IS DISTINCT FROM EXCLUDED.status
+			RETURNING id;
+		`, zombieID, data, capturedStatus).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return StaleEvent
+		}
+		if err != nil {
+			return storageError(fmt.Errorf("unable to capture zombie: %w", err))
+		}
+		return enqueueOutbox(ctx, tx, zombieID)
+	})
+	if err != nil {
+		return err
+	}
+	return z.applyOutbox(ctx, zombieID)
 }
 
+// LocatedZombie stores the last known zombie location. Locations of captured zombies are recorded,
+// but the zombie is kept out of the hunting list.
+// Location older than the stored zombie state is skipped with StaleEvent error.
 func (z *Zombie) LocatedZombie(ctx context.Context, zombieID uuid.UUID, lat, lon float64, updatedAt string) error {
-	data, err := time.Parse(time.RFC3339, updatedAt)
+	data, err := parseUpdatedAt(updatedAt)
+	if err != nil {
+		return err
+	}
+	err = z.withTx(ctx, "locate", func(ctx context.Context, tx *sqlx.Tx) error {
+		// status is not updated on conflict, so the captured zombie stays captured.
+		var id uuid.UUID
+		err = tx.QueryRowxContext(ctx, `
+			INSERT INTO zombies(id, updated_at, point, status)
+			VALUES($1, $2, point($3, $4), $5)
+			ON CONFLICT (id) DO UPDATE SET updated_at = EXCLUDED.updated_at, point = EXCLUDED.point
+			WHERE zombies.updated_at IS NULL OR zombies.updated_at <= EXCLUDED.updated_at
+			RETURNING id;
+		`, zombieID, data, lat, lon, locatedStatus).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return StaleEvent
+		}
+		if err != nil {
+			return storageError(fmt.Errorf("unable to locate zombie: %w", err))
+		}
+		return enqueueOutbox(ctx, tx, zombieID)
+	})
+	if err != nil {
+		return err
+	}
+	return z.applyOutbox(ctx, zombieID)
+}
+
+// LocatedZombies stores the batch of locations with a single upsert. Locations are collapsed to the latest
+// one per zombie, older ones and the ones older than the stored state are counted as stale.
+func (z *Zombie) LocatedZombies(ctx context.Context, updates []LocationUpdate) (int, error) {
+	b, err := newLocationBatch(updates)
+	if err != nil || len(b.ids) == 0 {
+		return 0, err
+	}
+
+	var stored []uuid.UUID
+	err = z.withTx(ctx, "locate_batch", func(ctx context.Context, tx *sqlx.Tx) error {
+		if err := tx.SelectContext(ctx, &stored, `
+			INSERT INTO zombies(id, updated_at, point, status)
+			SELECT u.id, u.updated_at, point(u.lat, u.lon), $5
+			FROM unnest($1::uuid[], $2::timestamp[], $3::float8[], $4::float8[]) AS u(id, updated_at, lat, lon)
+			ORDER BY u.id
+			ON CONFLICT (id) DO UPDATE SET updated_at = EXCLUDED.updated_at, point = EXCLUDED.point
+			WHERE zombies.updated_at IS NULL OR zombies.updated_at <= EXCLUDED.updated_at
+			RETURNING id;
+		`, pq.Array(b.ids), pq.Array(b.times), pq.Array(b.lats), pq.Array(b.lons), locatedStatus); err != nil {
+			return storageError(fmt.Errorf("unable to locate zombies: %w", err))
+		}
+		return enqueueOutbox(ctx, tx, stored...)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(updates) - len(stored), z.applyOutbox(ctx, stored...)
+}
+
+// locationBatch keeps the batch of locations collapsed to the latest one per zombie,
+// as the upsert parameters ordered by zombie id.
+type locationBatch struct {
+	ids   []string
+	times []string
+	lats  []float64
+	lons  []float64
+}
+
+func newLocationBatch(updates []LocationUpdate) (locationBatch, error) {
+	latest := make(map[uuid.UUID]locationUpdate, len(updates))
+	for _, u := range updates {
+		data, err := parseUpdatedAt(u.UpdatedAt)
+		if err != nil {
+			return locationBatch{}, err
+		}
+		// on equal time the later event wins, as it does for the separate updates
+		if prev, ok := latest[u.ZombieId]; ok && prev.updatedAt.After(data) {
+			continue
+		}
+		latest[u.ZombieId] = locationUpdate{LocationUpdate: u, updatedAt: data}
+	}
+
+	// rows are locked in the id order, so concurrent batches do not deadlock
+	b := locationBatch{ids: make([]string, 0, len(latest))}
+	for id := range latest {
+		b.ids = append(b.ids, id.String())
+	}
+	sort.Strings(b.ids)
+	b.times = make([]string, 0, len(b.ids))
+	b.lats = make([]float64, 0, len(b.ids))
+	b.lons = make([]float64, 0, len(b.ids))
+	for _, id := range b.ids {
+		u := latest[uuid.MustParse(id)]
+		b.times = append(b.times, u.updatedAt.Format(pgTimestampLayout))
+		b.lats = append(b.lats, u.Latitude)
+		b.lons = append(b.lons, u.Longitude)
+	}
+	return b, nil
+}
+
+type locationUpdate struct {
+	LocationUpdate
+	updatedAt time.Time
+}
+
+// indexZombies writes the zombies to tile38 concurrently, so the writes share the connections instead of waiting each other.
+// The writes are traced by the single span, rehydration writes too many zombies for the span per write.
+func (z *Zombie) indexZombies(ctx context.Context, updates map[uuid.UUID]locationUpdate, statuses map[uuid.UUID]string) (err error) {
+	_, end := z.startSpan(ctx, tile38Backend, "index", attribute.Int("zombies", len(statuses)))
+	defer func() { end(err) }()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, t38WriteConcurrency)
+	for id, status := range statuses {
+		u := updates[id]
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(id uuid.UUID, status string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := z.indexZombie(id, status, u.Latitude, u.Longitude, u.updatedAt); err != nil {
+				once.Do(func() { firstErr = err })
+			}
+		}(id, status)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// indexZombie puts the located zombie to the hunting list, captured or unknown one is kept out of it.
+func (z *Zombie) indexZombie(zombieID uuid.UUID, status string, lat, lon float64, updatedAt time.Time) error {
+	startedAt := time.Now()
+	if status != locatedStatus {
+		err := z.t38Connect.Keys.Del(t38Key, zombieID.String())
+		z.observe(tile38Backend, "del", startedAt, err)
+		if err != nil {
+			return failure.Transient(fmt.Errorf("unable to delete zombie from tile38: %w", err))
+		}
+		return nil
+	}
+	err := z.t38Connect.Keys.Set(t38Key, zombieID.String()).
+		Point(lat, lon).
+		Field(t38UpdatedAtField, float64(updatedAt.UnixMilli())).
+		Do()
+	z.observe(tile38Backend, "set", startedAt, err)
 	if err != nil {
-		return fmt.Errorf("unable to parse time: %w", err)
-	}
-	if _, err = z.dbConnect.Client().NamedExec(`
-		INSERT INTO zombies(id, updated_at, point, status)
-		VALUES(:id, :date, point(:lat, :lon), :status)
-		ON CONFLICT (id) DO UPDATE SET updated_at = :date, point = point(:lat, :lon), status = :status;
-	`, map[string]interface{}{
-		"id":     zombieID,
-		"date":   data,
-		"lat":    lat,
-		"lon":    lon,
-		"status": locatedStatus,
-	}); err != nil {
-		return fmt.Errorf("unable to locate zombie: %w", err)
-	}
-	if err = z.t38Connect.Keys.Set(t38Key, zombieID.String()).Point(lat, lon).Do(); err != nil {
-		return fmt.Errorf("unable to save zombie to tile38: %w", err)
+		return failure.Transient(fmt.Errorf("unable to save zombie to tile38: %w", err))
 	}
 	return nil
 }
 
-func (z *Zombie) LocateZombieList(ctx context.Context, lat, lon, limitKm float64) ([]Location, error) {
-	nearbyRes, err := z.t38Connect.Search.Nearby(t38Key, lat, lon, limitKm*1000).Do()
+func (z *Zombie) LocateZombieList(ctx context.Context, lat, lon float64, limit int, radiusKm float64) ([]Location, error) {
+	if limit <= 0 {
+		return []Location{}, nil
+	}
+	radius := maxSearchRadiusMeters
+	if radiusKm > 0 {
+		radius = radiusKm * 1000
+	}
+	// NEARBY with LIMIT uses the KNN algorithm, so tile38 returns the closest objects first.
+	_, end := z.startSpan(ctx, tile38Backend, "nearby")
+	startedAt := time.Now()
+	nearbyRes, err := z.t38Connect.Search.Nearby(t38Key, lat, lon, radius).
+		Limit(limit).
+		Distance().
+		Format(t38c.FormatPoints).
+		Do()
+	z.observe(tile38Backend, "nearby", startedAt, err)
+	end(err)
 	if err != nil {
 		return nil, fmt.Errorf("unable to get nearby zombies: %w", err)
 	}
-	if nearbyRes.Count == 0 {
+	if len(nearbyRes.Points) == 0 {
 		return []Location{}, nil
 	}
-	result := make([]Location, 0, nearbyRes.Count)
-	for i := range nearbyRes.Objects {
-		zID, err := uuid.Parse(nearbyRes.Objects[i].ID)
+	points := nearbyRes.Points
+	// ordering is part of the contract, do not rely on the storage for it.
+	sort.SliceStable(points, func(i, j int) bool {
+		return distanceOf(points[i].Distance) < distanceOf(points[j].Distance)
+	})
+	result := make([]Location, 0, len(points))
+	for i := range points {
+		zID, err := uuid.Parse(points[i].ID)
 		if err != nil {
 			return nil, fmt.Errorf("unable to parse uuid: %w", err)
 		}
 		result = append(result, Location{
 			ZombieId:  zID,
-			Latitude:  nearbyRes.Objects[i].Object.Geometry.Point[0],
-			Longitude: nearbyRes.Objects[i].Object.Geometry.Point[0],
+			Latitude:  points[i].Point.Lat,
+			Longitude: points[i].Point.Lon,
 		})
+		if len(result) == limit {
+			break
+		}
 	}
 	return result, nil
 }
+
+func distanceOf(distance *float64) float64 {
+	if distance == nil {
+		return math.MaxFloat64
+	}
+	return *distance
+}
+
+// Ping checks that both postgres and tile38 are reachable.
+func (z *Zombie) Ping(ctx context.Context) error {
+	if err := z.dbConnect.Client().PingContext(ctx); err != nil {
+		return fmt.Errorf("postgres is not reachable: %w", err)
+	}
+	if err := z.t38Connect.Ping(); err != nil {
+		return fmt.Errorf("tile38 is not reachable: %w", err)
+	}
+	return nil
+}
+
+// LastUpdate returns the time of the newest stored event, zero time when nothing is stored yet.
+func (z *Zombie) LastUpdate(ctx context.Context) (updatedAt time.Time, err error) {
+	ctx, end := z.startSpan(ctx, postgresBackend, "last_update")
+	defer func(startedAt time.Time) {
+		z.observe(postgresBackend, "last_update", startedAt, err)
+		end(err)
+	}(time.Now())
+	return lastUpdate(ctx, z.dbConnect)
+}
+
+// withTx runs fn inside a transaction. Tile38 is updated while the zombie row is locked by the transaction,
+// so concurrent updates of the same zombie reach tile38 in the same order as postgres and the last write wins in both.
+// The transaction is recorded as the postgres operation, including the tile38 writes made under the lock.
+// fn gets ctx with the span of the transaction.
+func (z *Zombie) withTx(ctx context.Context, operation string, fn func(ctx context.Context, tx *sqlx.Tx) error) (err error) {
+	ctx, end := z.startSpan(ctx, postgresBackend, operation)
+	defer func(startedAt time.Time) {
+		z.observe(postgresBackend, operation, startedAt, err)
+		end(err)
+	}(time.Now())
+	tx, err := z.dbConnect.Client().BeginTxx(ctx, nil)
+	if err != nil {
+		return failure.Transient(fmt.Errorf("unable to begin transaction: %w", err))
+	}
+	if err = fn(ctx, tx); err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			return fmt.Errorf("unable to rollback transaction: %v: %w", rErr, err)
+		}
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return storageError(fmt.Errorf("unable to commit transaction: %w", err))
+	}
+	return nil
+}
+
+// parseUpdatedAt parses the event time. Time is stored in UTC, so events with different offsets are comparable.
+func parseUpdatedAt(updatedAt string) (time.Time, error) {
+	data, err := time.Parse(time.RFC3339, updatedAt)
+	if err != nil {
+		return time.Time{}, failure.Permanent(fmt.Errorf("unable to parse time: %w", err))
+	}
+	return data.UTC(), nil
+}
+
+// observe records the duration of the backend operation and its failure.
+func (z *Zombie) observe(backend, operation string, startedAt time.Time, err error) {
+	z.metrics.Duration.WithLabelValues(backend, operation).Observe(time.Since(startedAt).Seconds())
+	if err != nil {
+		z.metrics.Errors.WithLabelValues(backend, operation).Inc()
+	}
+}
+
+// startSpan starts the span of the backend operation, end finishes it with the result of the operation.
+func (z *Zombie) startSpan(ctx context.Context, backend, operation string, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	system := semconv.DBSystemPostgreSQL
+	if backend == tile38Backend {
+		system = semconv.DBSystemKey.String(tile38Backend)
+	}
+	ctx, span := tracer.Start(ctx, backend+" "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, system, semconv.DBOperationKey.String(operation))...))
+	return ctx, func(err error) {
+		tracing.End(span, err)
+	}
+}
+
+func lastUpdate(ctx context.Context, dbConnect db.Connector) (time.Time, error) {
+	var updatedAt sql.NullTime
+	if err := dbConnect.Client().GetContext(ctx, &updatedAt, `SELECT max(updated_at) FROM zombies;`); err != nil {
+		return time.Time{}, fmt.Errorf("unable to get the last update: %w", err)
+	}
+	if !updatedAt.Valid {
+		return time.Time{}, nil
+	}
+	// timestamp column has no zone, it is stored in UTC
+	return updatedAt.Time.UTC(), nil
+}
+
+// storageError classifies postgres failure: broken data will fail again, everything else can be retried.
+func storageError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case dataExceptionClass, integrityViolationClass:
+			return failure.Permanent(err)
+		}
+	}
+	return failure.Transient(err)
+}
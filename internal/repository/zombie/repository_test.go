@@ -2,6 +2,7 @@ package zombie_test
 
 import (
 	"context"
+	"database/sql"
 	"testing"
 	"time"
 	"zombie_locator/internal/repository/zombie"
@@ -18,17 +19,15 @@ const (
 )
 
 func TestZombie_LocatedAndCapturedZombie(t *testing.T) {
-	connect, err := db.NewTile38Connection(tile38URL)
-	require.NoError(t, err)
-	pgConnect, err := db.NewPostgresConnection(postgresqlURL)
-	require.NoError(t, err)
+	pgConnect := postgresOrSkip(t)
+	connect := tile38OrSkip(t)
 
-	repo := zombie.NewZombieRepository(pgConnect, connect)
+	repo := zombie.NewZombieRepository(pgConnect, connect, nil)
 
 	zombieID := uuid.New()
 
 	// locate zombie
-	err = repo.LocatedZombie(context.Background(), zombieID, 48.85905, 2.294533, time.Now().Format(time.RFC3339))
+	err := repo.LocatedZombie(context.Background(), zombieID, 48.85905, 2.294533, time.Now().Format(time.RFC3339))
 	require.NoError(t, err)
 
 	// check zombie is in hunting list
@@ -41,12 +40,87 @@ func TestZombie_LocatedAndCapturedZombie(t *testing.T) {
 
 	// check zombie is not in hunting list
 	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, false)
+
+	// captured zombie keeps sending locations
+	err = repo.LocatedZombie(context.Background(), zombieID, 48.86, 2.3, time.Now().Format(time.RFC3339))
+	require.NoError(t, err)
+
+	// check zombie is still not in hunting list, but location is recorded
+	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, false)
+	checkStoredZombie(t, pgConnect, zombieID, "captured", true)
 }
 
-func checkZombie(t *testing.T, repo zombie.Zombier, zombieID uuid.UUID, lat, lon, limitKm float64, shouldExist bool) {
-	list, err := repo.LocateZombieList(context.Background(), lat, lon, limitKm)
+func TestZombie_CapturedBeforeLocatedZombie(t *testing.T) {
+	pgConnect := postgresOrSkip(t)
+	connect := tile38OrSkip(t)
+
+	repo := zombie.NewZombieRepository(pgConnect, connect, nil)
+
+	zombieID := uuid.New()
+
+	// capture zombie without any known location
+	err := repo.CapturedZombie(context.Background(), zombieID, time.Now().Format(time.RFC3339))
+	require.NoError(t, err)
+	checkStoredZombie(t, pgConnect, zombieID, "captured", false)
+
+	// locate zombie
+	err = repo.LocatedZombie(context.Background(), zombieID, 48.85905, 2.294533, time.Now().Format(time.RFC3339))
+	require.NoError(t, err)
+
+	// check zombie is not in hunting list, but location is recorded
+	checkZombie(t, repo, zombieID, 48.872544, 2.332298, 5, false)
+	checkStoredZombie(t, pgConnect, zombieID, "captured", true)
+}
+
+func TestZombie_LocateZombieListNearest(t *testing.T) {
+	pgConnect := postgresOrSkip(t)
+	connect := tile38OrSkip(t)
+
+	repo := zombie.NewZombieRepository(pgConnect, connect, nil)
+
+	// isolated spot in the middle of the ocean, so other zombies do not interfere
+	lat, lon := -48.876667, -123.393333
+	nearest, middle, farthest := uuid.New(), uuid.New(), uuid.New()
+	for id, offset := range map[uuid.UUID]float64{farthest: 0.03, nearest: 0.01, middle: 0.02} {
+		err := repo.LocatedZombie(context.Background(), id, lat+offset, lon, time.Now().Format(time.RFC3339))
+		require.NoError(t, err)
+	}
+	t.Cleanup(func() {
+		// remove zombies from the hunting list, so next runs start from the clean state
+		for _, id := range []uuid.UUID{nearest, middle, farthest} {
+			require.NoError(t, repo.CapturedZombie(context.Background(), id, time.Now().Format(time.RFC3339)))
+		}
+	})
+
+	list, err := repo.LocateZombieList(context.Background(), lat, lon, 2, 0)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, nearest, list[0].ZombieId)
+	require.Equal(t, middle, list[1].ZombieId)
+	require.InDelta(t, lat+0.01, list[0].Latitude, 0.000001)
+	require.InDelta(t, lon, list[0].Longitude, 0.000001)
+
+	// radius excludes the farthest ones
+	list, err = repo.LocateZombieList(context.Background(), lat, lon, 10, 1.5)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, nearest, list[0].ZombieId)
+}
+
+func checkStoredZombie(t *testing.T, pgConnect db.Connector, zombieID uuid.UUID, status string, hasPoint bool) {
+	var stored struct {
+		Status string         `db:"status"`
+		Point  sql.NullString `db:"point"`
+	}
+	err := pgConnect.Client().Get(&stored, "SELECT status, point FROM zombies WHERE id = $1", zombieID)
+	require.NoError(t, err)
+	require.Equal(t, status, stored.Status)
+	require.Equal(t, hasPoint, stored.Point.Valid)
+}
+
+func checkZombie(t *testing.T, repo zombie.Zombier, zombieID uuid.UUID, lat, lon, radiusKm float64, shouldExist bool) {
+	list, err := repo.LocateZombieList(context.Background(), lat, lon, 1000, radiusKm)
 	require.NoError(t, err)
-	require.True(t, len(list) > 0)
 	found := false
 	for _, l := range list {
 		if l.ZombieId == zombieID {
@@ -59,3 +133,125 @@ func checkZombie(t *testing.T, repo zombie.Zombier, zombieID uuid.UUID, lat, lon
 		require.False(t, found)
 	}
 }
+
+func TestZombie_OutOfOrderEvents(t *testing.T) {
+	pgConnect := postgresOrSkip(t)
+	connect := tile38OrSkip(t)
+
+	repo := zombie.NewZombieRepository(pgConnect, connect, nil)
+
+	zombieID := uuid.New()
+	now := time.Now()
+
+	// fresh location
+	err := repo.LocatedZombie(context.Background(), zombieID, 48.85905, 2.294533, now.Format(time.RFC3339))
+	require.NoError(t, err)
+
+	// older location arrives later and is skipped
+	err = repo.LocatedZombie(context.Background(), zombieID, 48.8, 2.2, now.Add(-time.Minute).Format(time.RFC3339))
+	require.ErrorIs(t, err, zombie.StaleEvent)
+
+	list, err := repo.LocateZombieList(context.Background(), 48.85905, 2.294533, 1, 0)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, zombieID, list[0].ZombieId)
+	require.InDelta(t, 48.85905, list[0].Latitude, 0.000001)
+
+	// capture older than the last location still captures the zombie
+	err = repo.CapturedZombie(context.Background(), zombieID, now.Add(-time.Hour).Format(time.RFC3339))
+	require.NoError(t, err)
+	checkZombie(t, repo, zombieID, 48.85905, 2.294533, 5, false)
+
+	// replayed capture is skipped
+	err = repo.CapturedZombie(context.Background(), zombieID, now.Format(time.RFC3339))
+	require.ErrorIs(t, err, zombie.StaleEvent)
+}
+
+func TestZombie_LocatedZombies(t *testing.T) {
+	pgConnect := postgresOrSkip(t)
+	connect := tile38OrSkip(t)
+
+	repo := zombie.NewZombieRepository(pgConnect, connect, nil)
+
+	located, captured, moved := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now()
+	require.NoError(t, repo.CapturedZombie(context.Background(), captured, now.Format(time.RFC3339)))
+	require.NoError(t, repo.LocatedZombie(context.Background(), moved, 48.85905, 2.294533, now.Format(time.RFC3339)))
+
+	stale, err := repo.LocatedZombies(context.Background(), []zombie.LocationUpdate{
+		{ZombieId: located, Latitude: 10, Longitude: 10, UpdatedAt: now.Add(-time.Minute).Format(time.RFC3339)},
+		{ZombieId: located, Latitude: 48.85905, Longitude: 2.294533, UpdatedAt: now.Format(time.RFC3339)},
+		{ZombieId: captured, Latitude: 48.85905, Longitude: 2.294533, UpdatedAt: now.Format(time.RFC3339)},
+		// older than the stored location
+		{ZombieId: moved, Latitude: 10, Longitude: 10, UpdatedAt: now.Add(-time.Minute).Format(time.RFC3339)},
+	})
+	require.NoError(t, err)
+	// collapsed location of the same zombie and the old location of moved one
+	require.Equal(t, 2, stale)
+
+	checkZombie(t, repo, located, 48.872544, 2.332298, 5, true)
+	checkZombie(t, repo, moved, 48.872544, 2.332298, 5, true)
+	checkZombie(t, repo, captured, 48.872544, 2.332298, 5, false)
+	checkStoredZombie(t, pgConnect, captured, "captured", true)
+}
+
+func TestZombie_Rehydrate(t *testing.T) {
+	pgConnect := postgresOrSkip(t)
+	connect := tile38OrSkip(t)
+
+	repo := zombie.NewZombieRepository(pgConnect, connect, nil)
+
+	located, captured := uuid.New(), uuid.New()
+	require.NoError(t, repo.LocatedZombie(context.Background(), located, 48.85905, 2.294533, time.Now().Format(time.RFC3339)))
+	require.NoError(t, repo.LocatedZombie(context.Background(), captured, 48.85905, 2.294533, time.Now().Format(time.RFC3339)))
+	require.NoError(t, repo.CapturedZombie(context.Background(), captured, time.Now().Format(time.RFC3339)))
+
+	// tile38 lost its data
+	require.NoError(t, connect.Keys.Del("zombies", located.String()))
+	require.NoError(t, connect.Keys.Drop("zombies_meta"))
+	checkZombie(t, repo, located, 48.872544, 2.332298, 5, false)
+	_, err := repo.Rehydrated(context.Background())
+	require.ErrorIs(t, err, zombie.NotRehydrated)
+
+	startedAt := time.Now()
+	loaded, err := repo.Rehydrate(context.Background())
+	require.NoError(t, err)
+	require.Positive(t, loaded)
+	rehydratedAt, err := repo.Rehydrated(context.Background())
+	require.NoError(t, err)
+	require.False(t, rehydratedAt.Before(startedAt))
+	checkZombie(t, repo, located, 48.872544, 2.332298, 5, true)
+	checkZombie(t, repo, captured, 48.872544, 2.332298, 5, false)
+}
+
+func TestZombie_Reconcile(t *testing.T) {
+	pgConnect := postgresOrSkip(t)
+	connect := tile38OrSkip(t)
+
+	repo := zombie.NewZombieRepository(pgConnect, connect, nil)
+
+	lost, captured, moved := uuid.New(), uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{lost, captured, moved} {
+		require.NoError(t, repo.LocatedZombie(context.Background(), id, 48.85905, 2.294533, time.Now().Format(time.RFC3339)))
+	}
+	require.NoError(t, repo.CapturedZombie(context.Background(), captured, time.Now().Format(time.RFC3339)))
+
+	// tile38 drifted from postgres
+	require.NoError(t, connect.Keys.Del("zombies", lost.String()))
+	require.NoError(t, connect.Keys.Set("zombies", captured.String()).Point(48.85905, 2.294533).Do())
+	require.NoError(t, connect.Keys.Set("zombies", moved.String()).Point(10, 10).Do())
+
+	drifted, err := repo.Reconcile(context.Background())
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, drifted, 3)
+	_, err = repo.RelayOutbox(context.Background())
+	require.NoError(t, err)
+
+	checkZombie(t, repo, lost, 48.872544, 2.332298, 5, true)
+	checkZombie(t, repo, moved, 48.872544, 2.332298, 5, true)
+	checkZombie(t, repo, captured, 48.872544, 2.332298, 5, false)
+
+	drifted, err = repo.Reconcile(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, drifted)
+}
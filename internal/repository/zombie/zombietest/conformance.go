@@ -0,0 +1,239 @@
+// Package zombietest provides the behaviour every zombie.Zombier implementation must conform to.
+package zombietest
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+	"zombie_locator/internal/repository/zombie"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// searchRadiusKm bounds the searches of the suite to the spot of the test,
+// so zombies left by other tests in the shared storage do not interfere.
+const searchRadiusKm = 50
+
+// Factory returns the storage under test. It skips the test when the storage is not available.
+type Factory func(t *testing.T) zombie.Zombier
+
+var (
+	rndMu sync.Mutex
+	rnd   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// RunConformance runs the suite against the storage returned by factory.
+func RunConformance(t *testing.T, factory Factory) {
+	t.Run("LocatedThenCaptured", func(t *testing.T) { testLocatedThenCaptured(t, factory(t)) })
+	t.Run("CapturedBeforeLocated", func(t *testing.T) { testCapturedBeforeLocated(t, factory(t)) })
+	t.Run("OutOfOrderLocations", func(t *testing.T) { testOutOfOrderLocations(t, factory(t)) })
+	t.Run("LocatedZombiesBatch", func(t *testing.T) { testLocatedZombiesBatch(t, factory(t)) })
+	t.Run("NearestOrdering", func(t *testing.T) { testNearestOrdering(t, factory(t)) })
+	t.Run("Limit", func(t *testing.T) { testLimit(t, factory(t)) })
+	t.Run("Radius", func(t *testing.T) { testRadius(t, factory(t)) })
+	t.Run("Hemispheres", func(t *testing.T) { testHemispheres(t, factory(t)) })
+	t.Run("Antimeridian", func(t *testing.T) { testAntimeridian(t, factory(t)) })
+}
+
+func testLocatedThenCaptured(t *testing.T, repo zombie.Zombier) {
+	lat, lon := spot()
+	zombieID := uuid.New()
+	locate(t, repo, zombieID, lat, lon, time.Now())
+	require.Equal(t, []uuid.UUID{zombieID}, nearby(t, repo, lat, lon))
+
+	require.NoError(t, repo.CapturedZombie(context.Background(), zombieID, timestamp(time.Now())))
+	require.Empty(t, nearby(t, repo, lat, lon))
+
+	// capture is sticky: captured zombies keep sending locations, but are not hunted anymore
+	locate(t, repo, zombieID, lat, lon, time.Now().Add(time.Minute))
+	require.Empty(t, nearby(t, repo, lat, lon))
+	err := repo.CapturedZombie(context.Background(), zombieID, timestamp(time.Now().Add(time.Hour)))
+	require.ErrorIs(t, err, zombie.StaleEvent)
+}
+
+func testCapturedBeforeLocated(t *testing.T, repo zombie.Zombier) {
+	lat, lon := spot()
+	zombieID := uuid.New()
+	require.NoError(t, repo.CapturedZombie(context.Background(), zombieID, timestamp(time.Now())))
+	// location older than the capture is stale, newer one is recorded
+	err := repo.LocatedZombie(context.Background(), zombieID, lat, lon, timestamp(time.Now().Add(-time.Minute)))
+	require.ErrorIs(t, err, zombie.StaleEvent)
+	locate(t, repo, zombieID, lat, lon, time.Now().Add(time.Minute))
+	require.Empty(t, nearby(t, repo, lat, lon))
+}
+
+func testOutOfOrderLocations(t *testing.T, repo zombie.Zombier) {
+	lat, lon := spot()
+	zombieID := uuid.New()
+	now := time.Now()
+	locate(t, repo, zombieID, lat, lon, now)
+
+	// older location arrives later and is skipped
+	err := repo.LocatedZombie(context.Background(), zombieID, lat+0.1, lon, timestamp(now.Add(-time.Minute)))
+	require.ErrorIs(t, err, zombie.StaleEvent)
+	list := locations(t, repo, lat, lon, 10, searchRadiusKm)
+	require.Len(t, list, 1)
+	require.InDelta(t, lat, list[0].Latitude, 0.000001)
+
+	// time with another offset is compared by the instant
+	err = repo.LocatedZombie(context.Background(), zombieID, lat+0.1, lon,
+		now.Add(-time.Second).In(time.FixedZone("UTC+5", 5*60*60)).Format(time.RFC3339))
+	require.ErrorIs(t, err, zombie.StaleEvent)
+
+	locate(t, repo, zombieID, lat+0.1, lon, now.Add(time.Minute))
+	list = locations(t, repo, lat, lon, 10, searchRadiusKm)
+	require.Len(t, list, 1)
+	require.InDelta(t, lat+0.1, list[0].Latitude, 0.000001)
+}
+
+func testLocatedZombiesBatch(t *testing.T, repo zombie.Zombier) {
+	lat, lon := spot()
+	first, second := uuid.New(), uuid.New()
+	now := time.Now()
+	stale, err := repo.LocatedZombies(context.Background(), []zombie.LocationUpdate{
+		{ZombieId: first, Latitude: lat + 0.01, Longitude: lon, UpdatedAt: timestamp(now)},
+		{ZombieId: first, Latitude: lat + 0.2, Longitude: lon, UpdatedAt: timestamp(now.Add(-time.Minute))},
+		{ZombieId: second, Latitude: lat + 0.02, Longitude: lon, UpdatedAt: timestamp(now)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, stale)
+	require.Equal(t, []uuid.UUID{first, second}, nearby(t, repo, lat, lon))
+
+	// the older event comes first, the newer one is kept all the same
+	lat, lon = spot()
+	third := uuid.New()
+	stale, err = repo.LocatedZombies(context.Background(), []zombie.LocationUpdate{
+		{ZombieId: third, Latitude: lat + 0.2, Longitude: lon, UpdatedAt: timestamp(now.Add(-time.Minute))},
+		{ZombieId: third, Latitude: lat + 0.01, Longitude: lon, UpdatedAt: timestamp(now)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, stale)
+	list := locations(t, repo, lat, lon, 1, searchRadiusKm)
+	require.Len(t, list, 1)
+	require.Equal(t, third, list[0].ZombieId)
+	require.InDelta(t, lat+0.01, list[0].Latitude, 0.000001)
+
+	// the whole batch is stale
+	stale, err = repo.LocatedZombies(context.Background(), []zombie.LocationUpdate{
+		{ZombieId: first, Latitude: lat, Longitude: lon, UpdatedAt: timestamp(now.Add(-time.Hour))},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, stale)
+
+	stale, err = repo.LocatedZombies(context.Background(), nil)
+	require.NoError(t, err)
+	require.Zero(t, stale)
+}
+
+func testNearestOrdering(t *testing.T, repo zombie.Zombier) {
+	lat, lon := spot()
+	nearest, middle, farthest := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now()
+	locate(t, repo, farthest, lat+0.03, lon, now)
+	locate(t, repo, nearest, lat, lon+0.01, now)
+	locate(t, repo, middle, lat-0.02, lon, now)
+
+	list := locations(t, repo, lat, lon, 2, searchRadiusKm)
+	require.Len(t, list, 2)
+	require.Equal(t, nearest, list[0].ZombieId)
+	require.InDelta(t, lat, list[0].Latitude, 0.000001)
+	require.InDelta(t, lon+0.01, list[0].Longitude, 0.000001)
+	require.Equal(t, middle, list[1].ZombieId)
+	require.Equal(t, []uuid.UUID{nearest, middle, farthest}, nearby(t, repo, lat, lon))
+}
+
+func testLimit(t *testing.T, repo zombie.Zombier) {
+	lat, lon := spot()
+	for i := 0; i < 3; i++ {
+		locate(t, repo, uuid.New(), lat+float64(i)*0.01, lon, time.Now())
+	}
+	require.Empty(t, locations(t, repo, lat, lon, 0, searchRadiusKm))
+	require.Empty(t, locations(t, repo, lat, lon, -1, searchRadiusKm))
+	require.Len(t, locations(t, repo, lat, lon, 1, searchRadiusKm), 1)
+	require.Len(t, locations(t, repo, lat, lon, 100, searchRadiusKm), 3)
+}
+
+func testRadius(t *testing.T, repo zombie.Zombier) {
+	lat, lon := spot()
+	near, far := uuid.New(), uuid.New()
+	// 0.01 degree of latitude is about 1.1 km
+	locate(t, repo, near, lat+0.01, lon, time.Now())
+	locate(t, repo, far, lat+0.03, lon, time.Now())
+
+	list := locations(t, repo, lat, lon, 10, 1.5)
+	require.Len(t, list, 1)
+	require.Equal(t, near, list[0].ZombieId)
+
+	// without radius the search is not bounded by distance
+	list = locations(t, repo, lat, lon, 1, 0)
+	require.Len(t, list, 1)
+	require.Equal(t, near, list[0].ZombieId)
+}
+
+func testHemispheres(t *testing.T, repo zombie.Zombier) {
+	for _, sign := range [][2]float64{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}} {
+		lat, lon := spot()
+		lat, lon = sign[0]*abs(lat), sign[1]*abs(lon)
+		zombieID := uuid.New()
+		locate(t, repo, zombieID, lat, lon, time.Now())
+		list := locations(t, repo, lat, lon, 1, searchRadiusKm)
+		require.Len(t, list, 1, "lat %v lon %v", lat, lon)
+		require.Equal(t, zombieID, list[0].ZombieId)
+		require.InDelta(t, lat, list[0].Latitude, 0.000001)
+		require.InDelta(t, lon, list[0].Longitude, 0.000001)
+	}
+}
+
+func testAntimeridian(t *testing.T, repo zombie.Zombier) {
+	lat, _ := spot()
+	across, sameSide := uuid.New(), uuid.New()
+	// zombie across the antimeridian is closer than the one on the same side
+	locate(t, repo, across, lat, -179.995, time.Now())
+	locate(t, repo, sameSide, lat, 179.97, time.Now())
+
+	list := locations(t, repo, lat, 179.995, 2, searchRadiusKm)
+	require.Len(t, list, 2)
+	require.Equal(t, across, list[0].ZombieId)
+	require.Equal(t, sameSide, list[1].ZombieId)
+}
+
+// spot returns the random point, tests at different spots do not see each other's zombies.
+func spot() (float64, float64) {
+	rndMu.Lock()
+	defer rndMu.Unlock()
+	// points far from the poles and the antimeridian, so the search area is regular
+	return 5 + rnd.Float64()*60, 5 + rnd.Float64()*170
+}
+
+func locate(t *testing.T, repo zombie.Zombier, zombieID uuid.UUID, lat, lon float64, updatedAt time.Time) {
+	require.NoError(t, repo.LocatedZombie(context.Background(), zombieID, lat, lon, timestamp(updatedAt)))
+}
+
+func locations(t *testing.T, repo zombie.Zombier, lat, lon float64, limit int, radiusKm float64) []zombie.Location {
+	list, err := repo.LocateZombieList(context.Background(), lat, lon, limit, radiusKm)
+	require.NoError(t, err)
+	return list
+}
+
+// nearby returns ids of the hunted zombies around the spot, nearest first.
+func nearby(t *testing.T, repo zombie.Zombier, lat, lon float64) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, l := range locations(t, repo, lat, lon, 100, searchRadiusKm) {
+		ids = append(ids, l.ZombieId)
+	}
+	return ids
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
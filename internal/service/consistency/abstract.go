@@ -0,0 +1,12 @@
+package consistency
+
+import "context"
+
+// Store keeps the zombies in the primary storage and the search index, e.g. postgres and tile38.
+type Store interface {
+	// RelayOutbox applies the pending updates to the search index, it returns the number of applied updates.
+	RelayOutbox(ctx context.Context) (int, error)
+	// Reconcile finds zombies which differ in the search index and schedules their repair,
+	// it returns the number of drifted zombies.
+	Reconcile(ctx context.Context) (int, error)
+}
@@ -0,0 +1,111 @@
+package consistency
+
+import (
+	"context"
+	"sync"
+	"time"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/utils/lifecycle"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRelayInterval     = time.Second
+	defaultReconcileInterval = 10 * time.Minute
+)
+
+// Config defines how often the search index is caught up with the primary storage.
+type Config struct {
+	// RelayInterval is the delay between outbox relays, updates are mostly applied right after they are stored.
+	RelayInterval time.Duration
+	// ReconcileInterval is the delay between full comparisons of the storages.
+	ReconcileInterval time.Duration
+}
+
+// Service keeps the search index consistent with the primary storage: it relays the outbox
+// left by failed index writes and periodically repairs the drift.
+type Service struct {
+	log   logger.AppLogger
+	store Store
+	cfg   Config
+}
+
+func NewConsistencyService(log logger.AppLogger, store Store, cfg Config) *Service {
+	if cfg.RelayInterval <= 0 {
+		cfg.RelayInterval = defaultRelayInterval
+	}
+	if cfg.ReconcileInterval <= 0 {
+		cfg.ReconcileInterval = defaultReconcileInterval
+	}
+	return &Service{
+		log:   log.With(zap.String("service", "consistency")),
+		store: store,
+		cfg:   cfg,
+	}
+}
+
+// Run relays and reconciles until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.every(ctx, s.cfg.RelayInterval, s.Relay)
+	}()
+	go func() {
+		defer wg.Done()
+		s.every(ctx, s.cfg.ReconcileInterval, s.Reconcile)
+	}()
+	wg.Wait()
+}
+
+// Component returns the service to run by the lifecycle manager.
+func (s *Service) Component(restart lifecycle.RestartPolicy) lifecycle.Component {
+	return lifecycle.Component{
+		Name: "consistency",
+		Run: func(ctx context.Context) error {
+			s.Run(ctx)
+			return nil
+		},
+		Restart: restart,
+	}
+}
+
+// Relay applies the outbox once.
+func (s *Service) Relay(ctx context.Context) {
+	relayed, err := s.store.RelayOutbox(ctx)
+	if err != nil && ctx.Err() == nil {
+		s.log.Error("failed to relay outbox", err, zap.Int("relayed", relayed))
+		return
+	}
+	if relayed > 0 {
+		s.log.Info("outbox relayed", zap.Int("relayed", relayed))
+	}
+}
+
+// Reconcile repairs the drift once, drifted zombies are fixed by the next relay.
+func (s *Service) Reconcile(ctx context.Context) {
+	drifted, err := s.store.Reconcile(ctx)
+	if err != nil && ctx.Err() == nil {
+		s.log.Error("failed to reconcile storages", err, zap.Int("drifted", drifted))
+		return
+	}
+	if drifted > 0 {
+		s.log.Info("drifted zombies scheduled for repair", zap.Int("drifted", drifted))
+		s.Relay(ctx)
+	}
+}
+
+func (s *Service) every(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn(ctx)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package consistency_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/service/consistency"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Run(t *testing.T) {
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+	store := &fakeStore{drift: 2, relayErr: errors.New("tile38 is down")}
+	service := consistency.NewConsistencyService(appLog, store, consistency.Config{
+		RelayInterval:     time.Millisecond,
+		ReconcileInterval: 5 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		service.Run(ctx)
+		close(done)
+	}()
+	// relay keeps going after failures and drift is repaired by it
+	require.Eventually(t, func() bool {
+		relays, reconciles := store.calls()
+		return relays > 3 && reconciles > 1
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
+
+type fakeStore struct {
+	mu         sync.Mutex
+	drift      int
+	relayErr   error
+	relays     int
+	reconciles int
+}
+
+func (f *fakeStore) RelayOutbox(ctx context.Context) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.relays++
+	if f.relays%2 == 0 {
+		return 0, f.relayErr
+	}
+	return f.drift, nil
+}
+
+func (f *fakeStore) Reconcile(ctx context.Context) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reconciles++
+	return f.drift, nil
+}
+
+func (f *fakeStore) calls() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.relays, f.reconciles
+}
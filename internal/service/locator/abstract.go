@@ -2,11 +2,18 @@ package locator
 
 import (
 	"context"
+	"time"
+	"zombie_locator/internal/repository/history"
 	"zombie_locator/internal/repository/zombie"
+
+	"github.com/google/uuid"
 )
 
 //go:generate mockgen -source=abstract.go -destination=abstract_locator_mock.go -package=locator
 type Locator interface {
-	// Locate returns the location of the zombies near provided coordinates.
-	Locate(ctx context.Context, lat, lon, limit float64) ([]zombie.Location, error)
+	// Locate returns up to limit zombies nearest to provided coordinates, ordered by distance.
+	// radiusKm bounds the search area, zero means no bound.
+	Locate(ctx context.Context, lat, lon float64, limit int, radiusKm float64) ([]zombie.Location, error)
+	// Track returns the zombie positions between from and to ordered by time, downsampled to maxPoints.
+	Track(ctx context.Context, zombieID uuid.UUID, from, to time.Time, maxPoints int) ([]history.Point, error)
 }
@@ -7,9 +7,12 @@ package locator
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
+	history "zombie_locator/internal/repository/history"
 	zombie "zombie_locator/internal/repository/zombie"
 
 	gomock "github.com/golang/mock/gomock"
+	uuid "github.com/google/uuid"
 )
 
 // MockLocator is a mock of Locator interface.
@@ -36,16 +39,31 @@ func (m *MockLocator) EXPECT() *MockLocatorMockRecorder {
 }
 
 // Locate mocks base method.
-func (m *MockLocator) Locate(ctx context.Context, lat, lon, limit float64) ([]zombie.Location, error) {
+func (m *MockLocator) Locate(ctx context.Context, lat, lon float64, limit int, radiusKm float64) ([]zombie.Location, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Locate", ctx, lat, lon, limit)
+	ret := m.ctrl.Call(m, "Locate", ctx, lat, lon, limit, radiusKm)
 	ret0, _ := ret[0].([]zombie.Location)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Locate indicates an expected call of Locate.
-func (mr *MockLocatorMockRecorder) Locate(ctx, lat, lon, limit interface{}) *gomock.Call {
+func (mr *MockLocatorMockRecorder) Locate(ctx, lat, lon, limit, radiusKm interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Locate", reflect.TypeOf((*MockLocator)(nil).Locate), ctx, lat, lon, limit)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Locate", reflect.TypeOf((*MockLocator)(nil).Locate), ctx, lat, lon, limit, radiusKm)
+}
+
+// Track mocks base method.
+func (m *MockLocator) Track(ctx context.Context, zombieID uuid.UUID, from, to time.Time, maxPoints int) ([]history.Point, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Track", ctx, zombieID, from, to, maxPoints)
+	ret0, _ := ret[0].([]history.Point)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Track indicates an expected call of Track.
+func (mr *MockLocatorMockRecorder) Track(ctx, zombieID, from, to, maxPoints interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Track", reflect.TypeOf((*MockLocator)(nil).Track), ctx, zombieID, from, to, maxPoints)
 }
@@ -2,24 +2,53 @@ package locator
 
 import (
 	"context"
+	"time"
 	"zombie_locator/internal/logger"
+	"zombie_locator/internal/repository/history"
 	"zombie_locator/internal/repository/zombie"
+	"zombie_locator/internal/tracing"
 
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+var tracer = otel.Tracer("zombie_locator/internal/service/locator")
+
 type Service struct {
-	log  logger.AppLogger
-	repo zombie.Zombier
+	log     logger.AppLogger
+	repo    zombie.Zombier
+	history history.Historian
 }
 
-func NewLocatorService(log logger.AppLogger, repo zombie.Zombier) *Service {
+func NewLocatorService(log logger.AppLogger, repo zombie.Zombier, history history.Historian) *Service {
 	return &Service{
-		log:  log.With(zap.String("service", "locator")),
-		repo: repo,
+		log:     log.With(zap.String("service", "locator")),
+		repo:    repo,
+		history: history,
 	}
 }
 
-func (s *Service) Locate(ctx context.Context, lat, lon, limit float64) ([]zombie.Location, error) {
-	return s.repo.LocateZombieList(ctx, lat, lon, limit)
+func (s *Service) Locate(ctx context.Context, lat, lon float64, limit int, radiusKm float64) (res []zombie.Location, err error) {
+	ctx, span := tracer.Start(ctx, "Locator.Locate", trace.WithAttributes(
+		attribute.Int("limit", limit),
+		attribute.Float64("radius_km", radiusKm)))
+	defer func() {
+		span.SetAttributes(attribute.Int("zombies", len(res)))
+		tracing.End(span, err)
+	}()
+	return s.repo.LocateZombieList(ctx, lat, lon, limit, radiusKm)
+}
+
+func (s *Service) Track(ctx context.Context, zombieID uuid.UUID, from, to time.Time, maxPoints int) (res []history.Point, err error) {
+	ctx, span := tracer.Start(ctx, "Locator.Track", trace.WithAttributes(
+		attribute.String("zombie_id", zombieID.String()),
+		attribute.Int("max_points", maxPoints)))
+	defer func() {
+		span.SetAttributes(attribute.Int("points", len(res)))
+		tracing.End(span, err)
+	}()
+	return s.history.Track(ctx, zombieID, from, to, maxPoints)
 }
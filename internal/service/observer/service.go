@@ -5,96 +5,245 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
-	"sync"
+	"strconv"
+	"time"
 	"zombie_locator/internal/entities"
 	"zombie_locator/internal/logger"
+	"zombie_locator/internal/metrics"
+	"zombie_locator/internal/repository/history"
 	"zombie_locator/internal/repository/zombie"
 	"zombie_locator/internal/storage/broker"
+	"zombie_locator/internal/tracing"
+	"zombie_locator/internal/utils/failure"
+	"zombie_locator/internal/utils/lifecycle"
 	"zombie_locator/internal/utils/shema_registry"
 
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+const (
+	locationEvent = "location"
+	captureEvent  = "capture"
+)
+
+var tracer = otel.Tracer("zombie_locator/internal/service/observer")
+
 var (
 	UnsupportedConsumerType = errors.New("unsupported event type")
+	InvalidEvent            = errors.New("invalid event data")
 )
 
 type Observer struct {
-	ctx              context.Context
-	cancel           context.CancelFunc
 	log              logger.AppLogger
 	repo             zombie.Zombier
+	history          history.Historian
 	registry         shema_registry.SchemaRegistry
 	statusConsumer   broker.Consumer
 	locationConsumer broker.Consumer
+	// batchLocations makes location events consumed in batches.
+	batchLocations bool
+	metrics        *metrics.Observer
 }
 
 func NewObserver(
 	log logger.AppLogger,
 	repo zombie.Zombier,
+	history history.Historian,
 	registry shema_registry.SchemaRegistry,
-	locationConsumer, statusConsumer broker.Consumer) *Observer {
-	ctx, cancel := context.WithCancel(context.Background())
+	locationConsumer, statusConsumer broker.Consumer,
+	batchLocations bool,
+	observerMetrics *metrics.Observer) *Observer {
+	if observerMetrics == nil {
+		observerMetrics = metrics.NewObserver(nil)
+	}
 	return &Observer{
-		ctx:              ctx,
-		cancel:           cancel,
 		log:              log.With(zap.String("service", "observer")),
 		repo:             repo,
+		history:          history,
 		registry:         registry,
 		locationConsumer: locationConsumer,
 		statusConsumer:   statusConsumer,
+		batchLocations:   batchLocations,
+		metrics:          observerMetrics,
 	}
 }
 
-func (o *Observer) Run() {
-	go func() {
-		if err := o.locationConsumer.Run(o.ctx, o.ZombieLocationUpdate); err != nil {
-			o.log.Error("failed to run location consumer", err)
-		}
-	}()
-	go func() {
-		if err := o.statusConsumer.Run(o.ctx, o.ZombieCapturedUpdate); err != nil {
-			o.log.Error("failed to run status consumer", err)
-		}
-	}()
+// Components returns the consumers of the observer to run by the lifecycle manager.
+// Each consumer is restarted by the policy on its own, the failure of the consumer stops the process otherwise.
+func (o *Observer) Components(restart lifecycle.RestartPolicy) []lifecycle.Component {
+	return []lifecycle.Component{
+		{
+			Name:    "location_consumer",
+			Run:     o.runLocationConsumer,
+			Stop:    o.locationConsumer.Shutdown,
+			Restart: restart,
+		},
+		{
+			Name: "status_consumer",
+			Run: func(ctx context.Context) error {
+				return o.statusConsumer.Run(ctx, o.ZombieCapturedUpdate)
+			},
+			Stop:    o.statusConsumer.Shutdown,
+			Restart: restart,
+		},
+	}
+}
+
+func (o *Observer) runLocationConsumer(ctx context.Context) error {
+	if o.batchLocations {
+		return o.locationConsumer.RunBatch(ctx, o.ZombieLocationBatchUpdate)
+	}
+	return o.locationConsumer.Run(ctx, o.ZombieLocationUpdate)
 }
 
 // ZombieLocationUpdate processes Kafka messages containing location updates..
-func (o *Observer) ZombieLocationUpdate(ctx context.Context, payload []byte) error {
+func (o *Observer) ZombieLocationUpdate(ctx context.Context, payload []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "Observer.ZombieLocationUpdate")
+	defer func() { tracing.End(span, err) }()
 	log := o.log.With(zap.String("method", "ZombieLocationUpdate"))
-	data, err := o.registry.DecodeZombieLocationStreamEvent(payload)
+	zL, err := o.decodeZombieLocation(ctx, log, payload)
+	if err != nil || zL == nil {
+		return err
+	}
+	return o.locatedZombie(ctx, log, zombie.LocationUpdate{
+		ZombieId:  zL.ZombieID,
+		Latitude:  zL.Latitude,
+		Longitude: zL.Longitude,
+		UpdatedAt: zL.UpdatedAt,
+	})
+}
+
+// ZombieLocationBatchUpdate processes the batch of Kafka messages containing location updates with a single storage call.
+// Messages which can not be decoded are reported as failed, the rest of the batch is stored.
+func (o *Observer) ZombieLocationBatchUpdate(ctx context.Context, msgs []kafka.Message) (failed map[int]error, err error) {
+	ctx, span := tracer.Start(ctx, "Observer.ZombieLocationBatchUpdate", trace.WithAttributes(attribute.Int("messages", len(msgs))))
+	defer func() {
+		span.SetAttributes(attribute.Int("failed", len(failed)))
+		tracing.End(span, err)
+	}()
+	log := o.log.With(zap.String("method", "ZombieLocationBatchUpdate"))
+	failed = make(map[int]error)
+	updates := make([]zombie.LocationUpdate, 0, len(msgs))
+	// indexes keeps the message index of each update
+	indexes := make([]int, 0, len(msgs))
+	for i, m := range msgs {
+		zL, err := o.decodeZombieLocation(broker.ContextWithMessage(ctx, m), log, m.Value)
+		if err != nil {
+			failed[i] = err
+			continue
+		}
+		if zL == nil {
+			continue
+		}
+		updates = append(updates, zombie.LocationUpdate{
+			ZombieId:  zL.ZombieID,
+			Latitude:  zL.Latitude,
+			Longitude: zL.Longitude,
+			UpdatedAt: zL.UpdatedAt,
+		})
+		indexes = append(indexes, i)
+	}
+	if len(updates) == 0 {
+		return failed, nil
+	}
+
+	// history is appended first, so the location stored as the latest one is always in the track
+	err = o.history.Append(ctx, updates...)
+	var stale int
+	if err == nil {
+		stale, err = o.repo.LocatedZombies(ctx, updates)
+		err = deferredIndex(log, err)
+	}
+	if failure.IsPermanent(err) {
+		// some update is broken, store them one by one to find it out and keep the rest
+		log.Error("failed to store zombie locations batch, storing one by one", err, zap.Int("size", len(updates)))
+		for j, u := range updates {
+			if err = o.locatedZombie(broker.ContextWithMessage(ctx, msgs[indexes[j]]), log, u); err != nil {
+				if !failure.IsPermanent(err) {
+					return nil, err
+				}
+				failed[indexes[j]] = err
+			}
+		}
+		return failed, nil
+	}
+	if err != nil {
+		log.Error("failed to update zombie locations", err, zap.Int("size", len(updates)))
+		return nil, fmt.Errorf("failed store zombie locations: %w", err)
+	}
+	if stale > 0 {
+		o.metrics.StaleEvents.WithLabelValues(locationEvent).Add(float64(stale))
+		log.Info("stale events skipped", zap.Int("count", stale))
+	}
+	for _, u := range updates {
+		o.observeLag(locationEvent, u.UpdatedAt)
+	}
+	return failed, nil
+}
+
+func (o *Observer) locatedZombie(ctx context.Context, log logger.AppLogger, u zombie.LocationUpdate) error {
+	if err := o.history.Append(ctx, u); err != nil {
+		log.Error("failed to append zombie location history", err)
+		return fmt.Errorf("failed append zombie location history: %w", err)
+	}
+	err := o.repo.LocatedZombie(ctx, u.ZombieId, u.Latitude, u.Longitude, u.UpdatedAt)
+	if errors.Is(err, zombie.StaleEvent) {
+		o.skipStaleEvent(log, locationEvent, u.ZombieId.String(), u.UpdatedAt)
+		return nil
+	}
+	err = deferredIndex(log, err)
+	if err != nil {
+		log.Error("failed to update zombie location", err)
+		return fmt.Errorf("failed store zombie location: %w", err)
+	}
+	o.observeLag(locationEvent, u.UpdatedAt)
+	return nil
+}
+
+// decodeZombieLocation decodes and validates the location event. Failures are permanent, the event will never be valid.
+func (o *Observer) decodeZombieLocation(ctx context.Context, log logger.AppLogger, payload []byte) (*entities.ZombieLocationV1, error) {
+	data, err := o.registry.DecodeZombieLocationStreamEvent(payload, o.schemaVersion(ctx))
 	if err != nil {
 		// unsupported message structure
 		log.Error("unsupported message structure", err)
-		return fmt.Errorf("unsupported message structure: %w", err)
+		return nil, failure.Permanent(fmt.Errorf("unsupported message structure: %w", err))
 	}
 	// we expect that map contains only one element
 	for v := range data {
+		broker.RecordSchemaVersion(ctx, v)
 		switch v {
 		case 1:
 			// process v1
-			return o.zombieLocationUpdateV1(ctx, log, data[v])
+			return o.zombieLocationV1(log, data[v])
 		default:
 			// unsupported version
 			err = fmt.Errorf("unsupported version: %d", v)
 			log.Error("error update zombie location", err)
-			return err
+			return nil, failure.Permanent(err)
 		}
 	}
-	return nil
+	return nil, nil
 }
 
 // ZombieCapturedUpdate processes Kafka messages containing captured zombies data updates.
-func (o *Observer) ZombieCapturedUpdate(ctx context.Context, payload []byte) error {
+func (o *Observer) ZombieCapturedUpdate(ctx context.Context, payload []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "Observer.ZombieCapturedUpdate")
+	defer func() { tracing.End(span, err) }()
 	log := o.log.With(zap.String("method", "ZombieCapturedUpdate"))
-	data, err := o.registry.DecodeZombieCapturedStreamEvent(payload)
+	data, err := o.registry.DecodeZombieCapturedStreamEvent(payload, o.schemaVersion(ctx))
 	if err != nil {
 		// unsupported message structure
 		log.Error("unsupported message structure", err)
-		return fmt.Errorf("unsupported message structure: %w", err)
+		return failure.Permanent(fmt.Errorf("unsupported message structure: %w", err))
 	}
 	// we expect that map contains only one element
 	for v := range data {
+		broker.RecordSchemaVersion(ctx, v)
 		switch v {
 		case 1:
 			// process v1
@@ -103,57 +252,87 @@ func (o *Observer) ZombieCapturedUpdate(ctx context.Context, payload []byte) err
 			// unsupported version
 			err = fmt.Errorf("unsupported version: %d", v)
 			log.Error("error update zombie status", err)
-			return fmt.Errorf("error update zombie status: %w", err)
+			return failure.Permanent(fmt.Errorf("error update zombie status: %w", err))
 		}
 	}
 	return nil
 }
 
 func (o *Observer) zombieCapturedUpdateV1(ctx context.Context, log logger.AppLogger, payload any) error {
-	zC, ok := payload.(entities.ZombieCapturedV1)
+	zC, ok := payload.(*entities.ZombieCapturedV1)
 	if !ok {
 		payloadType := reflect.TypeOf(payload).String()
 		log.Error("unsupported event type", UnsupportedConsumerType, zap.String("type", payloadType))
-		return fmt.Errorf("unsupported type for zombie capture update v1 :%s", payloadType)
+		return failure.Permanent(fmt.Errorf("unsupported type for zombie capture update v1 :%s", payloadType))
 	}
-	if err := o.repo.CapturedZombie(ctx, zC.ZombieID, zC.UpdatedAt); err != nil {
+	if zC.ZombieID == uuid.Nil {
+		log.Error("invalid zombie capture", InvalidEvent)
+		return failure.Permanent(fmt.Errorf("invalid zombie capture: %w", InvalidEvent))
+	}
+	err := o.repo.CapturedZombie(ctx, zC.ZombieID, zC.UpdatedAt)
+	if errors.Is(err, zombie.StaleEvent) {
+		o.skipStaleEvent(log, captureEvent, zC.ZombieID.String(), zC.UpdatedAt)
+		return nil
+	}
+	err = deferredIndex(log, err)
+	if err != nil {
 		log.Error("failed to update zombie status", err)
 		return fmt.Errorf("failed to update zombie status: %w", err)
 	}
+	o.observeLag(captureEvent, zC.UpdatedAt)
 	return nil
 }
 
-func (o *Observer) zombieLocationUpdateV1(ctx context.Context, log logger.AppLogger, payload any) error {
-	zL, ok := payload.(entities.ZombieLocationV1)
+func (o *Observer) zombieLocationV1(log logger.AppLogger, payload any) (*entities.ZombieLocationV1, error) {
+	zL, ok := payload.(*entities.ZombieLocationV1)
 	if !ok {
 		payloadType := reflect.TypeOf(payload).String()
 		log.Error("unsupported event type", UnsupportedConsumerType, zap.String("type", payloadType))
-		return fmt.Errorf("unsupported type for zombie location update v1 :%s", payloadType)
+		return nil, failure.Permanent(fmt.Errorf("unsupported type for zombie location update v1 :%s", payloadType))
 	}
-	if err := o.repo.LocatedZombie(ctx, zL.ZombieID, zL.Latitude, zL.Longitude, zL.UpdatedAt); err != nil {
-		log.Error("failed to update zombie location", err)
-		return fmt.Errorf("failed store zombie location: %w", err)
+	if !isValidLocation(zL.ZombieID, zL.Latitude, zL.Longitude) {
+		log.Error("invalid zombie location", InvalidEvent, zap.Float64("lat", zL.Latitude), zap.Float64("lon", zL.Longitude))
+		return nil, failure.Permanent(fmt.Errorf("invalid zombie location: %w", InvalidEvent))
 	}
-	return nil
+	return zL, nil
 }
 
-func (o *Observer) Shutdown() error {
-	var wg sync.WaitGroup
-	wg.Add(2)
-	o.cancel()
-	go func() {
-		if err := o.locationConsumer.Shutdown(); err != nil {
-			o.log.Error("failed to shutdown location consumer", err)
-		}
-		wg.Done()
-	}()
+// schemaVersion returns the schema version of the raw payload from the message header, zero if it is not set.
+func (o *Observer) schemaVersion(ctx context.Context) int {
+	value, ok := broker.HeaderFromContext(ctx, shema_registry.HeaderSchemaVersion)
+	if !ok {
+		return 0
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		// registry rejects unknown version, so the message goes to the dead letter queue
+		return -1
+	}
+	return version
+}
 
-	go func() {
-		if err := o.statusConsumer.Shutdown(); err != nil {
-			o.log.Error("failed to shutdown status consumer", err)
-		}
-		wg.Done()
-	}()
-	wg.Wait()
-	return nil
+func isValidLocation(zombieID uuid.UUID, lat, lon float64) bool {
+	return zombieID != uuid.Nil && lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+func (o *Observer) skipStaleEvent(log logger.AppLogger, event, zombieID, updatedAt string) {
+	o.metrics.StaleEvents.WithLabelValues(event).Inc()
+	log.Info("stale event skipped", zap.String("zombie_id", zombieID), zap.String("updated_at", updatedAt))
+}
+
+// deferredIndex logs the update which is stored but has not reached the search index yet and reports it
+// as done, the outbox relay applies it later. Other errors are returned as is.
+func deferredIndex(log logger.AppLogger, err error) error {
+	if errors.Is(err, zombie.IndexDeferred) {
+		log.Error("zombie update is stored, search index update is left to the outbox relay", err)
+		return nil
+	}
+	return err
+}
+
+// observeLag records the time from the event to its persistence, the time is already validated by the storage.
+func (o *Observer) observeLag(event, updatedAt string) {
+	if at, err := time.Parse(time.RFC3339, updatedAt); err == nil {
+		o.metrics.EventLag.WithLabelValues(event).Observe(time.Since(at).Seconds())
+	}
 }
@@ -0,0 +1,355 @@
+package observer_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/metrics"
+	"zombie_locator/internal/repository/history"
+	"zombie_locator/internal/repository/zombie"
+	"zombie_locator/internal/service/observer"
+	"zombie_locator/internal/storage/broker"
+	"zombie_locator/internal/utils/circuit"
+	"zombie_locator/internal/utils/failure"
+	"zombie_locator/internal/utils/lifecycle"
+	"zombie_locator/internal/utils/shema_registry"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserver_ZombieLocationUpdate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := zombie.NewMockZombier(ctrl)
+	observerMetrics := metrics.NewObserver(nil)
+	zombieObserver := newObserverWithMetrics(t, repo, history.NewMemoryHistory(), observerMetrics)
+	zombieID := uuid.MustParse("47bf590c-b593-412a-a2a1-68d051cd220c")
+	payload := []byte(`{
+		"zombie_id": "47bf590c-b593-412a-a2a1-68d051cd220c",
+		"latitude": 48.85905,
+		"longitude": 2.294533,
+		"updated_at": "2022-01-01T22:33:44.55Z"
+	}`)
+
+	t.Run("raw payload", func(t *testing.T) {
+		repo.EXPECT().LocatedZombie(gomock.Any(), zombieID, 48.85905, 2.294533, "2022-01-01T22:33:44.55Z").Return(nil)
+		require.NoError(t, zombieObserver.ZombieLocationUpdate(context.Background(), payload))
+	})
+	t.Run("raw payload with header version", func(t *testing.T) {
+		repo.EXPECT().LocatedZombie(gomock.Any(), zombieID, 48.85905, 2.294533, "2022-01-01T22:33:44.55Z").Return(nil)
+		ctx := withSchemaVersion("1")
+		require.NoError(t, zombieObserver.ZombieLocationUpdate(ctx, payload))
+	})
+	t.Run("unsupported header version", func(t *testing.T) {
+		err := zombieObserver.ZombieLocationUpdate(withSchemaVersion("2"), payload)
+		require.True(t, failure.IsPermanent(err))
+	})
+	t.Run("invalid location", func(t *testing.T) {
+		err := zombieObserver.ZombieLocationUpdate(context.Background(), []byte(`{"zombie_id":"47bf590c-b593-412a-a2a1-68d051cd220c","latitude":91}`))
+		require.True(t, failure.IsPermanent(err))
+	})
+	t.Run("stale event is skipped", func(t *testing.T) {
+		repo.EXPECT().LocatedZombie(gomock.Any(), zombieID, 48.85905, 2.294533, "2022-01-01T22:33:44.55Z").Return(zombie.StaleEvent)
+		require.NoError(t, zombieObserver.ZombieLocationUpdate(context.Background(), payload))
+		require.Equal(t, 1.0, staleEvents(t, observerMetrics, "location"))
+	})
+	t.Run("deferred index update is done", func(t *testing.T) {
+		repo.EXPECT().LocatedZombie(gomock.Any(), zombieID, 48.85905, 2.294533, "2022-01-01T22:33:44.55Z").
+			Return(failure.Transient(fmt.Errorf("%w: tile38 is down", zombie.IndexDeferred)))
+		require.NoError(t, zombieObserver.ZombieLocationUpdate(context.Background(), payload))
+	})
+}
+
+func TestObserver_ZombieCapturedUpdate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := zombie.NewMockZombier(ctrl)
+	zombieObserver := newObserver(t, repo, history.NewMemoryHistory())
+
+	repo.EXPECT().CapturedZombie(gomock.Any(), uuid.MustParse("84a526b3-4302-44ad-8fe6-4b8ce45d6980"), "2022-01-01T22:33:44.66Z").Return(nil)
+	err := zombieObserver.ZombieCapturedUpdate(context.Background(), []byte(`{
+		"zombie_id": "84a526b3-4302-44ad-8fe6-4b8ce45d6980",
+		"updated_at": "2022-01-01T22:33:44.66Z"
+	}`))
+	require.NoError(t, err)
+
+	err = zombieObserver.ZombieCapturedUpdate(context.Background(), []byte(`{"v":1,"d":"not base64"}`))
+	require.True(t, failure.IsPermanent(err))
+}
+
+func TestObserver_ZombieLocationBatchUpdate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := zombie.NewMockZombier(ctrl)
+	observerMetrics := metrics.NewObserver(nil)
+	zombieObserver := newObserverWithMetrics(t, repo, history.NewMemoryHistory(), observerMetrics)
+	first := uuid.MustParse("47bf590c-b593-412a-a2a1-68d051cd220c")
+	second := uuid.MustParse("84a526b3-4302-44ad-8fe6-4b8ce45d6980")
+	msgs := []kafka.Message{
+		locationMessage(first, 48.85905, 2.294533, "2022-01-01T22:33:44.55Z"),
+		{Value: []byte(`{"zombie_id":"47bf590c-b593-412a-a2a1-68d051cd220c","latitude":91}`)},
+		locationMessage(second, 48.8584, 2.2945, "2022-01-01T22:33:45Z"),
+		locationMessage(first, 48.86, 2.3, "2022-01-01T22:33:46Z"),
+	}
+	updates := []zombie.LocationUpdate{
+		{ZombieId: first, Latitude: 48.85905, Longitude: 2.294533, UpdatedAt: "2022-01-01T22:33:44.55Z"},
+		{ZombieId: second, Latitude: 48.8584, Longitude: 2.2945, UpdatedAt: "2022-01-01T22:33:45Z"},
+		{ZombieId: first, Latitude: 48.86, Longitude: 2.3, UpdatedAt: "2022-01-01T22:33:46Z"},
+	}
+
+	t.Run("batch is stored at once", func(t *testing.T) {
+		repo.EXPECT().LocatedZombies(gomock.Any(), updates).Return(1, nil)
+		failed, err := zombieObserver.ZombieLocationBatchUpdate(context.Background(), msgs)
+		require.NoError(t, err)
+		require.Len(t, failed, 1)
+		require.True(t, failure.IsPermanent(failed[1]))
+		require.Equal(t, 1.0, staleEvents(t, observerMetrics, "location"))
+	})
+	t.Run("deferred index update is done", func(t *testing.T) {
+		repo.EXPECT().LocatedZombies(gomock.Any(), updates).
+			Return(0, failure.Transient(fmt.Errorf("%w: tile38 is down", zombie.IndexDeferred)))
+		failed, err := zombieObserver.ZombieLocationBatchUpdate(context.Background(), msgs)
+		require.NoError(t, err)
+		require.Len(t, failed, 1)
+	})
+	t.Run("transient failure fails the batch", func(t *testing.T) {
+		repo.EXPECT().LocatedZombies(gomock.Any(), updates).Return(0, failure.Transient(errors.New("db is down")))
+		_, err := zombieObserver.ZombieLocationBatchUpdate(context.Background(), msgs)
+		require.True(t, failure.IsTransient(err))
+	})
+	t.Run("permanent failure is isolated", func(t *testing.T) {
+		repo.EXPECT().LocatedZombies(gomock.Any(), updates).Return(0, failure.Permanent(errors.New("broken row")))
+		repo.EXPECT().LocatedZombie(gomock.Any(), first, 48.85905, 2.294533, "2022-01-01T22:33:44.55Z").Return(nil)
+		repo.EXPECT().LocatedZombie(gomock.Any(), second, 48.8584, 2.2945, "2022-01-01T22:33:45Z").Return(failure.Permanent(errors.New("broken row")))
+		repo.EXPECT().LocatedZombie(gomock.Any(), first, 48.86, 2.3, "2022-01-01T22:33:46Z").Return(nil)
+		failed, err := zombieObserver.ZombieLocationBatchUpdate(context.Background(), msgs)
+		require.NoError(t, err)
+		require.Len(t, failed, 2)
+		require.True(t, failure.IsPermanent(failed[2]))
+	})
+}
+
+func TestObserver_LocationHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := zombie.NewMockZombier(ctrl)
+	zHistory := history.NewMockHistorian(ctrl)
+	zombieObserver := newObserver(t, repo, zHistory)
+	zombieID := uuid.New()
+	update := zombie.LocationUpdate{ZombieId: zombieID, Latitude: 48.86, Longitude: 2.3, UpdatedAt: "2022-01-01T22:33:46Z"}
+	msgs := []kafka.Message{locationMessage(zombieID, 48.86, 2.3, "2022-01-01T22:33:46Z")}
+
+	t.Run("history is appended before the location is stored", func(t *testing.T) {
+		gomock.InOrder(
+			zHistory.EXPECT().Append(gomock.Any(), update).Return(nil),
+			repo.EXPECT().LocatedZombies(gomock.Any(), []zombie.LocationUpdate{update}).Return(0, nil),
+		)
+		failed, err := zombieObserver.ZombieLocationBatchUpdate(context.Background(), msgs)
+		require.NoError(t, err)
+		require.Empty(t, failed)
+	})
+	t.Run("transient history failure fails the batch", func(t *testing.T) {
+		zHistory.EXPECT().Append(gomock.Any(), update).Return(failure.Transient(errors.New("db is down")))
+		_, err := zombieObserver.ZombieLocationBatchUpdate(context.Background(), msgs)
+		require.True(t, failure.IsTransient(err))
+	})
+	t.Run("history failure fails the location", func(t *testing.T) {
+		zHistory.EXPECT().Append(gomock.Any(), update).Return(failure.Transient(errors.New("db is down")))
+		err := zombieObserver.ZombieLocationUpdate(context.Background(), msgs[0].Value)
+		require.True(t, failure.IsTransient(err))
+	})
+}
+
+func TestObserver_HistoryFailureOpensBreaker(t *testing.T) {
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+	ctrl := gomock.NewController(t)
+	repo := zombie.NewMockZombier(ctrl)
+	zHistory := history.NewMockHistorian(ctrl)
+	breaker := circuit.NewBreaker(appLog, "storage", circuit.Config{FailureThreshold: 2}, func(ctx context.Context) error {
+		return errors.New("db is down")
+	})
+	zombieObserver := newObserver(t, zombie.NewBreakerRepository(repo, breaker), history.NewBreakerHistory(zHistory, breaker))
+	zombieID := uuid.New()
+	payload := locationMessage(zombieID, 48.86, 2.3, "2022-01-01T22:33:46Z").Value
+
+	// the zombie is not stored while the history can not be appended
+	zHistory.EXPECT().Append(gomock.Any(), gomock.Any()).Return(failure.Transient(errors.New("db is down"))).Times(2)
+	for i := 0; i < 2; i++ {
+		require.True(t, failure.IsTransient(zombieObserver.ZombieLocationUpdate(context.Background(), payload)))
+	}
+	// the consumers wait for the breaker instead of spending the retries
+	require.True(t, breaker.Paused())
+	err = zombieObserver.ZombieLocationUpdate(context.Background(), payload)
+	require.ErrorIs(t, err, circuit.OpenState)
+}
+
+func TestObserver_WithMemoryStorage(t *testing.T) {
+	repo := zombie.NewMemoryRepository()
+	zHistory := history.NewMemoryHistory()
+	observerMetrics := metrics.NewObserver(nil)
+	zombieObserver := newObserverWithMetrics(t, repo, zHistory, observerMetrics)
+	located, captured := uuid.New(), uuid.New()
+
+	failed, err := zombieObserver.ZombieLocationBatchUpdate(context.Background(), []kafka.Message{
+		locationMessage(located, 48.86, 2.3, "2022-01-01T22:33:46Z"),
+		locationMessage(located, 48.85, 2.2, "2022-01-01T22:33:45Z"),
+		locationMessage(captured, 48.86, 2.3, "2022-01-01T22:33:46Z"),
+	})
+	require.NoError(t, err)
+	require.Empty(t, failed)
+	require.Equal(t, 1.0, staleEvents(t, observerMetrics, "location"))
+	require.NoError(t, zombieObserver.ZombieCapturedUpdate(context.Background(), []byte(fmt.Sprintf(
+		`{"zombie_id":"%s","updated_at":"2022-01-01T22:33:47Z"}`, captured))))
+	// capture is terminal, the repeated one is stale
+	require.NoError(t, zombieObserver.ZombieCapturedUpdate(context.Background(), []byte(fmt.Sprintf(
+		`{"zombie_id":"%s","updated_at":"2022-01-01T22:33:48Z"}`, captured))))
+	require.Equal(t, 1.0, staleEvents(t, observerMetrics, "capture"))
+
+	list, err := repo.LocateZombieList(context.Background(), 48.86, 2.3, 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, []zombie.Location{{ZombieId: located, Latitude: 48.86, Longitude: 2.3}}, list)
+
+	// stale location is not the latest one, but it is in the track
+	track, err := zHistory.Track(context.Background(), located, time.Time{}, time.Now(), 10)
+	require.NoError(t, err)
+	require.Equal(t, []history.Point{
+		{RecordedAt: time.Date(2022, 1, 1, 22, 33, 45, 0, time.UTC), Latitude: 48.85, Longitude: 2.2},
+		{RecordedAt: time.Date(2022, 1, 1, 22, 33, 46, 0, time.UTC), Latitude: 48.86, Longitude: 2.3},
+	}, track)
+}
+
+func TestObserver_EventLag(t *testing.T) {
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+	observerMetrics := metrics.NewObserver(nil)
+	zombieObserver := observer.NewObserver(appLog, zombie.NewMemoryRepository(), history.NewMemoryHistory(),
+		shema_registry.NewRegistry([]int{1}), nil, nil, false, observerMetrics)
+
+	zombieID := uuid.New()
+	updatedAt := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	require.NoError(t, zombieObserver.ZombieLocationUpdate(context.Background(), locationMessage(zombieID, 48.85, 2.29, updatedAt).Value))
+	// stale event is not persisted, so it is not observed
+	older := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	require.NoError(t, zombieObserver.ZombieLocationUpdate(context.Background(), locationMessage(zombieID, 48.85, 2.29, older).Value))
+	require.NoError(t, zombieObserver.ZombieCapturedUpdate(context.Background(),
+		[]byte(fmt.Sprintf(`{"zombie_id":"%s","updated_at":"%s"}`, zombieID, updatedAt))))
+
+	for event, count := range map[string]uint64{"location": 1, "capture": 1} {
+		m := &dto.Metric{}
+		require.NoError(t, observerMetrics.EventLag.WithLabelValues(event).(prometheus.Metric).Write(m))
+		require.Equal(t, count, m.GetHistogram().GetSampleCount(), event)
+		require.InDelta(t, time.Minute.Seconds(), m.GetHistogram().GetSampleSum(), 5, event)
+	}
+}
+
+func TestObserver_Components(t *testing.T) {
+	locationConsumer := &fakeConsumer{failures: []error{errors.New("kafka is down")}}
+	statusConsumer := &fakeConsumer{}
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+	zombieObserver := observer.NewObserver(appLog, zombie.NewMemoryRepository(), history.NewMemoryHistory(),
+		shema_registry.NewRegistry([]int{1}), locationConsumer, statusConsumer, true, nil)
+
+	manager := lifecycle.NewManager(appLog, lifecycle.Config{})
+	manager.Add(zombieObserver.Components(lifecycle.RestartPolicy{MaxRestarts: 1, Backoff: time.Millisecond})...)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- manager.Run(ctx)
+	}()
+	// failed location consumer is restarted, batches are consumed by both runs
+	require.Eventually(t, func() bool { return locationConsumer.len() == 2 }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.Equal(t, []string{"RunBatch", "RunBatch", "Shutdown"}, locationConsumer.list())
+	require.Equal(t, []string{"Run", "Shutdown"}, statusConsumer.list())
+
+	// consumer failed beyond the policy stops the process
+	locationConsumer = &fakeConsumer{failures: []error{errors.New("kafka is down")}}
+	zombieObserver = observer.NewObserver(appLog, zombie.NewMemoryRepository(), history.NewMemoryHistory(),
+		shema_registry.NewRegistry([]int{1}), locationConsumer, &fakeConsumer{}, true, nil)
+	manager = lifecycle.NewManager(appLog, lifecycle.Config{})
+	manager.Add(zombieObserver.Components(lifecycle.RestartPolicy{})...)
+	require.EqualError(t, manager.Run(context.Background()), "location_consumer failed: kafka is down")
+}
+
+func locationMessage(zombieID uuid.UUID, lat, lon float64, updatedAt string) kafka.Message {
+	return kafka.Message{Value: []byte(fmt.Sprintf(
+		`{"zombie_id":"%s","latitude":%v,"longitude":%v,"updated_at":"%s"}`, zombieID, lat, lon, updatedAt))}
+}
+
+func newObserver(t *testing.T, repo zombie.Zombier, zHistory history.Historian) *observer.Observer {
+	return newObserverWithMetrics(t, repo, zHistory, nil)
+}
+
+func newObserverWithMetrics(t *testing.T, repo zombie.Zombier, zHistory history.Historian, observerMetrics *metrics.Observer) *observer.Observer {
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+	return observer.NewObserver(appLog, repo, zHistory, shema_registry.NewRegistry([]int{1}), nil, nil, false, observerMetrics)
+}
+
+func staleEvents(t *testing.T, observerMetrics *metrics.Observer, event string) float64 {
+	m := &dto.Metric{}
+	require.NoError(t, observerMetrics.StaleEvents.WithLabelValues(event).(prometheus.Metric).Write(m))
+	return m.GetCounter().GetValue()
+}
+
+func withSchemaVersion(version string) context.Context {
+	return broker.ContextWithMessage(context.Background(), kafka.Message{
+		Headers: []kafka.Header{{Key: shema_registry.HeaderSchemaVersion, Value: []byte(version)}},
+	})
+}
+
+// fakeConsumer records the calls, each run fails with the next failure or blocks until ctx is done.
+type fakeConsumer struct {
+	mu       sync.Mutex
+	failures []error
+	calls    []string
+}
+
+func (f *fakeConsumer) Run(ctx context.Context, handler broker.Handler) error {
+	return f.run(ctx, "Run")
+}
+
+func (f *fakeConsumer) RunBatch(ctx context.Context, handler broker.BatchHandler) error {
+	return f.run(ctx, "RunBatch")
+}
+
+func (f *fakeConsumer) run(ctx context.Context, call string) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	if len(f.failures) > 0 {
+		err := f.failures[0]
+		f.failures = f.failures[1:]
+		f.mu.Unlock()
+		return err
+	}
+	f.mu.Unlock()
+	<-ctx.Done()
+	return nil
+}
+
+func (f *fakeConsumer) Shutdown() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "Shutdown")
+	return nil
+}
+
+func (f *fakeConsumer) list() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *fakeConsumer) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.calls)
+}
@@ -0,0 +1,166 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/storage/broker"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+var UnknownSourceTopic = errors.New("no handler for the source topic")
+
+// Handler replays the dead message.
+type Handler func(ctx context.Context, d broker.DeadMessage) error
+
+// ConsumerHandler replays the original payload through the consumer handler, as it was consumed from the source topic.
+func ConsumerHandler(handler broker.Handler) Handler {
+	return func(ctx context.Context, d broker.DeadMessage) error {
+		return handler(broker.ContextWithMessage(ctx, d.OriginalMessage()), d.Msg)
+	}
+}
+
+// PublishHandler replays the original message with its key and headers through the publish function.
+func PublishHandler(publish func(ctx context.Context, m kafka.Message) error) Handler {
+	return func(ctx context.Context, d broker.DeadMessage) error {
+		return publish(ctx, d.OriginalMessage())
+	}
+}
+
+// Filter selects dead messages to replay, empty fields match everything.
+type Filter struct {
+	SourceTopic   string
+	ErrorContains string
+	From          time.Time
+	To            time.Time
+}
+
+func (f Filter) match(d broker.DeadMessage, failedAt time.Time) bool {
+	if f.SourceTopic != "" && d.Topic != f.SourceTopic {
+		return false
+	}
+	if f.ErrorContains != "" && !strings.Contains(d.Error, f.ErrorContains) {
+		return false
+	}
+	if !f.From.IsZero() && failedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && failedAt.After(f.To) {
+		return false
+	}
+	return true
+}
+
+// Summary describes the replay result.
+type Summary struct {
+	Read     int            `json:"read"`
+	Broken   int            `json:"broken"`
+	Matched  int            `json:"matched"`
+	Replayed int            `json:"replayed"`
+	Failed   int            `json:"failed"`
+	ByTopic  map[string]int `json:"by_topic"`
+	ByError  map[string]int `json:"by_error"`
+}
+
+// Partition is the part of the dead letter queue to replay.
+type Partition struct {
+	ID int
+	// Reader reads the partition from Start.
+	Reader broker.MessageReader
+	// Start is the offset of the first message to read.
+	Start int64
+	// End is the high-water mark taken when the replay starts, messages dead-lettered later are left for the next replay.
+	End int64
+}
+
+// Service reads dead letter queue and re-feeds matched messages to the handler of their source topic.
+type Service struct {
+	log        logger.AppLogger
+	partitions []Partition
+	handlers   map[string]Handler
+}
+
+// NewReplayService sets up replay of the dead messages of the partitions. handlers are keyed by the source topic.
+func NewReplayService(log logger.AppLogger, partitions []Partition, handlers map[string]Handler) *Service {
+	return &Service{
+		log:        log.With(zap.String("service", "dlq_replay")),
+		partitions: partitions,
+		handlers:   handlers,
+	}
+}
+
+// Run reads each partition up to its end. Matched messages are replayed unless dryRun is set.
+func (s *Service) Run(ctx context.Context, filter Filter, dryRun bool) (Summary, error) {
+	summary := Summary{
+		ByTopic: make(map[string]int),
+		ByError: make(map[string]int),
+	}
+	for _, p := range s.partitions {
+		if err := s.runPartition(ctx, p, filter, dryRun, &summary); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+func (s *Service) runPartition(ctx context.Context, p Partition, filter Filter, dryRun bool, summary *Summary) error {
+	// the reader blocks at the end waiting for new messages, so it stops right after the last message before the end
+	for offset := p.Start; offset < p.End; {
+		m, err := p.Reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to fetch dead message of partition %d: %w", p.ID, err)
+		}
+		if m.Offset >= p.End {
+			return nil
+		}
+		offset = m.Offset + 1
+		summary.Read++
+
+		d, err := broker.DecodeDeadMessage(m.Value)
+		if err != nil {
+			summary.Broken++
+			s.log.Error("broken dead message", err, zap.Int("partition", m.Partition), zap.Int64("offset", m.Offset))
+			continue
+		}
+		// records written before versioning have no failure time, the dead letter write time is close enough
+		failedAt := d.FailedAt
+		if failedAt.IsZero() {
+			failedAt = m.Time
+		}
+		if !filter.match(d, failedAt) {
+			continue
+		}
+		summary.Matched++
+		summary.ByTopic[d.Topic]++
+		summary.ByError[d.Error]++
+		if dryRun {
+			continue
+		}
+		if err = s.replay(ctx, d); err != nil {
+			summary.Failed++
+			s.log.Error("failed to replay dead message", err,
+				zap.String("source_topic", d.Topic),
+				zap.Int("partition", m.Partition),
+				zap.Int64("offset", m.Offset))
+			continue
+		}
+		summary.Replayed++
+	}
+	return nil
+}
+
+func (s *Service) replay(ctx context.Context, d broker.DeadMessage) error {
+	handler, ok := s.handlers[d.Topic]
+	if !ok {
+		return fmt.Errorf("%w: %s", UnknownSourceTopic, d.Topic)
+	}
+	return handler(ctx, d)
+}
@@ -0,0 +1,124 @@
+package replay_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/service/replay"
+	"zombie_locator/internal/storage/broker"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Run(t *testing.T) {
+	now := time.Now()
+	messages := []kafka.Message{
+		deadMessage(t, "zombie_locations", "failed store zombie location: db is down", "loc-1", now.Add(-time.Hour)),
+		deadMessage(t, "zombie_locations", "unsupported message structure", "loc-2", now),
+		deadMessage(t, "captured_zombies", "failed to update zombie status: db is down", "cap-1", now),
+		{Value: []byte("not a dead message"), Time: now},
+		// record written before versioning, kafka time is used as failure time
+		{Value: []byte(`{"topic":"zombie_locations","error":"db is down","msg":"bG9jLTM="}`), Time: now.Add(-time.Hour)},
+	}
+	filter := replay.Filter{
+		ErrorContains: "db is down",
+		From:          now.Add(-time.Minute),
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		replayed := make(map[string][]string)
+		summary, err := newService(t, messages, replayed).Run(context.Background(), filter, true)
+		require.NoError(t, err)
+		require.Equal(t, 5, summary.Read)
+		require.Equal(t, 1, summary.Broken)
+		require.Equal(t, 1, summary.Matched)
+		require.Equal(t, 0, summary.Replayed)
+		require.Equal(t, map[string]int{"captured_zombies": 1}, summary.ByTopic)
+		require.Empty(t, replayed)
+	})
+
+	t.Run("messages dead-lettered after the start are not read", func(t *testing.T) {
+		replayed := make(map[string][]string)
+		later := append(append([]kafka.Message(nil), messages...),
+			deadMessage(t, "captured_zombies", "failed to update zombie status: db is down", "cap-2", now))
+		summary, err := newService(t, later, replayed).Run(context.Background(), filter, true)
+		require.NoError(t, err)
+		require.Equal(t, 5, summary.Read)
+		require.Equal(t, 1, summary.Matched)
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		replayed := make(map[string][]string)
+		summary, err := newService(t, messages, replayed).Run(context.Background(), replay.Filter{SourceTopic: "zombie_locations"}, false)
+		require.NoError(t, err)
+		require.Equal(t, 3, summary.Matched)
+		require.Equal(t, 2, summary.Replayed)
+		require.Equal(t, 1, summary.Failed)
+		require.Equal(t, map[string][]string{"zombie_locations": {"loc-1", "loc-2", "loc-3"}}, replayed)
+	})
+}
+
+func newService(t *testing.T, messages []kafka.Message, replayed map[string][]string) *replay.Service {
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+	handler := func(topic string) replay.Handler {
+		return replay.ConsumerHandler(func(ctx context.Context, msg []byte) error {
+			replayed[topic] = append(replayed[topic], string(msg))
+			if string(msg) == "loc-2" {
+				return errors.New("still broken")
+			}
+			return nil
+		})
+	}
+	queue := make([]kafka.Message, len(messages))
+	for i, m := range messages {
+		m.Offset = int64(i)
+		queue[i] = m
+	}
+	return replay.NewReplayService(appLog, []replay.Partition{
+		// empty partition, its reader blocks until ctx is done
+		{ID: 0, Reader: &fakeReader{}, Start: 3, End: 3},
+		{ID: 1, Reader: &fakeReader{queue: queue}, Start: 0, End: 5},
+	}, map[string]replay.Handler{
+		"zombie_locations": handler("zombie_locations"),
+		"captured_zombies": handler("captured_zombies"),
+	})
+}
+
+func deadMessage(t *testing.T, topic, errMsg, msg string, failedAt time.Time) kafka.Message {
+	data, err := json.Marshal(broker.DeadMessage{
+		Version:  broker.DeadMessageVersion,
+		Topic:    topic,
+		Error:    errMsg,
+		Msg:      []byte(msg),
+		FailedAt: failedAt,
+	})
+	require.NoError(t, err)
+	return kafka.Message{Value: data, Time: time.Now()}
+}
+
+type fakeReader struct {
+	queue []kafka.Message
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	if len(r.queue) == 0 {
+		<-ctx.Done()
+		return kafka.Message{}, ctx.Err()
+	}
+	m := r.queue[0]
+	r.queue = r.queue[1:]
+	return m, nil
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	return nil
+}
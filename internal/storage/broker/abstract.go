@@ -2,17 +2,48 @@ package broker
 
 import (
 	"context"
+
+	"github.com/segmentio/kafka-go"
 )
 
 // Handler provides message processing capabilities.
 type Handler func(ctx context.Context, msg []byte) error
 
+// BatchHandler processes the batch of messages at once. It returns failures of the separate messages keyed
+// by their index in the batch, such messages go to the dead letter queue. Failure of the whole batch is retried.
+type BatchHandler func(ctx context.Context, msgs []kafka.Message) (map[int]error, error)
+
 type Consumer interface {
 	Run(ctx context.Context, handler Handler) error
+	// RunBatch consumes messages in batches, offsets are committed once the whole batch is processed.
+	RunBatch(ctx context.Context, handler BatchHandler) error
 	Shutdown() error
 }
 
 type Producer interface {
-	WriteDeadMessages(ctx context.Context, srcTopic string, err error, msg []byte) error
+	WriteDeadMessages(ctx context.Context, d DeadMessage) error
 	Shutdown() error
 }
+
+// MessageReader provides messages fetching and offsets committing, implemented by kafka.Reader.
+type MessageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Pauser pauses the consumption while messages can not be processed, e.g. storage is down.
+type Pauser interface {
+	// Paused reports whether the consumption is paused.
+	Paused() bool
+	// WaitResume blocks until the consumption is resumed or ctx is done.
+	WaitResume(ctx context.Context) error
+}
+
+// GroupClient provides the cluster metadata and the consumer group state, implemented by kafka.Client.
+type GroupClient interface {
+	Metadata(ctx context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error)
+	DescribeGroups(ctx context.Context, req *kafka.DescribeGroupsRequest) (*kafka.DescribeGroupsResponse, error)
+	OffsetFetch(ctx context.Context, req *kafka.OffsetFetchRequest) (*kafka.OffsetFetchResponse, error)
+	ListOffsets(ctx context.Context, req *kafka.ListOffsetsRequest) (*kafka.ListOffsetsResponse, error)
+}
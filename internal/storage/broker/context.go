@@ -0,0 +1,76 @@
+package broker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type messageKey struct{}
+
+// ContextWithMessage returns ctx carrying the consumed message, so handlers can access its key and headers.
+func ContextWithMessage(ctx context.Context, m kafka.Message) context.Context {
+	return context.WithValue(ctx, messageKey{}, m)
+}
+
+// MessageFromContext returns the consumed message stored in ctx.
+func MessageFromContext(ctx context.Context) (kafka.Message, bool) {
+	m, ok := ctx.Value(messageKey{}).(kafka.Message)
+	return m, ok
+}
+
+// HeaderFromContext returns the last value of the consumed message header.
+func HeaderFromContext(ctx context.Context, key string) (string, bool) {
+	m, ok := MessageFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	for i := len(m.Headers) - 1; i >= 0; i-- {
+		if m.Headers[i].Key == key {
+			return string(m.Headers[i].Value), true
+		}
+	}
+	return "", false
+}
+
+type schemaVersionsKey struct{}
+
+// schemaVersions collects the schema versions the handler resolved while decoding the consumed messages.
+type schemaVersions struct {
+	mu       sync.Mutex
+	versions map[messagePosition]int
+}
+
+type messagePosition struct {
+	partition int
+	offset    int64
+}
+
+func contextWithSchemaVersions(ctx context.Context) (context.Context, *schemaVersions) {
+	versions := &schemaVersions{versions: make(map[messagePosition]int)}
+	return context.WithValue(ctx, schemaVersionsKey{}, versions), versions
+}
+
+func (s *schemaVersions) get(m kafka.Message) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.versions[messagePosition{partition: m.Partition, offset: m.Offset}]
+	return v, ok
+}
+
+// RecordSchemaVersion reports the schema version the consumed message of ctx was decoded with,
+// so the consumer metrics are labeled by it. It does nothing outside of the consumer.
+func RecordSchemaVersion(ctx context.Context, version int) {
+	s, ok := ctx.Value(schemaVersionsKey{}).(*schemaVersions)
+	if !ok {
+		return
+	}
+	m, ok := MessageFromContext(ctx)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[messagePosition{partition: m.Partition, offset: m.Offset}] = version
+}
@@ -0,0 +1,137 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// DeadMessageVersion is the current version of the dead letter queue envelope.
+	// Version 1 records were written before versioning and keep only topic, error and msg.
+	DeadMessageVersion = 2
+
+	// headers added to the dead letter queue record next to the original message headers.
+	HeaderDeadVersion       = "dlq-version"
+	HeaderDeadTopic         = "dlq-source-topic"
+	HeaderDeadPartition     = "dlq-source-partition"
+	HeaderDeadOffset        = "dlq-source-offset"
+	HeaderDeadTimestamp     = "dlq-source-timestamp"
+	HeaderDeadConsumerGroup = "dlq-consumer-group"
+	HeaderDeadFailedAt      = "dlq-failed-at"
+	HeaderDeadAttempts      = "dlq-attempts"
+	HeaderDeadError         = "dlq-error"
+)
+
+var UnsupportedDeadMessageVersion = errors.New("unsupported dead message version")
+
+// DeadMessageHeader is the header of the original message.
+type DeadMessageHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// DeadMessage is the dead letter queue record, it keeps the failed message with the failure reason
+// and the kafka metadata of the original message.
+type DeadMessage struct {
+	Version       int                 `json:"v"`
+	Topic         string              `json:"topic"`
+	Partition     int                 `json:"partition"`
+	Offset        int64               `json:"offset"`
+	Key           []byte              `json:"key,omitempty"`
+	Headers       []DeadMessageHeader `json:"headers,omitempty"`
+	Timestamp     time.Time           `json:"timestamp"`
+	ConsumerGroup string              `json:"consumer_group"`
+	FailedAt      time.Time           `json:"failed_at"`
+	Attempts      int                 `json:"attempts"`
+	Error         string              `json:"error"`
+	Msg           []byte              `json:"msg"`
+}
+
+// NewDeadMessage wraps the message failed in the consumer group after the given number of attempts.
+func NewDeadMessage(m kafka.Message, consumerGroup string, attempts int, err error) DeadMessage {
+	headers := make([]DeadMessageHeader, 0, len(m.Headers))
+	for _, h := range m.Headers {
+		headers = append(headers, DeadMessageHeader{Key: h.Key, Value: h.Value})
+	}
+	return DeadMessage{
+		Version:       DeadMessageVersion,
+		Topic:         m.Topic,
+		Partition:     m.Partition,
+		Offset:        m.Offset,
+		Key:           m.Key,
+		Headers:       headers,
+		Timestamp:     m.Time,
+		ConsumerGroup: consumerGroup,
+		FailedAt:      time.Now().UTC(),
+		Attempts:      attempts,
+		Error:         err.Error(),
+		Msg:           m.Value,
+	}
+}
+
+// DecodeDeadMessage parses the dead letter queue record.
+func DecodeDeadMessage(data []byte) (DeadMessage, error) {
+	var d DeadMessage
+	if err := json.Unmarshal(data, &d); err != nil {
+		return DeadMessage{}, fmt.Errorf("failed to unmarshal dead message: %w", err)
+	}
+	switch d.Version {
+	case 0:
+		// record written before versioning
+		d.Version = 1
+	case 1, DeadMessageVersion:
+	default:
+		return DeadMessage{}, fmt.Errorf("%w: %d", UnsupportedDeadMessageVersion, d.Version)
+	}
+	return d, nil
+}
+
+// kafkaMessage builds the dead letter queue record, keyed by the original key to keep the order per key.
+func (d DeadMessage) kafkaMessage() (kafka.Message, error) {
+	data, err := json.Marshal(&d)
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("failed to marshal dead message: %w", err)
+	}
+	headers := make([]kafka.Header, 0, len(d.Headers)+9)
+	for _, h := range d.Headers {
+		headers = append(headers, kafka.Header{Key: h.Key, Value: h.Value})
+	}
+	headers = append(headers,
+		kafka.Header{Key: HeaderDeadVersion, Value: []byte(strconv.Itoa(d.Version))},
+		kafka.Header{Key: HeaderDeadTopic, Value: []byte(d.Topic)},
+		kafka.Header{Key: HeaderDeadPartition, Value: []byte(strconv.Itoa(d.Partition))},
+		kafka.Header{Key: HeaderDeadOffset, Value: []byte(strconv.FormatInt(d.Offset, 10))},
+		kafka.Header{Key: HeaderDeadTimestamp, Value: []byte(d.Timestamp.Format(time.RFC3339Nano))},
+		kafka.Header{Key: HeaderDeadConsumerGroup, Value: []byte(d.ConsumerGroup)},
+		kafka.Header{Key: HeaderDeadFailedAt, Value: []byte(d.FailedAt.Format(time.RFC3339Nano))},
+		kafka.Header{Key: HeaderDeadAttempts, Value: []byte(strconv.Itoa(d.Attempts))},
+		kafka.Header{Key: HeaderDeadError, Value: []byte(d.Error)},
+	)
+	return kafka.Message{
+		Key:     d.Key,
+		Value:   data,
+		Headers: headers,
+	}, nil
+}
+
+// OriginalMessage restores the message as it was consumed from the source topic.
+func (d DeadMessage) OriginalMessage() kafka.Message {
+	headers := make([]kafka.Header, 0, len(d.Headers))
+	for _, h := range d.Headers {
+		headers = append(headers, kafka.Header{Key: h.Key, Value: h.Value})
+	}
+	return kafka.Message{
+		Topic:     d.Topic,
+		Partition: d.Partition,
+		Offset:    d.Offset,
+		Key:       d.Key,
+		Headers:   headers,
+		Time:      d.Timestamp,
+		Value:     d.Msg,
+	}
+}
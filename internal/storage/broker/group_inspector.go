@@ -0,0 +1,164 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const defaultInspectorTimeout = 5 * time.Second
+
+// NotMember is returned when the consumer is not the member of its group, e.g. the group is rebalanced.
+var NotMember = errors.New("consumer is not the group member")
+
+// InspectorConfig defines the consumer group to inspect.
+type InspectorConfig struct {
+	Brokers []string
+	GroupID string
+	Topic   string
+	// ClientID is the client id of the consumer, it tells the consumer apart from the other group members.
+	ClientID string
+	// Timeout bounds each request to the brokers.
+	Timeout time.Duration
+}
+
+// GroupInspector reports the state of the consumer group as the brokers see it.
+type GroupInspector struct {
+	client   GroupClient
+	groupID  string
+	topic    string
+	clientID string
+}
+
+// NewGroupInspector sets up the inspector of the consumer group on top of the kafka client.
+func NewGroupInspector(cfg InspectorConfig) *GroupInspector {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultInspectorTimeout
+	}
+	return NewGroupInspectorWithClient(&kafka.Client{
+		Addr:    kafka.TCP(cfg.Brokers...),
+		Timeout: cfg.Timeout,
+	}, cfg)
+}
+
+// NewGroupInspectorWithClient sets up the inspector of the consumer group on top of the given client.
+func NewGroupInspectorWithClient(client GroupClient, cfg InspectorConfig) *GroupInspector {
+	return &GroupInspector{
+		client:   client,
+		groupID:  cfg.GroupID,
+		topic:    cfg.Topic,
+		clientID: cfg.ClientID,
+	}
+}
+
+// Ping checks that the brokers are reachable and know the topic.
+func (g *GroupInspector) Ping(ctx context.Context) error {
+	_, err := g.partitions(ctx)
+	return err
+}
+
+// Member checks that the consumer is the member of the group subscribed to the topic.
+// It returns NotMember while the consumer has not joined the group yet or the group is rebalanced.
+func (g *GroupInspector) Member(ctx context.Context) error {
+	resp, err := g.client.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{GroupIDs: []string{g.groupID}})
+	if err != nil {
+		return fmt.Errorf("unable to describe group %s: %w", g.groupID, err)
+	}
+	for _, group := range resp.Groups {
+		if group.GroupID != g.groupID {
+			continue
+		}
+		if group.Error != nil {
+			return fmt.Errorf("unable to describe group %s: %w", g.groupID, group.Error)
+		}
+		for _, m := range group.Members {
+			if m.ClientID != g.clientID {
+				continue
+			}
+			for _, t := range m.MemberAssignments.Topics {
+				if t.Topic == g.topic {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("%w: %s is not assigned %s in %s group", NotMember, g.clientID, g.topic, group.GroupState)
+	}
+	return fmt.Errorf("%w: group %s is not found", NotMember, g.groupID)
+}
+
+// Lag returns the number of messages of the topic which are not committed by the group yet.
+// Partitions without committed offset lag by all the messages they keep.
+func (g *GroupInspector) Lag(ctx context.Context) (int64, error) {
+	partitions, err := g.partitions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	requests := make([]kafka.OffsetRequest, 0, 2*len(partitions))
+	for _, p := range partitions {
+		requests = append(requests, kafka.FirstOffsetOf(p), kafka.LastOffsetOf(p))
+	}
+	listed, err := g.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{g.topic: requests},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to list offsets of %s: %w", g.topic, err)
+	}
+	fetched, err := g.client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: g.groupID,
+		Topics:  map[string][]int{g.topic: partitions},
+	})
+	if err == nil {
+		err = fetched.Error
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to fetch committed offsets of %s: %w", g.topic, err)
+	}
+
+	committed := make(map[int]int64, len(partitions))
+	for _, p := range fetched.Topics[g.topic] {
+		if p.Error != nil {
+			return 0, fmt.Errorf("unable to fetch committed offset of %s/%d: %w", g.topic, p.Partition, p.Error)
+		}
+		committed[p.Partition] = p.CommittedOffset
+	}
+	var lag int64
+	for _, p := range listed.Topics[g.topic] {
+		if p.Error != nil {
+			return 0, fmt.Errorf("unable to list offsets of %s/%d: %w", g.topic, p.Partition, p.Error)
+		}
+		offset, ok := committed[p.Partition]
+		if !ok || offset < p.FirstOffset {
+			// nothing is committed or the committed messages are deleted by the retention
+			offset = p.FirstOffset
+		}
+		if p.LastOffset > offset {
+			lag += p.LastOffset - offset
+		}
+	}
+	return lag, nil
+}
+
+// partitions returns the partitions of the topic.
+func (g *GroupInspector) partitions(ctx context.Context) ([]int, error) {
+	resp, err := g.client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{g.topic}})
+	if err != nil {
+		return nil, fmt.Errorf("kafka is not reachable: %w", err)
+	}
+	for _, t := range resp.Topics {
+		if t.Name != g.topic {
+			continue
+		}
+		if t.Error != nil {
+			return nil, fmt.Errorf("unable to get topic %s: %w", g.topic, t.Error)
+		}
+		partitions := make([]int, 0, len(t.Partitions))
+		for _, p := range t.Partitions {
+			partitions = append(partitions, p.ID)
+		}
+		return partitions, nil
+	}
+	return nil, fmt.Errorf("topic %s is not found", g.topic)
+}
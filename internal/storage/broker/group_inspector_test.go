@@ -0,0 +1,131 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"zombie_locator/internal/storage/broker"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupInspector_Lag(t *testing.T) {
+	client := &fakeGroupClient{
+		partitions: []int{0, 1, 2},
+		offsets: map[int][2]int64{
+			0: {0, 10},
+			1: {5, 20},
+			2: {100, 100},
+		},
+		committed: map[int]int64{
+			0: 7,
+			// partition 1 is never committed, it lags by all its messages
+			1: -1,
+			// messages were committed and then deleted by the retention
+			2: 50,
+		},
+	}
+	inspector := newTestInspector(client)
+
+	lag, err := inspector.Lag(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(3+15), lag)
+	require.NoError(t, inspector.Ping(context.Background()))
+
+	client.err = errors.New("connection refused")
+	_, err = inspector.Lag(context.Background())
+	require.ErrorIs(t, err, client.err)
+	require.ErrorIs(t, inspector.Ping(context.Background()), client.err)
+}
+
+func TestGroupInspector_Member(t *testing.T) {
+	member := func(clientID, topic string) kafka.DescribeGroupsResponseMember {
+		return kafka.DescribeGroupsResponseMember{
+			ClientID: clientID,
+			MemberAssignments: kafka.DescribeGroupsResponseAssignments{
+				Topics: []kafka.GroupMemberTopic{{Topic: topic, Partitions: []int{0}}},
+			},
+		}
+	}
+	client := &fakeGroupClient{group: kafka.DescribeGroupsResponseGroup{
+		GroupID:    testGroup,
+		GroupState: "Stable",
+		Members: []kafka.DescribeGroupsResponseMember{
+			member("other-node", testTopic),
+			member("this-node", "captured_zombies"),
+		},
+	}}
+	inspector := newTestInspector(client)
+	require.ErrorIs(t, inspector.Member(context.Background()), broker.NotMember)
+
+	client.group.Members = append(client.group.Members, member("this-node", testTopic))
+	require.NoError(t, inspector.Member(context.Background()))
+
+	client.group = kafka.DescribeGroupsResponseGroup{GroupID: "other-group"}
+	require.ErrorIs(t, inspector.Member(context.Background()), broker.NotMember)
+}
+
+func newTestInspector(client broker.GroupClient) *broker.GroupInspector {
+	return broker.NewGroupInspectorWithClient(client, broker.InspectorConfig{
+		GroupID:  testGroup,
+		Topic:    testTopic,
+		ClientID: "this-node",
+	})
+}
+
+// fakeGroupClient serves the state of the single topic and group.
+type fakeGroupClient struct {
+	partitions []int
+	// offsets keeps the first and the last offset of the partition.
+	offsets   map[int][2]int64
+	committed map[int]int64
+	group     kafka.DescribeGroupsResponseGroup
+	err       error
+}
+
+func (f *fakeGroupClient) Metadata(ctx context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	topic := kafka.Topic{Name: testTopic}
+	for _, p := range f.partitions {
+		topic.Partitions = append(topic.Partitions, kafka.Partition{Topic: testTopic, ID: p})
+	}
+	return &kafka.MetadataResponse{Topics: []kafka.Topic{topic}}, nil
+}
+
+func (f *fakeGroupClient) DescribeGroups(ctx context.Context, req *kafka.DescribeGroupsRequest) (*kafka.DescribeGroupsResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &kafka.DescribeGroupsResponse{Groups: []kafka.DescribeGroupsResponseGroup{f.group}}, nil
+}
+
+func (f *fakeGroupClient) OffsetFetch(ctx context.Context, req *kafka.OffsetFetchRequest) (*kafka.OffsetFetchResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var partitions []kafka.OffsetFetchPartition
+	for _, p := range req.Topics[testTopic] {
+		partitions = append(partitions, kafka.OffsetFetchPartition{Partition: p, CommittedOffset: f.committed[p]})
+	}
+	return &kafka.OffsetFetchResponse{Topics: map[string][]kafka.OffsetFetchPartition{testTopic: partitions}}, nil
+}
+
+func (f *fakeGroupClient) ListOffsets(ctx context.Context, req *kafka.ListOffsetsRequest) (*kafka.ListOffsetsResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	seen := make(map[int]bool)
+	var partitions []kafka.PartitionOffsets
+	for _, r := range req.Topics[testTopic] {
+		if seen[r.Partition] {
+			continue
+		}
+		seen[r.Partition] = true
+		offsets := f.offsets[r.Partition]
+		partitions = append(partitions, kafka.PartitionOffsets{Partition: r.Partition, FirstOffset: offsets[0], LastOffset: offsets[1]})
+	}
+	return &kafka.ListOffsetsResponse{Topics: map[string][]kafka.PartitionOffsets{testTopic: partitions}}, nil
+}
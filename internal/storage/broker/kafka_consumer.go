@@ -2,81 +2,727 @@ package broker
 
 import (
 	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
 	"zombie_locator/internal/logger"
+	"zombie_locator/internal/metrics"
+	"zombie_locator/internal/tracing"
+	"zombie_locator/internal/utils/failure"
 
 	"go.uber.org/zap"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/segmentio/kafka-go"
 )
 
+const (
+	defaultCommitInterval      = time.Second
+	defaultCommitBatchSize     = 100
+	defaultRetryMaxAttempts    = 8
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 5 * time.Second
+	defaultWorkers             = 1
+	defaultBatchSize           = 500
+	defaultBatchTimeout        = 100 * time.Millisecond
+	defaultDrainTimeout        = 10 * time.Second
+
+	// maxLabeledVersion bounds the schema versions the metrics are labeled by.
+	maxLabeledVersion = 99
+)
+
+// ConsumerConfig defines a Kafka consumer settings.
+type ConsumerConfig struct {
+	Brokers []string
+	GroupID string
+	Topic   string
+	// ClientID tells the consumer apart from the other group members, e.g. by the readiness check. Optional.
+	ClientID string
+	// CommitInterval is the max time processed messages wait before offsets are committed.
+	CommitInterval time.Duration
+	// CommitBatchSize is the max number of processed messages waiting before offsets are committed.
+	CommitBatchSize int
+	// RetryMaxAttempts is the max number of handler calls for the message failed with transient error.
+	RetryMaxAttempts int
+	// RetryInitialBackoff is the delay before the first retry, it doubles on each next retry up to RetryMaxBackoff.
+	RetryInitialBackoff time.Duration
+	RetryMaxBackoff     time.Duration
+	// Workers is the number of messages handled concurrently. Messages with the same key are handled
+	// by the same worker in the fetch order, messages without key are sharded by partition.
+	Workers int
+	// BatchSize is the max number of messages passed to the batch handler at once.
+	BatchSize int
+	// BatchTimeout is the max time the batch is collected since its first message was fetched.
+	BatchTimeout time.Duration
+	// Pauser stops the fetching while it is paused, optional.
+	Pauser Pauser
+	// DrainTimeout is the time the in-flight messages have to be handled once the consumer is stopped,
+	// handlers are interrupted after it and their messages are consumed again after restart.
+	DrainTimeout time.Duration
+	// Metrics records the consumption, optional.
+	Metrics *metrics.Consumer
+	// VersionHeader is the header with the schema version the consumed messages are counted by, optional.
+	VersionHeader string
+}
+
 // KafkaConsumer defines a Kafka messages consumer.
+// Offsets are committed only for the messages which were handled or put in the dead letter queue,
+// so the delivery is at-least-once.
+// Failed messages are retried in place, only permanent failures and failures which exhausted
+// the retry budget go to the dead letter queue.
+// Messages are handled by the pool of workers, the offset is committed only when all the previous messages
+// of the partition are processed.
+// The consumer can be run again after the failure, the reader is reopened then, so the messages which were
+// fetched and not committed are consumed again.
 type KafkaConsumer struct {
+	log       logger.AppLogger
+	newReader func() MessageReader
+	reader    MessageReader
+	dlq       Producer
+	topic     string
+	groupID   string
+
+	commitInterval      time.Duration
+	commitBatchSize     int
+	retryMaxAttempts    int
+	retryInitialBackoff time.Duration
+	retryMaxBackoff     time.Duration
+	workers             int
+	batchSize           int
+	batchTimeout        time.Duration
+	pauser              Pauser
+	drainTimeout        time.Duration
+	metrics             *metrics.Consumer
+	versionHeader       string
+
+	mu sync.Mutex
+	// exitMark is closed when the current run returns, nil before the first run.
 	exitMark chan struct{}
-	log      logger.AppLogger
-	reader   *kafka.Reader
-	dlq      Producer
-	topic    string
+	// stopFetching stops the current run, its in-flight messages are drained.
+	stopFetching context.CancelFunc
+	// failed is set when the last run failed, the reader position is ahead of the committed offsets then.
+	failed bool
+	// offsets keeps fetched messages until they are committed.
+	offsets *offsetTracker
+	// pendingCount is the number of processed and not yet committed messages.
+	pendingCount int
+	// drainDeadline is the time the stopped run has to finish by, zero while it is not stopped.
+	drainDeadline time.Time
 }
 
 // NewKafkaConsumer sets up a new kafka consumer for the given topic using the provided handler.
-func NewKafkaConsumer(log logger.AppLogger, dlq Producer, brokerAddrs []string, consumerGroupID, topic string) *KafkaConsumer {
+func NewKafkaConsumer(log logger.AppLogger, dlq Producer, cfg ConsumerConfig) *KafkaConsumer {
+	dialer := *kafka.DefaultDialer
+	if cfg.ClientID != "" {
+		dialer.ClientID = cfg.ClientID
+	}
+	return NewKafkaConsumerWithReader(log, dlq, func() MessageReader {
+		return kafka.NewReader(kafka.ReaderConfig{
+			Brokers: cfg.Brokers,
+			GroupID: cfg.GroupID,
+			Topic:   cfg.Topic,
+			Dialer:  &dialer,
+		})
+	}, cfg)
+}
+
+// NewKafkaConsumerWithReader sets up a new kafka consumer on top of the readers opened by newReader.
+// The reader is opened once and then again on each run after the failure.
+func NewKafkaConsumerWithReader(log logger.AppLogger, dlq Producer, newReader func() MessageReader, cfg ConsumerConfig) *KafkaConsumer {
+	if cfg.CommitInterval <= 0 {
+		cfg.CommitInterval = defaultCommitInterval
+	}
+	if cfg.CommitBatchSize <= 0 {
+		cfg.CommitBatchSize = defaultCommitBatchSize
+	}
+	if cfg.RetryMaxAttempts <= 0 {
+		cfg.RetryMaxAttempts = defaultRetryMaxAttempts
+	}
+	if cfg.RetryInitialBackoff <= 0 {
+		cfg.RetryInitialBackoff = defaultRetryInitialBackoff
+	}
+	if cfg.RetryMaxBackoff < cfg.RetryInitialBackoff {
+		cfg.RetryMaxBackoff = defaultRetryMaxBackoff
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.BatchTimeout <= 0 {
+		cfg.BatchTimeout = defaultBatchTimeout
+	}
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = defaultDrainTimeout
+	}
+	if cfg.Metrics == nil {
+		cfg.Metrics = metrics.NewConsumer(nil)
+	}
 	return &KafkaConsumer{
-		exitMark: make(chan struct{}),
-		dlq:      dlq,
+		dlq: dlq,
 		log: log.With(zap.String("service", "kafka_consumer")).
-			With(zap.String("group_id", consumerGroupID)).
-			With(zap.String("topic", topic)),
-		reader: kafka.NewReader(kafka.ReaderConfig{
-			Brokers: brokerAddrs,
-			GroupID: consumerGroupID,
-			Topic:   topic,
-		}),
-		topic: topic,
+			With(zap.String("group_id", cfg.GroupID)).
+			With(zap.String("topic", cfg.Topic)),
+		newReader:           newReader,
+		reader:              newReader(),
+		topic:               cfg.Topic,
+		groupID:             cfg.GroupID,
+		commitInterval:      cfg.CommitInterval,
+		commitBatchSize:     cfg.CommitBatchSize,
+		retryMaxAttempts:    cfg.RetryMaxAttempts,
+		retryInitialBackoff: cfg.RetryInitialBackoff,
+		retryMaxBackoff:     cfg.RetryMaxBackoff,
+		workers:             cfg.Workers,
+		batchSize:           cfg.BatchSize,
+		batchTimeout:        cfg.BatchTimeout,
+		pauser:              cfg.Pauser,
+		drainTimeout:        cfg.DrainTimeout,
+		metrics:             cfg.Metrics,
+		versionHeader:       cfg.VersionHeader,
+		offsets:             newOffsetTracker(),
+	}
+}
+
+// Run starts the kafka consumer. Once ctx is done the fetching stops and the in-flight messages
+// are handled within the drain timeout. Pending commits are flushed before it returns.
+func (p *KafkaConsumer) Run(ctx context.Context, handler Handler) error {
+	return p.run(ctx, func(fetchCtx, handleCtx context.Context) error {
+		return p.consume(fetchCtx, handleCtx, handler)
+	})
+}
+
+// RunBatch starts the kafka consumer passing messages to the handler in batches. Once ctx is done
+// the fetching stops and the in-flight batch is handled within the drain timeout.
+// Pending commits are flushed before it returns.
+func (p *KafkaConsumer) RunBatch(ctx context.Context, handler BatchHandler) error {
+	return p.run(ctx, func(fetchCtx, handleCtx context.Context) error {
+		return p.consumeBatch(fetchCtx, handleCtx, handler)
+	})
+}
+
+// run consumes messages until ctx is done or the consumer is shut down. Fetching stops with fetchCtx,
+// while handlers get handleCtx which is canceled only when the drain timeout passes after that.
+func (p *KafkaConsumer) run(ctx context.Context, consume func(fetchCtx, handleCtx context.Context) error) (err error) {
+	fetchCtx, stopFetching := context.WithCancel(ctx)
+	defer stopFetching()
+	exitMark := p.begin(stopFetching)
+	defer close(exitMark)
+
+	handleCtx, abortHandlers := context.WithCancel(context.Background())
+	defer abortHandlers()
+	go p.drain(fetchCtx, handleCtx, abortHandlers)
+
+	// flusher keeps committing while the in-flight messages are drained
+	flusherCtx, stopFlusher := context.WithCancel(handleCtx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.runFlusher(flusherCtx)
+	}()
+
+	err = consume(fetchCtx, handleCtx)
+	stopFlusher()
+	wg.Wait()
+
+	// ctx can be already canceled here, commit anyway to not re-read processed messages after restart.
+	commitCtx, cancelCommit := p.commitContext()
+	fErr := p.flush(commitCtx)
+	cancelCommit()
+	if fErr != nil {
+		p.log.Error("failed to flush commits", fErr)
+		if err == nil {
+			err = fErr
+		}
+	}
+	if err != nil {
+		p.mu.Lock()
+		p.failed = true
+		p.mu.Unlock()
+	}
+	return err
+}
+
+// begin marks the run started. After the failed run the reader is reopened from the committed offsets
+// and the messages which were not committed are forgotten, they are fetched again.
+func (p *KafkaConsumer) begin(stopFetching context.CancelFunc) chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failed {
+		if err := p.reader.Close(); err != nil {
+			p.log.Error("failed to close reader", err)
+		}
+		p.reader = p.newReader()
+		p.offsets = newOffsetTracker()
+		p.pendingCount = 0
+		p.failed = false
 	}
+	p.drainDeadline = time.Time{}
+	p.exitMark = make(chan struct{})
+	p.stopFetching = stopFetching
+	return p.exitMark
 }
 
-// Run starts the kafka consumer.
-func (p *KafkaConsumer) Run(ctx context.Context, handler Handler) (err error) {
-	defer close(p.exitMark)
-loop:
+// drain aborts the handlers when they do not finish the in-flight messages within the drain timeout
+// after the fetching is stopped. It returns once the run is over.
+func (p *KafkaConsumer) drain(fetchCtx, handleCtx context.Context, abortHandlers context.CancelFunc) {
+	select {
+	case <-handleCtx.Done():
+		return
+	case <-fetchCtx.Done():
+	}
+	p.mu.Lock()
+	p.drainDeadline = time.Now().Add(p.drainTimeout)
+	p.mu.Unlock()
+	timer := time.NewTimer(p.drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-handleCtx.Done():
+	case <-timer.C:
+		p.log.Info("in-flight messages are not handled within the drain timeout, handlers are interrupted",
+			zap.Duration("drain_timeout", p.drainTimeout))
+		abortHandlers()
+	}
+}
+
+// consume fetches messages and dispatches them to the workers by the message key.
+// Once fetchCtx is done the workers finish the messages they have got.
+func (p *KafkaConsumer) consume(fetchCtx, handleCtx context.Context, handler Handler) error {
+	workerCtx, stopWorkers := context.WithCancel(handleCtx)
+	defer stopWorkers()
+	dispatchCtx, stopDispatch := context.WithCancel(fetchCtx)
+	defer stopDispatch()
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		failErr  error
+	)
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failErr = err
+			stopWorkers()
+			stopDispatch()
+		})
+	}
+	queues := make([]chan kafka.Message, p.workers)
+	for i := range queues {
+		queues[i] = make(chan kafka.Message)
+		wg.Add(1)
+		go func(queue chan kafka.Message) {
+			defer wg.Done()
+			p.work(workerCtx, handler, queue, fail)
+		}(queues[i])
+	}
+
+	fetchErr := p.dispatch(dispatchCtx, queues)
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+	if failErr != nil {
+		return failErr
+	}
+	if fetchErr != nil && fetchCtx.Err() == nil {
+		return fmt.Errorf("failed to fetch message: %w", fetchErr)
+	}
+	return nil
+}
+
+// dispatch fetches messages until ctx is done or fetching fails.
+func (p *KafkaConsumer) dispatch(ctx context.Context, queues []chan kafka.Message) error {
 	for {
+		// reader can return buffered messages with the canceled context
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := p.waitResume(ctx); err != nil {
+			return nil
+		}
+		// Get the next message to consume from the broker
+		m, err := p.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		p.track(m)
 		select {
+		case queues[shard(m, len(queues))] <- m:
 		case <-ctx.Done():
-			break loop
-		default:
-			// Get the next message to consume from the broker
-			m, err := p.reader.FetchMessage(ctx)
-			if err != nil {
-				return err
+			// not handled message is not committed and will be consumed again.
+			return nil
+		}
+	}
+}
+
+// work handles messages of its queue one by one. After the first failure the rest of the queue is skipped.
+func (p *KafkaConsumer) work(ctx context.Context, handler Handler, queue chan kafka.Message, fail func(err error)) {
+	for m := range queue {
+		if ctx.Err() != nil {
+			continue
+		}
+		if err := p.process(ctx, handler, m); err != nil {
+			fail(err)
+		}
+	}
+}
+
+// process handles the message and marks it processed. Errors are returned only when the consumption must stop.
+func (p *KafkaConsumer) process(ctx context.Context, handler Handler, m kafka.Message) error {
+	spanCtx, span := p.startProcessSpan(ctx, m)
+	spanCtx, versions := contextWithSchemaVersions(spanCtx)
+	attempts, err := p.handle(spanCtx, handler, m)
+	tracing.End(span, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			// interrupted by shutdown, the message is not committed and will be consumed again.
+			return nil
+		}
+		p.metrics.Failed.WithLabelValues(p.topic, p.version(versions, m)).Inc()
+		// dead letter keeps the trace context of the failed processing
+		if dlqErr := p.putInDeadLetter(spanCtx, m, attempts, err); dlqErr != nil {
+			// message is neither processed nor saved, stop here to get it again after restart.
+			p.log.Error("failed to put message in dead letter queue", dlqErr,
+				zap.Int("partition", m.Partition),
+				zap.Int64("offset", m.Offset))
+			return fmt.Errorf("failed to handle message: %w", dlqErr)
+		}
+	}
+	p.metrics.Consumed.WithLabelValues(p.topic, p.version(versions, m)).Inc()
+	return p.markProcessed(ctx, m)
+}
+
+// shard returns the worker index of the message, so messages of the same zombie are handled in order.
+func shard(m kafka.Message, workers int) int {
+	h := fnv.New32a()
+	if len(m.Key) > 0 {
+		_, _ = h.Write(m.Key)
+	} else {
+		_, _ = h.Write([]byte(strconv.Itoa(m.Partition)))
+	}
+	return int(h.Sum32() % uint32(workers))
+}
+
+// consumeBatch fetches and handles batches one by one. Once fetchCtx is done the fetched batch is finished.
+func (p *KafkaConsumer) consumeBatch(fetchCtx, handleCtx context.Context, handler BatchHandler) error {
+	for {
+		// reader can return buffered messages with the canceled context
+		if fetchCtx.Err() != nil {
+			return nil
+		}
+		if err := p.waitResume(fetchCtx); err != nil {
+			return nil
+		}
+		batch, err := p.fetchBatch(fetchCtx)
+		if err != nil {
+			if fetchCtx.Err() != nil {
+				// not handled messages are not committed and will be consumed again.
+				return nil
 			}
+			return fmt.Errorf("failed to fetch message: %w", err)
+		}
+		p.track(batch...)
 
-			err = handler(ctx, m.Value)
-			if err == nil {
+		spanCtx, span := p.startBatchSpan(handleCtx, batch)
+		spanCtx, versions := contextWithSchemaVersions(spanCtx)
+		attempts, failed, err := p.handleBatch(spanCtx, handler, batch)
+		tracing.End(span, err)
+		if err != nil {
+			if handleCtx.Err() != nil {
+				// interrupted after the drain timeout, the batch will be consumed again.
+				return nil
+			}
+			// the whole batch is failed, each message goes to the dead letter queue with the batch failure
+			failed = make(map[int]error, len(batch))
+			for i := range batch {
+				failed[i] = err
+			}
+		}
+		for i, m := range batch {
+			mErr, ok := failed[i]
+			if !ok {
 				continue
 			}
-			if err = p.putInDeadLetter(ctx, err, m.Value); err != nil {
-				p.log.Error("failed to handle message", err)
+			p.metrics.Failed.WithLabelValues(p.topic, p.version(versions, m)).Inc()
+			if dlqErr := p.putInDeadLetter(spanCtx, m, attempts, mErr); dlqErr != nil {
+				if handleCtx.Err() != nil {
+					return nil
+				}
+				p.log.Error("failed to put message in dead letter queue", dlqErr,
+					zap.Int("partition", m.Partition),
+					zap.Int64("offset", m.Offset))
+				return fmt.Errorf("failed to handle message: %w", dlqErr)
 			}
+		}
+
+		for _, m := range batch {
+			p.metrics.Consumed.WithLabelValues(p.topic, p.version(versions, m)).Inc()
+		}
+		if err = p.markProcessed(handleCtx, batch...); err != nil {
+			return err
+		}
+	}
+}
+
+// fetchBatch blocks until the first message is fetched, then collects the batch until it is full or batchTimeout passes.
+func (p *KafkaConsumer) fetchBatch(ctx context.Context) ([]kafka.Message, error) {
+	m, err := p.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := make([]kafka.Message, 0, p.batchSize)
+	batch = append(batch, m)
 
-			// Mark the processed message as committed on the broker.
-			if err = p.reader.CommitMessages(ctx, m); err != nil {
-				break loop
+	batchCtx, cancel := context.WithTimeout(ctx, p.batchTimeout)
+	defer cancel()
+	for len(batch) < p.batchSize {
+		m, err = p.reader.FetchMessage(batchCtx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
 			}
+			if batchCtx.Err() != nil {
+				break
+			}
+			return nil, err
+		}
+		batch = append(batch, m)
+	}
+	return batch, nil
+}
+
+// handle calls handler for the message and retries it in place while the failure is not permanent.
+// Errors which are not classified are retried as transient. It returns the number of attempts made.
+func (p *KafkaConsumer) handle(ctx context.Context, handler Handler, m kafka.Message) (int, error) {
+	msgCtx := ContextWithMessage(ctx, m)
+	duration := p.metrics.HandlerDuration.WithLabelValues(p.topic, "message")
+	return p.retry(ctx, func() error {
+		defer observeSince(duration, time.Now())
+		return handler(msgCtx, m.Value)
+	}, zap.Int("partition", m.Partition), zap.Int64("offset", m.Offset))
+}
+
+// handleBatch calls handler for the batch and retries it in place while the failure of the batch is not permanent.
+// It returns the number of attempts made and the failed messages of the successful attempt.
+func (p *KafkaConsumer) handleBatch(ctx context.Context, handler BatchHandler, batch []kafka.Message) (int, map[int]error, error) {
+	first, last := batch[0], batch[len(batch)-1]
+	var failed map[int]error
+	duration := p.metrics.HandlerDuration.WithLabelValues(p.topic, "batch")
+	attempts, err := p.retry(ctx, func() error {
+		defer observeSince(duration, time.Now())
+		var err error
+		failed, err = handler(ctx, batch)
+		return err
+	},
+		zap.Int("size", len(batch)),
+		zap.Int("first_partition", first.Partition),
+		zap.Int64("first_offset", first.Offset),
+		zap.Int("last_partition", last.Partition),
+		zap.Int64("last_offset", last.Offset))
+	return attempts, failed, err
+}
+
+// retry calls fn until it succeeds, fails permanently or the retry budget is exhausted. Waiting for the paused
+// consumption does not use the budget. It returns the number of attempts made.
+func (p *KafkaConsumer) retry(ctx context.Context, fn func() error, fields ...zap.Field) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || failure.IsPermanent(err) {
+			return attempt, err
+		}
+		if p.pauser != nil && p.pauser.Paused() {
+			// dependency is down, the message keeps its retry budget and waits for the recovery.
+			p.log.Error("consumption is paused", err, fields...)
+			if err = p.waitResume(ctx); err != nil {
+				return attempt, err
+			}
+			attempt--
+			continue
+		}
+		if attempt >= p.retryMaxAttempts {
+			return attempt, err
+		}
+		delay := p.backoff(attempt)
+		p.metrics.Retries.WithLabelValues(p.topic).Inc()
+		p.log.Error("failed to handle message, retrying", err,
+			append(fields, zap.Int("attempt", attempt), zap.Duration("delay", delay))...)
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(delay):
 		}
 	}
-	return err
 }
 
-// DeadLetter put failed message to dead letter queue. extra logic can be added here - retry, etc.
-// message can store into db|cache
-func (p *KafkaConsumer) putInDeadLetter(ctx context.Context, err error, msg []byte) error {
-	return p.dlq.WriteDeadMessages(ctx, p.topic, err, msg)
+func (p *KafkaConsumer) waitResume(ctx context.Context) error {
+	if p.pauser == nil {
+		return nil
+	}
+	return p.pauser.WaitResume(ctx)
 }
 
+// backoff returns exponential delay with jitter before the next attempt, so retries of different consumers spread.
+func (p *KafkaConsumer) backoff(attempt int) time.Duration {
+	delay := p.retryMaxBackoff
+	if attempt < 32 {
+		if d := p.retryInitialBackoff << (attempt - 1); d > 0 && d < delay {
+			delay = d
+		}
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// track registers fetched messages, they are committed once they and all the previous messages are processed.
+func (p *KafkaConsumer) track(msgs ...kafka.Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range msgs {
+		p.offsets.track(m)
+	}
+}
+
+// markProcessed stores messages as ready to commit and commits pending messages once the batch is full.
+func (p *KafkaConsumer) markProcessed(ctx context.Context, msgs ...kafka.Message) error {
+	p.mu.Lock()
+	for _, m := range msgs {
+		p.offsets.done(m)
+	}
+	p.pendingCount += len(msgs)
+	full := p.pendingCount >= p.commitBatchSize
+	p.mu.Unlock()
+	if !full {
+		return nil
+	}
+	if err := p.flush(ctx); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+func (p *KafkaConsumer) runFlusher(ctx context.Context) {
+	ticker := time.NewTicker(p.commitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.flush(ctx); err != nil && ctx.Err() == nil {
+				p.log.Error("failed to commit messages", err)
+			}
+		}
+	}
+}
+
+// commitContext bounds the final commit by the drain deadline, so the stopped consumer does not outlive
+// the shutdown timeout while the broker is unreachable. The run which failed by itself gets the whole drain timeout.
+// Messages not committed in time are consumed again after restart.
+func (p *KafkaConsumer) commitContext() (context.Context, context.CancelFunc) {
+	p.mu.Lock()
+	deadline := p.drainDeadline
+	p.mu.Unlock()
+	if deadline.IsZero() {
+		deadline = time.Now().Add(p.drainTimeout)
+	}
+	return context.WithDeadline(context.Background(), deadline)
+}
+
+// flush commits the offsets of the pending messages. On failure messages stay pending.
+func (p *KafkaConsumer) flush(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	msgs := p.offsets.ready()
+	if len(msgs) == 0 {
+		return nil
+	}
+	// Mark the processed messages as committed on the broker.
+	if err := p.reader.CommitMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("failed to commit messages: %w", err)
+	}
+	p.offsets.committed()
+	p.pendingCount = 0
+	return nil
+}
+
+// putInDeadLetter puts failed message to dead letter queue with its kafka metadata and the failure reason.
+func (p *KafkaConsumer) putInDeadLetter(ctx context.Context, m kafka.Message, attempts int, err error) error {
+	if m.Topic == "" {
+		m.Topic = p.topic
+	}
+	if err = p.dlq.WriteDeadMessages(ctx, NewDeadMessage(m, p.groupID, attempts, err)); err != nil {
+		p.metrics.DeadLetterWrites.WithLabelValues(p.topic, "error").Inc()
+		return err
+	}
+	p.metrics.DeadLetterWrites.WithLabelValues(p.topic, "ok").Inc()
+	return nil
+}
+
+// version returns the schema version of the message for the metrics. The version the handler decoded the message
+// with is preferred over the version header, none is returned when neither is known.
+// Any value which is not a small number is reported as invalid, so producers can not blow up the label.
+func (p *KafkaConsumer) version(versions *schemaVersions, m kafka.Message) string {
+	if v, ok := versions.get(m); ok {
+		return labelVersion(v)
+	}
+	if p.versionHeader == "" {
+		return "none"
+	}
+	for i := len(m.Headers) - 1; i >= 0; i-- {
+		if m.Headers[i].Key != p.versionHeader {
+			continue
+		}
+		v, err := strconv.Atoi(string(m.Headers[i].Value))
+		if err != nil {
+			return "invalid"
+		}
+		return labelVersion(v)
+	}
+	return "none"
+}
+
+func labelVersion(v int) string {
+	if v < 0 || v > maxLabeledVersion {
+		return "invalid"
+	}
+	return strconv.Itoa(v)
+}
+
+func observeSince(o prometheus.Observer, startedAt time.Time) {
+	o.Observe(time.Since(startedAt).Seconds())
+}
+
+// Shutdown stops the fetching of the running consumer and waits for its in-flight messages to be handled
+// within the drain timeout. Then it flushes the pending commits, leaves the consumer group, so partitions are
+// reassigned without waiting for the session timeout, and closes the dead letter queue.
 func (p *KafkaConsumer) Shutdown() error {
-	<-p.exitMark
-	if err := p.dlq.Shutdown(); err != nil {
-		p.log.Error("failed to shutdown dead letter queue", err)
+	p.mu.Lock()
+	exitMark, stopFetching := p.exitMark, p.stopFetching
+	p.mu.Unlock()
+	if exitMark != nil {
+		stopFetching()
+		<-exitMark
+	}
+	commitCtx, cancelCommit := p.commitContext()
+	defer cancelCommit()
+	if err := p.flush(commitCtx); err != nil {
+		p.log.Error("failed to flush commits", err)
 	}
-	return p.reader.Close()
+	// kafka reader leaves the consumer group on close
+	err := p.reader.Close()
+	if err != nil {
+		p.log.Error("failed to close reader", err)
+	}
+	if dlqErr := p.dlq.Shutdown(); dlqErr != nil {
+		p.log.Error("failed to shutdown dead letter queue", dlqErr)
+		if err == nil {
+			err = dlqErr
+		}
+	}
+	return err
 }
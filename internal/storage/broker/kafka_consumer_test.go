@@ -0,0 +1,964 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/metrics"
+	"zombie_locator/internal/storage/broker"
+	"zombie_locator/internal/utils/failure"
+	"zombie_locator/internal/utils/shema_registry"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	testTopic = "zombie_locations"
+	testGroup = "zombie-tracker"
+)
+
+func TestKafkaConsumer_CommitsHandledAndDeadMessages(t *testing.T) {
+	kafkaBroker := newFakeBroker(3, 5)
+	dlq := &fakeProducer{}
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), dlq, broker.ConsumerConfig{})
+
+	handled := newHandledSet()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runConsumer(ctx, consumer, func(ctx context.Context, msg []byte) error {
+		handled.add(msg)
+		if string(msg) == "1-2" {
+			return failure.Permanent(errors.New("bad message"))
+		}
+		return nil
+	})
+	require.Eventually(t, func() bool { return handled.len() == 15 }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+
+	require.Equal(t, map[int]int64{0: 5, 1: 5, 2: 5}, kafkaBroker.committedOffsets())
+	require.Equal(t, []string{"1-2"}, dlq.messages())
+}
+
+func TestKafkaConsumer_Metrics(t *testing.T) {
+	kafkaBroker := newFakeBroker(2, 3)
+	// the message without the version and the message with the garbage one
+	kafkaBroker.messages[0].Headers = nil
+	kafkaBroker.messages[1].Headers = []kafka.Header{{Key: "schema-version", Value: []byte("drop table")}}
+	dlq := &fakeProducer{}
+	consumerMetrics := metrics.NewConsumer(nil)
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), dlq, broker.ConsumerConfig{
+		RetryInitialBackoff: time.Millisecond,
+		RetryMaxBackoff:     time.Millisecond,
+		Metrics:             consumerMetrics,
+		VersionHeader:       "schema-version",
+	})
+
+	calls := newHandledSet()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runConsumer(ctx, consumer, func(ctx context.Context, msg []byte) error {
+		calls.add(msg)
+		switch string(msg) {
+		case "1-1":
+			return failure.Permanent(errors.New("bad message"))
+		case "0-2":
+			if calls.len() < 8 {
+				return failure.Transient(errors.New("db is down"))
+			}
+		}
+		return nil
+	})
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(consumerMetrics.Consumed.WithLabelValues(testTopic, "1")) == 4
+	}, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+
+	require.Equal(t, float64(1), testutil.ToFloat64(consumerMetrics.Consumed.WithLabelValues(testTopic, "none")))
+	require.Equal(t, float64(1), testutil.ToFloat64(consumerMetrics.Consumed.WithLabelValues(testTopic, "invalid")))
+	require.Equal(t, float64(1), testutil.ToFloat64(consumerMetrics.Failed.WithLabelValues(testTopic, "1")))
+	require.Equal(t, float64(1), testutil.ToFloat64(consumerMetrics.DeadLetterWrites.WithLabelValues(testTopic, "ok")))
+	require.Equal(t, float64(calls.len()-6), testutil.ToFloat64(consumerMetrics.Retries.WithLabelValues(testTopic)))
+	// each handler call is observed, the retried ones as well
+	require.Equal(t, uint64(calls.len()), histogramCount(t, consumerMetrics.HandlerDuration.WithLabelValues(testTopic, "message")))
+}
+
+func TestKafkaConsumer_MetricsByDecodedVersion(t *testing.T) {
+	kafkaBroker := newFakeBroker(1, 1)
+	// the envelope carries the version, the header is not set
+	kafkaBroker.messages[0].Headers = nil
+	kafkaBroker.messages[0].Value = []byte(`{"v":1,"d":{"zombie_id":"84a526b3-4302-44ad-8fe6-4b8ce45d6980","updated_at":"2022-01-01T22:33:44.66Z"}}`)
+	consumerMetrics := metrics.NewConsumer(nil)
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), &fakeProducer{}, broker.ConsumerConfig{
+		Metrics:       consumerMetrics,
+		VersionHeader: "schema-version",
+	})
+
+	registry := shema_registry.NewRegistry([]int{1})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runConsumer(ctx, consumer, func(ctx context.Context, msg []byte) error {
+		data, err := registry.DecodeZombieCapturedStreamEvent(msg, 0)
+		if err != nil {
+			return failure.Permanent(err)
+		}
+		for v := range data {
+			broker.RecordSchemaVersion(ctx, v)
+		}
+		return nil
+	})
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(consumerMetrics.Consumed.WithLabelValues(testTopic, "1")) == 1
+	}, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+
+	require.Equal(t, float64(0), testutil.ToFloat64(consumerMetrics.Consumed.WithLabelValues(testTopic, "none")))
+}
+
+func TestKafkaConsumer_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	const producerTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	kafkaBroker := newFakeBroker(1, 2)
+	kafkaBroker.messages[0].Headers = append(kafkaBroker.messages[0].Headers,
+		kafka.Header{Key: "traceparent", Value: []byte("00-" + producerTrace + "-00f067aa0ba902b7-01")})
+	dlq := &fakeProducer{}
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), dlq, broker.ConsumerConfig{})
+
+	var (
+		mu     sync.Mutex
+		traces = make(map[string]trace.SpanContext)
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runConsumer(ctx, consumer, func(ctx context.Context, msg []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		traces[string(msg)] = trace.SpanContextFromContext(ctx)
+		if string(msg) == "0-0" {
+			return failure.Permanent(errors.New("bad message"))
+		}
+		return nil
+	})
+	require.Eventually(t, func() bool { return len(dlq.messages()) == 1 }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the message with the trace context continues the trace of the producer, the one without it starts the new one
+	require.Equal(t, producerTrace, traces["0-0"].TraceID().String())
+	require.True(t, traces["0-1"].IsValid())
+	require.NotEqual(t, producerTrace, traces["0-1"].TraceID().String())
+	// dead letter is written in the span of the failed processing
+	require.Equal(t, traces["0-0"], dlq.spanContexts()[0])
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	for _, span := range spans {
+		require.Equal(t, testTopic+" process", span.Name())
+		require.Equal(t, trace.SpanKindConsumer, span.SpanKind())
+		if span.SpanContext().TraceID().String() == producerTrace {
+			require.Equal(t, codes.Error, span.Status().Code)
+		} else {
+			require.Equal(t, codes.Unset, span.Status().Code)
+		}
+	}
+}
+
+func histogramCount(t *testing.T, o prometheus.Observer) uint64 {
+	m := &dto.Metric{}
+	require.NoError(t, o.(prometheus.Metric).Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestKafkaConsumer_CommitsByBatchSize(t *testing.T) {
+	kafkaBroker := newFakeBroker(1, 4)
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), &fakeProducer{}, broker.ConsumerConfig{
+		CommitInterval:  time.Hour,
+		CommitBatchSize: 2,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runConsumer(ctx, consumer, func(ctx context.Context, msg []byte) error { return nil })
+	require.Eventually(t, func() bool {
+		return kafkaBroker.committedOffsets()[0] == 4
+	}, time.Second, time.Millisecond)
+	require.Equal(t, 2, kafkaBroker.commitCalls())
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+	require.Equal(t, 2, kafkaBroker.commitCalls())
+}
+
+func TestKafkaConsumer_CommitsByInterval(t *testing.T) {
+	kafkaBroker := newFakeBroker(1, 1)
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), &fakeProducer{}, broker.ConsumerConfig{
+		CommitInterval:  10 * time.Millisecond,
+		CommitBatchSize: 100,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runConsumer(ctx, consumer, func(ctx context.Context, msg []byte) error { return nil })
+	require.Eventually(t, func() bool {
+		return kafkaBroker.committedOffsets()[0] == 1
+	}, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+}
+
+func TestKafkaConsumer_NoMessageLostAcrossRestart(t *testing.T) {
+	kafkaBroker := newFakeBroker(2, 10)
+	cfg := broker.ConsumerConfig{
+		CommitInterval:  time.Hour,
+		CommitBatchSize: 3,
+		DrainTimeout:    10 * time.Millisecond,
+	}
+
+	// first run is stopped in the middle of the message processing, which is not finished within the drain timeout
+	firstRun := newHandledSet()
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), &fakeProducer{}, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runConsumer(ctx, consumer, func(handleCtx context.Context, msg []byte) error {
+		if firstRun.len() == 7 {
+			cancel()
+			<-handleCtx.Done()
+			return handleCtx.Err()
+		}
+		firstRun.add(msg)
+		return nil
+	})
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+	require.Equal(t, 7, firstRun.len())
+
+	// second run fails to store a dead message, it must be delivered again
+	secondRun := newHandledSet()
+	consumer = newTestConsumer(t, kafkaBroker.newReader(), &fakeProducer{err: errors.New("kafka is down")}, cfg)
+	done = runConsumer(context.Background(), consumer, func(ctx context.Context, msg []byte) error {
+		if secondRun.len() == 5 {
+			return failure.Permanent(errors.New("bad message"))
+		}
+		secondRun.add(msg)
+		return nil
+	})
+	require.Error(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+	require.Equal(t, 5, secondRun.len())
+
+	// third run processes the rest
+	thirdRun := newHandledSet()
+	consumer = newTestConsumer(t, kafkaBroker.newReader(), &fakeProducer{}, cfg)
+	ctx, cancel = context.WithCancel(context.Background())
+	done = runConsumer(ctx, consumer, func(ctx context.Context, msg []byte) error {
+		thirdRun.add(msg)
+		return nil
+	})
+	require.Eventually(t, func() bool { return thirdRun.len() == 8 }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+
+	// every message is handled exactly once, as each run committed what was processed
+	all := make(map[string]struct{})
+	for _, set := range []*handledSet{firstRun, secondRun, thirdRun} {
+		for _, msg := range set.list() {
+			_, ok := all[msg]
+			require.False(t, ok, "message %s handled twice", msg)
+			all[msg] = struct{}{}
+		}
+	}
+	for _, m := range kafkaBroker.messages {
+		require.Contains(t, all, string(m.Value))
+	}
+	require.Equal(t, map[int]int64{0: 10, 1: 10}, kafkaBroker.committedOffsets())
+}
+
+func TestKafkaConsumer_RunAgainAfterFailure(t *testing.T) {
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+	kafkaBroker := newFakeBroker(1, 6)
+	dlq := &fakeProducer{err: errors.New("kafka is down")}
+	consumer := broker.NewKafkaConsumerWithReader(appLog, dlq, func() broker.MessageReader {
+		return kafkaBroker.newReader()
+	}, broker.ConsumerConfig{Topic: testTopic, GroupID: testGroup, CommitInterval: time.Hour})
+	handled := newHandledSet()
+	handler := func(ctx context.Context, msg []byte) error {
+		handled.add(msg)
+		if string(msg) == "0-2" {
+			return failure.Permanent(errors.New("bad message"))
+		}
+		return nil
+	}
+
+	// dead message can not be stored, the run fails with the processed messages committed
+	require.Error(t, consumer.Run(context.Background(), handler))
+	require.Equal(t, map[int]int64{0: 2}, kafkaBroker.committedOffsets())
+
+	// the next run starts from the committed offset, so the failed message is not lost
+	dlq.mu.Lock()
+	dlq.err = nil
+	dlq.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runConsumer(ctx, consumer, handler)
+	require.Eventually(t, func() bool { return handled.len() == 7 }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+
+	require.Equal(t, []string{"0-0", "0-1", "0-2", "0-2", "0-3", "0-4", "0-5"}, handled.list())
+	require.Equal(t, []string{"0-2"}, dlq.messages())
+	require.Equal(t, map[int]int64{0: 6}, kafkaBroker.committedOffsets())
+}
+
+func TestKafkaConsumer_RetriesTransientFailures(t *testing.T) {
+	cfg := broker.ConsumerConfig{
+		CommitInterval:      5 * time.Millisecond,
+		RetryMaxAttempts:    3,
+		RetryInitialBackoff: time.Millisecond,
+		RetryMaxBackoff:     2 * time.Millisecond,
+	}
+	table := []struct {
+		name          string
+		handlerErrors []error
+		expectedCalls int
+		expectedDead  []string
+	}{
+		{
+			name:          "recovered transient failure",
+			handlerErrors: []error{failure.Transient(errors.New("db is down")), errors.New("unknown failure"), nil},
+			expectedCalls: 3,
+		},
+		{
+			name:          "permanent failure",
+			handlerErrors: []error{failure.Permanent(errors.New("bad message"))},
+			expectedCalls: 1,
+			expectedDead:  []string{"0-0"},
+		},
+		{
+			name: "exhausted retry budget",
+			handlerErrors: []error{
+				failure.Transient(errors.New("db is down")),
+				failure.Transient(errors.New("db is down")),
+				failure.Transient(errors.New("db is down")),
+			},
+			expectedCalls: 3,
+			expectedDead:  []string{"0-0"},
+		},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			kafkaBroker := newFakeBroker(1, 1)
+			dlq := &fakeProducer{}
+			consumer := newTestConsumer(t, kafkaBroker.newReader(), dlq, cfg)
+
+			calls := newHandledSet()
+			ctx, cancel := context.WithCancel(context.Background())
+			done := runConsumer(ctx, consumer, func(ctx context.Context, msg []byte) error {
+				calls.add(msg)
+				return tc.handlerErrors[calls.len()-1]
+			})
+			require.Eventually(t, func() bool {
+				return kafkaBroker.committedOffsets()[0] == 1
+			}, time.Second, time.Millisecond)
+			cancel()
+			require.NoError(t, <-done)
+			require.NoError(t, consumer.Shutdown())
+
+			require.Equal(t, tc.expectedCalls, calls.len())
+			require.Equal(t, tc.expectedDead, dlq.messages())
+			for _, d := range dlq.deadMessages() {
+				require.Equal(t, broker.DeadMessageVersion, d.Version)
+				require.Equal(t, testTopic, d.Topic)
+				require.Equal(t, testGroup, d.ConsumerGroup)
+				require.Equal(t, tc.expectedCalls, d.Attempts)
+				require.Equal(t, []byte("zombie-0"), d.Key)
+				require.Equal(t, []broker.DeadMessageHeader{{Key: "schema-version", Value: []byte("1")}}, d.Headers)
+				require.Equal(t, tc.handlerErrors[tc.expectedCalls-1].Error(), d.Error)
+				require.False(t, d.FailedAt.IsZero())
+			}
+		})
+	}
+}
+
+func TestKafkaConsumer_PausedWhileStorageIsDown(t *testing.T) {
+	kafkaBroker := newFakeBroker(1, 2)
+	dlq := &fakeProducer{}
+	pauser := newFakePauser()
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), dlq, broker.ConsumerConfig{
+		CommitInterval:      5 * time.Millisecond,
+		RetryMaxAttempts:    1,
+		RetryInitialBackoff: time.Millisecond,
+		RetryMaxBackoff:     time.Millisecond,
+		Pauser:              pauser,
+	})
+
+	calls := newHandledSet()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runConsumer(ctx, consumer, func(ctx context.Context, msg []byte) error {
+		calls.add(msg)
+		if calls.len() == 1 {
+			// storage goes down while the message is handled
+			pauser.pause()
+			return failure.Transient(errors.New("db is down"))
+		}
+		return nil
+	})
+	require.Eventually(t, func() bool { return calls.len() == 1 }, time.Second, time.Millisecond)
+	// nothing is fetched, committed or dead-lettered while paused
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, 1, calls.len())
+	require.Empty(t, kafkaBroker.committedOffsets())
+
+	// storage is back, the same message is handled again
+	pauser.resume()
+	require.Eventually(t, func() bool {
+		return kafkaBroker.committedOffsets()[0] == 2
+	}, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+
+	require.Equal(t, []string{"0-0", "0-0", "0-1"}, calls.list())
+	require.Empty(t, dlq.messages())
+}
+
+func TestKafkaConsumer_CommitsContiguousOffsets(t *testing.T) {
+	kafkaBroker := newFakeBroker(1, 6)
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), &fakeProducer{}, broker.ConsumerConfig{
+		CommitInterval: 5 * time.Millisecond,
+		Workers:        4,
+	})
+
+	release := make(chan struct{})
+	handled := newHandledSet()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runConsumer(ctx, consumer, func(ctx context.Context, msg []byte) error {
+		if string(msg) == "0-1" {
+			// slow zombie does not stall the others
+			<-release
+		}
+		handled.add(msg)
+		return nil
+	})
+	// messages of the other zombies are handled while 0-1 is in progress
+	require.Eventually(t, func() bool { return handled.len() >= 2 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	require.NotContains(t, handled.list(), "0-1")
+	// offsets after the slow message are not committed until it is handled
+	require.Equal(t, map[int]int64{0: 1}, kafkaBroker.committedOffsets())
+
+	close(release)
+	require.Eventually(t, func() bool {
+		return kafkaBroker.committedOffsets()[0] == 6
+	}, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+}
+
+func TestKafkaConsumer_KeepsOrderPerKey(t *testing.T) {
+	kafkaBroker := newFakeBroker(2, 50)
+	for i := range kafkaBroker.messages {
+		kafkaBroker.messages[i].Key = []byte(fmt.Sprintf("zombie-%d", i%5))
+	}
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), &fakeProducer{}, broker.ConsumerConfig{
+		CommitInterval: 5 * time.Millisecond,
+		Workers:        3,
+	})
+
+	var (
+		mu    sync.Mutex
+		order = make(map[string][]int64)
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runConsumer(ctx, consumer, func(ctx context.Context, msg []byte) error {
+		m, ok := broker.MessageFromContext(ctx)
+		require.True(t, ok)
+		time.Sleep(time.Duration(m.Offset%3) * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		order[string(m.Key)] = append(order[string(m.Key)], int64(m.Partition)*1000+m.Offset)
+		return nil
+	})
+	require.Eventually(t, func() bool {
+		offsets := kafkaBroker.committedOffsets()
+		return offsets[0] == 50 && offsets[1] == 50
+	}, 5*time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, order, 5)
+	for key, handled := range order {
+		// messages of the same key are fetched partition by partition in the offset order
+		byPartition := make(map[int64]int64)
+		for _, o := range handled {
+			partition, offset := o/1000, o%1000
+			prev, ok := byPartition[partition]
+			require.True(t, !ok || prev < offset, "messages of %s handled out of order", key)
+			byPartition[partition] = offset
+		}
+	}
+}
+
+func TestKafkaConsumer_RunBatch(t *testing.T) {
+	kafkaBroker := newFakeBroker(2, 5)
+	dlq := &fakeProducer{}
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), dlq, broker.ConsumerConfig{
+		CommitInterval:      5 * time.Millisecond,
+		RetryInitialBackoff: time.Millisecond,
+		RetryMaxBackoff:     time.Millisecond,
+		BatchSize:           4,
+		BatchTimeout:        5 * time.Millisecond,
+	})
+
+	var (
+		mu      sync.Mutex
+		batches [][]string
+	)
+	handled := newHandledSet()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.RunBatch(ctx, func(ctx context.Context, msgs []kafka.Message) (map[int]error, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			var batch []string
+			for _, m := range msgs {
+				batch = append(batch, string(m.Value))
+			}
+			batches = append(batches, batch)
+			if len(batches) == 1 {
+				return nil, failure.Transient(errors.New("db is down"))
+			}
+			failed := make(map[int]error)
+			for i, m := range msgs {
+				if string(m.Value) == "1-2" {
+					failed[i] = failure.Permanent(errors.New("bad message"))
+					continue
+				}
+				handled.add(m.Value)
+			}
+			return failed, nil
+		})
+	}()
+	require.Eventually(t, func() bool {
+		offsets := kafkaBroker.committedOffsets()
+		return offsets[0] == 5 && offsets[1] == 5
+	}, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, consumer.Shutdown())
+
+	mu.Lock()
+	defer mu.Unlock()
+	// failed batch is retried as a whole
+	require.Equal(t, batches[0], batches[1])
+	for _, batch := range batches {
+		require.LessOrEqual(t, len(batch), 4)
+	}
+	require.Equal(t, 9, handled.len())
+	require.Equal(t, []string{"1-2"}, dlq.messages())
+}
+
+func TestKafkaConsumer_SIGTERMInTheMiddleOfBatch(t *testing.T) {
+	kafkaBroker := newFakeBroker(1, 9)
+	dlq := &fakeProducer{onShutdown: func() { kafkaBroker.event("close dead letter queue") }}
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), dlq, broker.ConsumerConfig{
+		CommitInterval: time.Hour,
+		BatchSize:      3,
+		BatchTimeout:   time.Hour,
+	})
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	batches := 0
+	err := consumer.RunBatch(ctx, func(handleCtx context.Context, msgs []kafka.Message) (map[int]error, error) {
+		batches++
+		if batches == 2 {
+			// the process is asked to stop while the batch is handled
+			require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+			<-ctx.Done()
+			require.NoError(t, handleCtx.Err(), "in-flight batch must not be interrupted")
+		}
+		kafkaBroker.event(fmt.Sprintf("handle batch %d", batches))
+		return nil, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, consumer.Shutdown())
+
+	// the batch in flight is finished and committed, the next one is not fetched
+	require.Equal(t, map[int]int64{0: 6}, kafkaBroker.committedOffsets())
+	require.Equal(t, []string{
+		"handle batch 1",
+		"handle batch 2",
+		"commit",
+		"leave group",
+		"close dead letter queue",
+	}, kafkaBroker.eventList())
+}
+
+func TestKafkaConsumer_ShutdownWithUnreachableBroker(t *testing.T) {
+	kafkaBroker := newFakeBroker(1, 3)
+	reader := kafkaBroker.newReader()
+	reader.hangCommits = true
+	consumer := newTestConsumer(t, reader, &fakeProducer{}, broker.ConsumerConfig{
+		CommitInterval: time.Hour,
+		DrainTimeout:   50 * time.Millisecond,
+	})
+	handled := newHandledSet()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runConsumer(ctx, consumer, func(ctx context.Context, msg []byte) error {
+		handled.add(msg)
+		return nil
+	})
+	require.Eventually(t, func() bool { return handled.len() == 3 }, time.Second, time.Millisecond)
+
+	stoppedAt := time.Now()
+	cancel()
+	// the final commits are bounded by the drain deadline
+	require.ErrorIs(t, <-done, context.DeadlineExceeded)
+	require.NoError(t, consumer.Shutdown())
+	require.Less(t, time.Since(stoppedAt), time.Second)
+	require.Empty(t, kafkaBroker.committedOffsets())
+}
+
+func TestKafkaConsumer_ShutdownDrainsInFlightMessages(t *testing.T) {
+	kafkaBroker := newFakeBroker(2, 3)
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), &fakeProducer{}, broker.ConsumerConfig{
+		CommitInterval: time.Hour,
+	})
+	started, release := make(chan struct{}), make(chan struct{})
+	handled := newHandledSet()
+	done := runConsumer(context.Background(), consumer, func(ctx context.Context, msg []byte) error {
+		if string(msg) == "0-1" {
+			close(started)
+			<-release
+		}
+		handled.add(msg)
+		return ctx.Err()
+	})
+	<-started
+
+	// shutdown stops the fetching by itself and waits for the message in flight
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- consumer.Shutdown()
+	}()
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned before the in-flight message was handled")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	require.NoError(t, <-shutdown)
+	require.NoError(t, <-done)
+
+	// the fetched message which did not reach the handler is consumed again after restart
+	require.Equal(t, []string{"0-0", "1-0", "0-1"}, handled.list())
+	require.Equal(t, map[int]int64{0: 2, 1: 1}, kafkaBroker.committedOffsets())
+}
+
+func TestKafkaConsumer_DrainTimeout(t *testing.T) {
+	kafkaBroker := newFakeBroker(1, 4)
+	consumer := newTestConsumer(t, kafkaBroker.newReader(), &fakeProducer{}, broker.ConsumerConfig{
+		CommitInterval: time.Hour,
+		BatchSize:      2,
+		BatchTimeout:   time.Hour,
+		DrainTimeout:   20 * time.Millisecond,
+	})
+	started := make(chan struct{})
+	batches := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.RunBatch(context.Background(), func(ctx context.Context, msgs []kafka.Message) (map[int]error, error) {
+			batches++
+			if batches == 2 {
+				// the handler is stuck until it is interrupted
+				close(started)
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return nil, nil
+		})
+	}()
+	<-started
+	require.NoError(t, consumer.Shutdown())
+	require.NoError(t, <-done)
+
+	// interrupted batch is not committed, it is consumed again after restart
+	require.Equal(t, map[int]int64{0: 2}, kafkaBroker.committedOffsets())
+}
+
+func newTestConsumer(t *testing.T, reader broker.MessageReader, dlq broker.Producer, cfg broker.ConsumerConfig) *broker.KafkaConsumer {
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+	cfg.Topic = testTopic
+	cfg.GroupID = testGroup
+	return broker.NewKafkaConsumerWithReader(appLog, dlq, func() broker.MessageReader { return reader }, cfg)
+}
+
+func runConsumer(ctx context.Context, consumer *broker.KafkaConsumer, handler broker.Handler) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.Run(ctx, handler)
+	}()
+	return done
+}
+
+// fakeBroker keeps messages and committed offsets of the consumer group between readers.
+type fakeBroker struct {
+	mu        sync.Mutex
+	messages  []kafka.Message
+	committed map[int]int64
+	commits   int
+	// events records commits and closed readers along with the events of the test.
+	events []string
+}
+
+func newFakeBroker(partitions, messagesPerPartition int) *fakeBroker {
+	b := &fakeBroker{committed: make(map[int]int64)}
+	for o := 0; o < messagesPerPartition; o++ {
+		for p := 0; p < partitions; p++ {
+			b.messages = append(b.messages, kafka.Message{
+				Topic:     testTopic,
+				Partition: p,
+				Offset:    int64(o),
+				Key:       []byte(fmt.Sprintf("zombie-%d", o)),
+				Headers:   []kafka.Header{{Key: "schema-version", Value: []byte("1")}},
+				Value:     []byte(fmt.Sprintf("%d-%d", p, o)),
+			})
+		}
+	}
+	return b
+}
+
+// newReader returns reader which starts from the committed offsets, as a restarted consumer does.
+func (b *fakeBroker) newReader() *fakeReader {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := &fakeReader{broker: b}
+	for _, m := range b.messages {
+		if m.Offset >= b.committed[m.Partition] {
+			r.queue = append(r.queue, m)
+		}
+	}
+	return r
+}
+
+func (b *fakeBroker) committedOffsets() map[int]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := make(map[int]int64, len(b.committed))
+	for p, o := range b.committed {
+		res[p] = o
+	}
+	return res
+}
+
+func (b *fakeBroker) event(event string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, event)
+}
+
+func (b *fakeBroker) eventList() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.events...)
+}
+
+func (b *fakeBroker) commitCalls() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.commits
+}
+
+type fakeReader struct {
+	broker *fakeBroker
+	mu     sync.Mutex
+	queue  []kafka.Message
+	// hangCommits makes commits wait for ctx, as they do while the broker is unreachable.
+	hangCommits bool
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	if len(r.queue) == 0 {
+		r.mu.Unlock()
+		<-ctx.Done()
+		return kafka.Message{}, ctx.Err()
+	}
+	m := r.queue[0]
+	r.queue = r.queue[1:]
+	r.mu.Unlock()
+	return m, nil
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if r.hangCommits {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	r.broker.mu.Lock()
+	defer r.broker.mu.Unlock()
+	r.broker.commits++
+	r.broker.events = append(r.broker.events, "commit")
+	for _, m := range msgs {
+		if m.Offset+1 > r.broker.committed[m.Partition] {
+			r.broker.committed[m.Partition] = m.Offset + 1
+		}
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	r.broker.event("leave group")
+	return nil
+}
+
+type fakeProducer struct {
+	mu   sync.Mutex
+	err  error
+	dead []broker.DeadMessage
+	// spans keeps the span context each dead message is written in.
+	spans      []trace.SpanContext
+	onShutdown func()
+}
+
+func (f *fakeProducer) WriteDeadMessages(ctx context.Context, d broker.DeadMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.dead = append(f.dead, d)
+	f.spans = append(f.spans, trace.SpanContextFromContext(ctx))
+	return nil
+}
+
+func (f *fakeProducer) spanContexts() []trace.SpanContext {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]trace.SpanContext(nil), f.spans...)
+}
+
+func (f *fakeProducer) Shutdown() error {
+	if f.onShutdown != nil {
+		f.onShutdown()
+	}
+	return nil
+}
+
+func (f *fakeProducer) messages() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var res []string
+	for _, d := range f.dead {
+		res = append(res, string(d.Msg))
+	}
+	return res
+}
+
+func (f *fakeProducer) deadMessages() []broker.DeadMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]broker.DeadMessage(nil), f.dead...)
+}
+
+type handledSet struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func newHandledSet() *handledSet {
+	return &handledSet{}
+}
+
+func (h *handledSet) add(msg []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.msgs = append(h.msgs, string(msg))
+}
+
+func (h *handledSet) len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.msgs)
+}
+
+func (h *handledSet) list() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.msgs...)
+}
+
+type fakePauser struct {
+	mu      sync.Mutex
+	resumed chan struct{}
+}
+
+func newFakePauser() *fakePauser {
+	resumed := make(chan struct{})
+	close(resumed)
+	return &fakePauser{resumed: resumed}
+}
+
+func (f *fakePauser) pause() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resumed = make(chan struct{})
+}
+
+func (f *fakePauser) resume() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.resumed)
+}
+
+func (f *fakePauser) Paused() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-f.resumed:
+		return false
+	default:
+		return true
+	}
+}
+
+func (f *fakePauser) WaitResume(ctx context.Context) error {
+	f.mu.Lock()
+	resumed := f.resumed
+	f.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resumed:
+		return nil
+	}
+}
@@ -2,7 +2,6 @@ package broker
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 	"zombie_locator/internal/logger"
@@ -12,42 +11,41 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
-type deadMessage struct {
-	Topic string `json:"topic"`
-	Error string `json:"error"`
-	Msg   []byte `json:"msg"`
-}
-
 // KafkaProducer defines a Kafka messages producer.
 type KafkaProducer struct {
 	log    logger.AppLogger
 	writer *kafka.Writer
 }
 
-func NewKafkaProducer(log logger.AppLogger, brokerAddr string, topic string) *KafkaProducer {
+// ProducerConfig is the kafka producer configuration.
+type ProducerConfig struct {
+	Brokers []string
+	Topic   string
+}
+
+func NewKafkaProducer(log logger.AppLogger, cfg ProducerConfig) *KafkaProducer {
 	return &KafkaProducer{
 		log: log.With(zap.String("component", "kafka_producer")).
-			With(zap.String("topic", topic)),
+			With(zap.String("topic", cfg.Topic)),
 		writer: &kafka.Writer{
-			Addr:     kafka.TCP(brokerAddr),
-			Topic:    topic,
-			Balancer: &kafka.LeastBytes{},
+			Addr:  kafka.TCP(cfg.Brokers...),
+			Topic: cfg.Topic,
+			// messages with the same key go to the same partition, so the order per zombie is kept
+			Balancer: &kafka.Hash{},
 		},
 	}
 }
 
-func (k *KafkaProducer) WriteDeadMessages(ctx context.Context, srcTopic string, err error, msg []byte) error {
-	d := deadMessage{
-		Error: err.Error(),
-		Topic: srcTopic,
-		Msg:   msg,
-	}
-	data, err := json.Marshal(&d)
+// WriteDeadMessages puts the failed message to the dead letter queue topic. The record headers carry
+// the trace context of ctx, the original headers are kept in the record.
+func (k *KafkaProducer) WriteDeadMessages(ctx context.Context, d DeadMessage) error {
+	msg, err := d.kafkaMessage()
 	if err != nil {
-		return fmt.Errorf("failed to marshal dead message: %w", err)
+		return err
 	}
+	injectTraceContext(ctx, &msg)
 	for i := 0; i < 3; i++ {
-		if err = k.writer.WriteMessages(ctx, kafka.Message{Value: data}); err == nil {
+		if err = k.writer.WriteMessages(ctx, msg); err == nil {
 			return nil
 		}
 		time.Sleep(time.Duration(i) * time.Second)
@@ -56,6 +54,16 @@ func (k *KafkaProducer) WriteDeadMessages(ctx context.Context, srcTopic string,
 	return err
 }
 
+// Publish writes the message to the producer topic keeping its key and headers.
+func (k *KafkaProducer) Publish(ctx context.Context, m kafka.Message) error {
+	// topic, partition and offset belong to the source message, writer chooses own
+	m.Topic, m.Partition, m.Offset = "", 0, 0
+	if err := k.writer.WriteMessages(ctx, m); err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+	return nil
+}
+
 func (k *KafkaProducer) Shutdown() error {
 	// simply close connection, as dlq writes from consumer
 	return k.writer.Close()
@@ -0,0 +1,68 @@
+package broker
+
+import "github.com/segmentio/kafka-go"
+
+// offsetTracker keeps the fetched messages of each partition until they are processed. Messages are processed
+// out of order, so only the highest offset with all the previous messages processed is ready to commit.
+// It is not safe for concurrent use.
+type offsetTracker struct {
+	partitions map[int]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	// inFlight keeps fetched and not yet committable messages in the fetch order.
+	inFlight  []kafka.Message
+	processed map[int64]struct{}
+	// ready is the last message which offset can be committed, nil if there is nothing new to commit.
+	ready *kafka.Message
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[int]*partitionOffsets)}
+}
+
+// track registers the fetched message, messages of a partition are tracked in the fetch order.
+func (t *offsetTracker) track(m kafka.Message) {
+	po, ok := t.partitions[m.Partition]
+	if !ok {
+		po = &partitionOffsets{processed: make(map[int64]struct{})}
+		t.partitions[m.Partition] = po
+	}
+	po.inFlight = append(po.inFlight, m)
+}
+
+// done marks the message processed and moves the committable offset of its partition as far as it can.
+func (t *offsetTracker) done(m kafka.Message) {
+	po, ok := t.partitions[m.Partition]
+	if !ok {
+		return
+	}
+	po.processed[m.Offset] = struct{}{}
+	for len(po.inFlight) > 0 {
+		first := po.inFlight[0]
+		if _, ok = po.processed[first.Offset]; !ok {
+			break
+		}
+		delete(po.processed, first.Offset)
+		po.inFlight = po.inFlight[1:]
+		po.ready = &first
+	}
+}
+
+// ready returns the messages to commit, one per partition.
+func (t *offsetTracker) ready() []kafka.Message {
+	var msgs []kafka.Message
+	for _, po := range t.partitions {
+		if po.ready != nil {
+			msgs = append(msgs, *po.ready)
+		}
+	}
+	return msgs
+}
+
+// committed forgets the committed messages.
+func (t *offsetTracker) committed() {
+	for _, po := range t.partitions {
+		po.ready = nil
+	}
+}
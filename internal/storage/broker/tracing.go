@@ -0,0 +1,102 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	kafkaPartitionKey = attribute.Key("messaging.kafka.partition")
+	kafkaOffsetKey    = attribute.Key("messaging.kafka.message_offset")
+	kafkaGroupKey     = attribute.Key("messaging.kafka.consumer_group")
+	kafkaBatchSizeKey = attribute.Key("messaging.batch.message_count")
+)
+
+var tracer = otel.Tracer("zombie_locator/internal/storage/broker")
+
+// headerCarrier passes the trace context in the kafka message headers.
+type headerCarrier struct {
+	headers *[]kafka.Header
+}
+
+// Get returns the last value of the header.
+func (c headerCarrier) Get(key string) string {
+	for i := len(*c.headers) - 1; i >= 0; i-- {
+		if (*c.headers)[i].Key == key {
+			return string((*c.headers)[i].Value)
+		}
+	}
+	return ""
+}
+
+// Set replaces all the values of the header, so the message does not carry the stale trace context.
+func (c headerCarrier) Set(key, value string) {
+	headers := make([]kafka.Header, 0, len(*c.headers)+1)
+	for _, h := range *c.headers {
+		if h.Key != key {
+			headers = append(headers, h)
+		}
+	}
+	*c.headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.headers))
+	for _, h := range *c.headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// messageContext returns ctx with the trace context the producer put in the message headers.
+func messageContext(ctx context.Context, m kafka.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier{headers: &m.Headers})
+}
+
+// injectTraceContext puts the trace context of ctx in the message headers.
+func injectTraceContext(ctx context.Context, m *kafka.Message) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{headers: &m.Headers})
+}
+
+// startProcessSpan starts the span of the message processing, it continues the trace of the producer.
+func (p *KafkaConsumer) startProcessSpan(ctx context.Context, m kafka.Message) (context.Context, trace.Span) {
+	return tracer.Start(messageContext(ctx, m), p.topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(append(p.spanAttributes(),
+			kafkaPartitionKey.Int(m.Partition),
+			kafkaOffsetKey.Int64(m.Offset))...))
+}
+
+// startBatchSpan starts the span of the batch processing. Messages of the batch come from different traces,
+// so the span starts the new trace linked to each of them.
+func (p *KafkaConsumer) startBatchSpan(ctx context.Context, batch []kafka.Message) (context.Context, trace.Span) {
+	links := make([]trace.Link, 0, len(batch))
+	for _, m := range batch {
+		if sc := trace.SpanContextFromContext(messageContext(ctx, m)); sc.IsValid() {
+			links = append(links, trace.Link{
+				SpanContext: sc,
+				Attributes:  []attribute.KeyValue{kafkaPartitionKey.Int(m.Partition), kafkaOffsetKey.Int64(m.Offset)},
+			})
+		}
+	}
+	return tracer.Start(ctx, p.topic+" process",
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(append(p.spanAttributes(), kafkaBatchSizeKey.Int(len(batch)))...))
+}
+
+func (p *KafkaConsumer) spanAttributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("kafka"),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingDestinationKey.String(p.topic),
+		semconv.MessagingOperationProcess,
+		kafkaGroupKey.String(p.groupID),
+	}
+}
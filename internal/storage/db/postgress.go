@@ -9,13 +9,19 @@ import (
 	_ "github.com/lib/pq"
 )
 
+// PostgresConfig is the postgres connection configuration.
+type PostgresConfig struct {
+	// URL is the connection string, it holds the password.
+	URL string `yaml:"url"`
+}
+
 type Connect struct {
 	db *sqlx.DB
 }
 
 // NewPostgresConnection creates a new DB instance based on the given configuration.
-func NewPostgresConnection(dbDSN string) (*Connect, error) {
-	db, err := sqlx.Connect("postgres", dbDSN)
+func NewPostgresConnection(cfg PostgresConfig) (*Connect, error) {
+	db, err := sqlx.Connect("postgres", cfg.URL)
 	if err != nil {
 		return nil, fmt.Errorf("could not create a connection: %w", err)
 	}
@@ -6,9 +6,15 @@ import (
 	"github.com/xjem/t38c"
 )
 
+// Tile38Config is the tile38 connection configuration.
+type Tile38Config struct {
+	// Addr is the host:port of the tile38 server.
+	Addr string `yaml:"addr"`
+}
+
 // NewTile38Connection creates a new DB instance based on the given configuration.
-func NewTile38Connection(connStr string) (*t38c.Client, error) {
-	client, err := t38c.New(connStr, t38c.Debug)
+func NewTile38Connection(cfg Tile38Config) (*t38c.Client, error) {
+	client, err := t38c.New(cfg.Addr, t38c.Debug)
 	if err != nil {
 		return nil, fmt.Errorf("could not create a tile38 connection: %w", err)
 	}
@@ -0,0 +1,114 @@
+// Package migrations evolves the postgres schema with the versioned SQL files embedded in the binary.
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+//go:embed sql/*.sql sql/postgis/*.sql
+var files embed.FS
+
+const (
+	upDirection   = "up"
+	downDirection = "down"
+
+	// PostgisTable keeps the applied migrations of the postgis storage backend.
+	PostgisTable = "schema_migrations_postgis"
+)
+
+// fileName is the migration file name: version, name and direction, e.g. 0001_create_zombies.up.sql.
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var (
+	InvalidMigration = errors.New("invalid migration")
+	UnknownMigration = errors.New("migration is unknown to this version")
+)
+
+// Migration is the schema change and the way back.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Embedded returns the migrations shipped with the binary ordered by version.
+func Embedded() ([]Migration, error) {
+	return embedded("sql")
+}
+
+// EmbeddedPostgis returns the migrations of the postgis storage backend ordered by version. They need
+// the postgis extension, so they are applied on top of the Embedded ones only along with that backend
+// and recorded in PostgisTable.
+func EmbeddedPostgis() ([]Migration, error) {
+	return embedded("sql/postgis")
+}
+
+func embedded(dir string) ([]Migration, error) {
+	sub, err := fs.Sub(files, dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open embedded migrations: %w", err)
+	}
+	return Load(sub)
+}
+
+// Load reads the migrations from the root of fsys ordered by version.
+// Every version must have both up and down files with the same name.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read migrations: %w", err)
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		match := fileName.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%w: unexpected file name %s", InvalidMigration, e.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%w: unexpected version of %s", InvalidMigration, e.Name())
+		}
+		content, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("unable to read migration %s: %w", e.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("%w: version %d is used by %s and %s", InvalidMigration, version, m.Name, match[2])
+		}
+		switch match[3] {
+		case upDirection:
+			m.Up = string(content)
+		case downDirection:
+			m.Down = string(content)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("%w: version %d must have both up and down files", InvalidMigration, m.Version)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+	return result, nil
+}
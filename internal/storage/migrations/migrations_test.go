@@ -0,0 +1,66 @@
+package migrations_test
+
+import (
+	"testing"
+	"testing/fstest"
+	"zombie_locator/internal/storage/migrations"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	loaded, err := migrations.Load(fstest.MapFS{
+		"0010_second.up.sql":   {Data: []byte("create table b")},
+		"0010_second.down.sql": {Data: []byte("drop table b")},
+		"0002_first.up.sql":    {Data: []byte("create table a")},
+		"0002_first.down.sql":  {Data: []byte("drop table a")},
+		"README.md":            {Data: []byte("not a migration")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []migrations.Migration{
+		{Version: 2, Name: "first", Up: "create table a", Down: "drop table a"},
+		{Version: 10, Name: "second", Up: "create table b", Down: "drop table b"},
+	}, loaded)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"no down file": {
+			"0001_first.up.sql": {Data: []byte("create table a")},
+		},
+		"same version": {
+			"0001_first.up.sql":    {Data: []byte("create table a")},
+			"0001_first.down.sql":  {Data: []byte("drop table a")},
+			"0001_second.up.sql":   {Data: []byte("create table b")},
+			"0001_second.down.sql": {Data: []byte("drop table b")},
+		},
+		"no version": {
+			"first.up.sql": {Data: []byte("create table a")},
+		},
+		"zero version": {
+			"0000_first.up.sql":   {Data: []byte("create table a")},
+			"0000_first.down.sql": {Data: []byte("drop table a")},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := migrations.Load(fsys)
+			require.ErrorIs(t, err, migrations.InvalidMigration)
+		})
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	for name, load := range map[string]func() ([]migrations.Migration, error){
+		"common":  migrations.Embedded,
+		"postgis": migrations.EmbeddedPostgis,
+	} {
+		t.Run(name, func(t *testing.T) {
+			embedded, err := load()
+			require.NoError(t, err)
+			require.NotEmpty(t, embedded)
+			for i, m := range embedded {
+				require.Equal(t, int64(i+1), m.Version, "versions are sequential")
+			}
+		})
+	}
+}
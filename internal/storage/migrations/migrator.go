@@ -0,0 +1,223 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/storage/db"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// defaultTable keeps the applied migrations.
+const defaultTable = "schema_migrations"
+
+type Config struct {
+	// Table keeps the applied migrations, schema_migrations by default.
+	Table string
+	// Migrations to apply, the embedded ones by default.
+	Migrations []Migration
+}
+
+// Migrator applies the migrations. Each migration is applied in its own transaction together with
+// its record in the migrations table, so a failed migration leaves no trace. The transaction holds
+// the advisory lock, so instances started at once apply every migration exactly once.
+type Migrator struct {
+	log        logger.AppLogger
+	dbConnect  db.Connector
+	table      string
+	migrations []Migration
+}
+
+// Status is the state of the migration.
+type Status struct {
+	Version int64
+	Name    string
+	// AppliedAt is nil for the pending migration.
+	AppliedAt *time.Time
+	// Unknown is set for the migration applied by the newer version of the service.
+	Unknown bool
+}
+
+func NewMigrator(log logger.AppLogger, dbConnect db.Connector, cfg Config) (*Migrator, error) {
+	if cfg.Table == "" {
+		cfg.Table = defaultTable
+	}
+	if cfg.Migrations == nil {
+		var err error
+		if cfg.Migrations, err = Embedded(); err != nil {
+			return nil, err
+		}
+	}
+	return &Migrator{
+		log:        log.With(zap.String("service", "migrations")),
+		dbConnect:  dbConnect,
+		table:      cfg.Table,
+		migrations: cfg.Migrations,
+	}, nil
+}
+
+// Table returns the table which keeps the applied migrations.
+func (m *Migrator) Table() string {
+	return m.table
+}
+
+// Up applies the pending migrations in the version order and returns the number of applied ones.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	for {
+		done := true
+		err := m.withLock(ctx, func(tx *sqlx.Tx, versions map[int64]time.Time) error {
+			migration, ok := m.nextPending(versions)
+			if !ok {
+				return nil
+			}
+			done = false
+			m.log.Info("applying migration", zap.Int64("version", migration.Version), zap.String("name", migration.Name))
+			if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+				return fmt.Errorf("unable to apply migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			if _, err := tx.ExecContext(ctx,
+				fmt.Sprintf(`INSERT INTO %s(version, name) VALUES($1, $2)`, m.table),
+				migration.Version, migration.Name); err != nil {
+				return fmt.Errorf("unable to record migration %d: %w", migration.Version, err)
+			}
+			return nil
+		})
+		if err != nil || done {
+			return applied, err
+		}
+		applied++
+	}
+}
+
+// nextPending returns the pending migration of the lowest version.
+func (m *Migrator) nextPending(versions map[int64]time.Time) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if _, ok := versions[migration.Version]; !ok {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+// Down reverts up to steps latest applied migrations and returns the number of reverted ones.
+// Migration applied by the newer version of the service can not be reverted, as its down file is unknown.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	byVersion := make(map[int64]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		byVersion[migration.Version] = migration
+	}
+	reverted := 0
+	for ; reverted < steps; reverted++ {
+		done := false
+		err := m.withLock(ctx, func(tx *sqlx.Tx, versions map[int64]time.Time) error {
+			if len(versions) == 0 {
+				done = true
+				return nil
+			}
+			var latest int64
+			for version := range versions {
+				if version > latest {
+					latest = version
+				}
+			}
+			migration, ok := byVersion[latest]
+			if !ok {
+				return fmt.Errorf("%w: version %d", UnknownMigration, latest)
+			}
+			m.log.Info("reverting migration", zap.Int64("version", migration.Version), zap.String("name", migration.Name))
+			if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+				return fmt.Errorf("unable to revert migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			if _, err := tx.ExecContext(ctx,
+				fmt.Sprintf(`DELETE FROM %s WHERE version = $1`, m.table), migration.Version); err != nil {
+				return fmt.Errorf("unable to delete migration %d: %w", migration.Version, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return reverted, err
+		}
+		if done {
+			break
+		}
+	}
+	return reverted, nil
+}
+
+// Status returns the state of the known migrations and of the applied unknown ones, ordered by version.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var result []Status
+	err := m.withLock(ctx, func(tx *sqlx.Tx, versions map[int64]time.Time) error {
+		known := make(map[int64]struct{}, len(m.migrations))
+		for _, migration := range m.migrations {
+			known[migration.Version] = struct{}{}
+			s := Status{Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := versions[migration.Version]; ok {
+				s.AppliedAt = &appliedAt
+			}
+			result = append(result, s)
+		}
+		for version, appliedAt := range versions {
+			if _, ok := known[version]; !ok {
+				appliedAt := appliedAt
+				result = append(result, Status{Version: version, AppliedAt: &appliedAt, Unknown: true})
+			}
+		}
+		return nil
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+	return result, err
+}
+
+// withLock runs fn inside the transaction holding the migrations lock, fn gets the applied versions.
+func (m *Migrator) withLock(ctx context.Context, fn func(tx *sqlx.Tx, versions map[int64]time.Time) error) error {
+	tx, err := m.dbConnect.Client().BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	if err = m.lockedFn(ctx, tx, fn); err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			return fmt.Errorf("unable to rollback transaction: %v: %w", rErr, err)
+		}
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("unable to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (m *Migrator) lockedFn(ctx context.Context, tx *sqlx.Tx, fn func(tx *sqlx.Tx, versions map[int64]time.Time) error) error {
+	// the lock is released with the transaction, so it is never left behind
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, m.table); err != nil {
+		return fmt.Errorf("unable to lock migrations: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s
+		(
+			version    bigint                  not null primary key,
+			name       varchar                 not null,
+			applied_at timestamp default now() not null
+		)`, m.table)); err != nil {
+		return fmt.Errorf("unable to create migrations table: %w", err)
+	}
+	var rows []struct {
+		Version   int64     `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	if err := tx.SelectContext(ctx, &rows, fmt.Sprintf(`SELECT version, applied_at FROM %s`, m.table)); err != nil {
+		return fmt.Errorf("unable to get applied migrations: %w", err)
+	}
+	versions := make(map[int64]time.Time, len(rows))
+	for _, r := range rows {
+		versions[r.Version] = r.AppliedAt
+	}
+	return fn(tx, versions)
+}
@@ -1,4 +1,5 @@
-create table zombies
+-- tables may exist already in the databases created before the migrations, they are adopted as they are.
+create table if not exists zombies
 (
     id         uuid
         constraint zombies_pk
@@ -6,4 +7,4 @@ create table zombies
     updated_at timestamp,
     point      point,
     status     varchar
-);
\ No newline at end of file
+);
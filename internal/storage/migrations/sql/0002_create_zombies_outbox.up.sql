@@ -0,0 +1,12 @@
+-- zombies_outbox keeps zombies updated in postgres and not yet applied to tile38.
+create table if not exists zombies_outbox
+(
+    id         bigserial
+        constraint zombies_outbox_pk
+            primary key,
+    zombie_id  uuid                    not null,
+    created_at timestamp default now() not null
+);
+
+create index if not exists zombies_outbox_zombie_id_idx
+    on zombies_outbox (zombie_id);
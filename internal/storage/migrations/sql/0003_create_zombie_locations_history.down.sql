@@ -0,0 +1 @@
+drop table if exists zombie_locations_history;
@@ -0,0 +1,10 @@
+-- zombie_locations_history keeps every received zombie location, zombies keeps the latest one only.
+create table if not exists zombie_locations_history
+(
+    zombie_id   uuid             not null,
+    recorded_at timestamp        not null,
+    lat         double precision not null,
+    lon         double precision not null,
+    constraint zombie_locations_history_pk
+        primary key (zombie_id, recorded_at)
+);
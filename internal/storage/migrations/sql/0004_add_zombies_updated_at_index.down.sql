@@ -0,0 +1 @@
+drop index if exists zombies_updated_at_idx;
@@ -0,0 +1,3 @@
+-- zombies_updated_at_idx serves the newest update the readiness check judges the data freshness by.
+create index if not exists zombies_updated_at_idx
+    on zombies (updated_at);
@@ -0,0 +1,4 @@
+drop index if exists zombies_location_idx;
+
+alter table zombies
+    drop column if exists location;
@@ -0,0 +1,14 @@
+create extension if not exists postgis;
+
+-- location is used by the postgis storage backend instead of tile38, the migrations of this directory
+-- are applied only along with that backend, so the other deployments do not need the extension
+alter table zombies
+    add column if not exists location geography(Point, 4326);
+
+create index if not exists zombies_location_idx
+    on zombies using gist (location);
+
+-- zombies located before the backend is enabled keep only the point
+update zombies
+set location = ST_SetSRID(ST_MakePoint(point[1], point[0]), 4326)::geography
+where point is not null;
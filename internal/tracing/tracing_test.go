@@ -0,0 +1,49 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"zombie_locator/internal/tracing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetup(t *testing.T) {
+	for _, exporter := range []string{tracing.NoneExporter, tracing.StdoutExporter, tracing.OTLPExporter} {
+		t.Run(exporter, func(t *testing.T) {
+			shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+				Exporter:    exporter,
+				Endpoint:    "localhost:4317",
+				Insecure:    true,
+				SampleRatio: 1,
+			}, "zombie-tracker")
+			require.NoError(t, err)
+			// nothing is buffered, so the unreachable collector is not contacted
+			require.NoError(t, shutdown(context.Background()))
+		})
+	}
+
+	_, err := tracing.Setup(context.Background(), tracing.Config{Exporter: "jaeger"}, "zombie-tracker")
+	require.Error(t, err)
+}
+
+func TestEnd(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	_, span := tracer.Start(context.Background(), "ok")
+	tracing.End(span, nil)
+	_, span = tracer.Start(context.Background(), "failed")
+	tracing.End(span, errors.New("connection refused"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+	require.Equal(t, codes.Error, spans[1].Status().Code)
+	require.Equal(t, "connection refused", spans[1].Status().Description)
+	require.Len(t, spans[1].Events(), 1)
+}
@@ -0,0 +1,189 @@
+package circuit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/utils/failure"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultProbeInterval    = 2 * time.Second
+)
+
+// OpenState is returned by Execute while the breaker is open.
+var OpenState = errors.New("circuit breaker is open")
+
+type State string
+
+const (
+	Closed State = "closed"
+	Open   State = "open"
+)
+
+// Probe checks the health of the protected dependency.
+type Probe func(ctx context.Context) error
+
+type Config struct {
+	// FailureThreshold is the number of consecutive transient failures which opens the breaker.
+	FailureThreshold int
+	// ProbeInterval is the delay between health probes while the breaker is open.
+	ProbeInterval time.Duration
+}
+
+// Status is a snapshot of the breaker state.
+type Status struct {
+	Name      string     `json:"name"`
+	State     State      `json:"state"`
+	Failures  int        `json:"failures"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// Breaker stops calls to the dependency after consecutive transient failures.
+// While it is open, the background probe checks the dependency and closes the breaker once it is healthy again.
+// Permanent failures are caused by the data, not by the dependency, so they do not open the breaker.
+type Breaker struct {
+	log              logger.AppLogger
+	name             string
+	probe            Probe
+	failureThreshold int
+	probeInterval    time.Duration
+
+	mu        sync.RWMutex
+	state     State
+	failures  int
+	openedAt  time.Time
+	lastError error
+	// resumed is closed when the breaker gets closed, waiters are blocked on it while the breaker is open.
+	resumed chan struct{}
+}
+
+func NewBreaker(log logger.AppLogger, name string, cfg Config, probe Probe) *Breaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultFailureThreshold
+	}
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = defaultProbeInterval
+	}
+	resumed := make(chan struct{})
+	close(resumed)
+	return &Breaker{
+		log: log.With(zap.String("service", "circuit_breaker")).
+			With(zap.String("name", name)),
+		name:             name,
+		probe:            probe,
+		failureThreshold: cfg.FailureThreshold,
+		probeInterval:    cfg.ProbeInterval,
+		state:            Closed,
+		resumed:          resumed,
+	}
+}
+
+// Run probes the dependency while the breaker is open, until ctx is done.
+func (b *Breaker) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !b.Paused() {
+				continue
+			}
+			if err := b.probe(ctx); err != nil {
+				b.log.Error("health probe failed, breaker stays open", err)
+				continue
+			}
+			b.close()
+		}
+	}
+}
+
+// Execute calls fn unless the breaker is open and records its result.
+func (b *Breaker) Execute(fn func() error) error {
+	if b.Paused() {
+		return failure.Transient(fmt.Errorf("%s: %w", b.name, OpenState))
+	}
+	err := fn()
+	switch {
+	case err == nil:
+		b.mu.Lock()
+		b.failures = 0
+		b.mu.Unlock()
+	case failure.IsTransient(err):
+		b.recordFailure(err)
+	}
+	return err
+}
+
+// Paused reports whether the breaker is open and the dependency calls are stopped.
+func (b *Breaker) Paused() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.state == Open
+}
+
+// WaitResume blocks while the breaker is open.
+func (b *Breaker) WaitResume(ctx context.Context) error {
+	b.mu.RLock()
+	resumed := b.resumed
+	b.mu.RUnlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resumed:
+		return nil
+	}
+}
+
+func (b *Breaker) Status() Status {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	status := Status{
+		Name:     b.name,
+		State:    b.state,
+		Failures: b.failures,
+	}
+	if b.state == Open {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	if b.lastError != nil {
+		status.LastError = b.lastError.Error()
+	}
+	return status
+}
+
+func (b *Breaker) recordFailure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err
+	if b.state == Open || b.failures < b.failureThreshold {
+		return
+	}
+	b.state = Open
+	b.openedAt = time.Now()
+	b.resumed = make(chan struct{})
+	b.log.Error("breaker is open, dependency is unavailable", err, zap.Int("failures", b.failures))
+}
+
+func (b *Breaker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Closed {
+		return
+	}
+	b.log.Info("breaker is closed, dependency is available", zap.Duration("downtime", time.Since(b.openedAt)))
+	b.state = Closed
+	b.failures = 0
+	close(b.resumed)
+}
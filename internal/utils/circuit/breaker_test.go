@@ -0,0 +1,70 @@
+package circuit_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/utils/circuit"
+	"zombie_locator/internal/utils/failure"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker_OpensOnTransientFailuresAndClosesAfterProbe(t *testing.T) {
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+
+	var healthy atomic.Bool
+	breaker := circuit.NewBreaker(appLog, "storage", circuit.Config{
+		FailureThreshold: 2,
+		ProbeInterval:    time.Millisecond,
+	}, func(ctx context.Context) error {
+		if healthy.Load() {
+			return nil
+		}
+		return errors.New("db is down")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go breaker.Run(ctx)
+
+	// permanent failures are caused by data, they do not open the breaker
+	for i := 0; i < 3; i++ {
+		err = breaker.Execute(func() error { return failure.Permanent(errors.New("bad data")) })
+		require.True(t, failure.IsPermanent(err))
+	}
+	require.Equal(t, circuit.Closed, breaker.Status().State)
+
+	for i := 0; i < 2; i++ {
+		err = breaker.Execute(func() error { return failure.Transient(errors.New("db is down")) })
+		require.True(t, failure.IsTransient(err))
+	}
+	require.True(t, breaker.Paused())
+	status := breaker.Status()
+	require.Equal(t, circuit.Open, status.State)
+	require.NotNil(t, status.OpenedAt)
+	require.Equal(t, "db is down", status.LastError)
+
+	// calls are not executed while breaker is open
+	called := false
+	err = breaker.Execute(func() error {
+		called = true
+		return nil
+	})
+	require.ErrorIs(t, err, circuit.OpenState)
+	require.True(t, failure.IsTransient(err))
+	require.False(t, called)
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer waitCancel()
+	require.ErrorIs(t, breaker.WaitResume(waitCtx), context.DeadlineExceeded)
+
+	// probe succeeds, breaker is closed
+	healthy.Store(true)
+	require.NoError(t, breaker.WaitResume(context.Background()))
+	require.False(t, breaker.Paused())
+	require.Equal(t, circuit.Closed, breaker.Status().State)
+}
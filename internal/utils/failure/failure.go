@@ -0,0 +1,58 @@
+package failure
+
+import "errors"
+
+// permanentError is a failure which repeats on every attempt: broken payload, validation, unsupported version.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// transientError is a failure of the infrastructure, which can disappear on the next attempt: network, db outage.
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e transientError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks error as permanent, there is no reason to retry it.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// Transient marks error as transient, operation can be retried.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return transientError{err: err}
+}
+
+// IsPermanent reports whether any error in err's chain is marked as permanent.
+func IsPermanent(err error) bool {
+	var target permanentError
+	return errors.As(err, &target)
+}
+
+// IsTransient reports whether any error in err's chain is marked as transient.
+// Errors which are not marked at all are not transient.
+func IsTransient(err error) bool {
+	var target transientError
+	return errors.As(err, &target)
+}
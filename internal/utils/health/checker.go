@@ -0,0 +1,129 @@
+// Package health runs the checks the readiness of the service is judged by.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultTimeout = 2 * time.Second
+
+type Status string
+
+const (
+	Up   Status = "up"
+	Down Status = "down"
+)
+
+// Check is the named probe of the dependency.
+type Check struct {
+	Name string
+	// Run returns the details reported along with the status, e.g. the consumer lag, nil when there are none.
+	Run func(ctx context.Context) (interface{}, error)
+}
+
+// Ping returns the check which only reports whether fn succeeds.
+func Ping(name string, fn func(ctx context.Context) error) Check {
+	return Check{
+		Name: name,
+		Run: func(ctx context.Context) (interface{}, error) {
+			return nil, fn(ctx)
+		},
+	}
+}
+
+// Result is the outcome of the check.
+type Result struct {
+	Status    Status      `json:"status"`
+	LatencyMs float64     `json:"latency_ms"`
+	Details   interface{} `json:"details,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// Report is the outcome of all the checks, the status is down when any of them is down.
+type Report struct {
+	Status Status            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type Config struct {
+	// Timeout bounds each check, the check which has not finished in time is down.
+	Timeout time.Duration
+}
+
+// Checker runs the checks concurrently.
+type Checker struct {
+	timeout time.Duration
+	checks  []Check
+}
+
+func NewChecker(cfg Config) *Checker {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	return &Checker{timeout: cfg.Timeout}
+}
+
+// Add registers the checks, names must be unique.
+func (c *Checker) Add(checks ...Check) {
+	c.checks = append(c.checks, checks...)
+}
+
+// Check runs all the checks at once and waits for them, each one is bounded by the timeout.
+func (c *Checker) Check(ctx context.Context) Report {
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i := range c.checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = c.run(ctx, c.checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	report := Report{Status: Up, Checks: make(map[string]Result, len(c.checks))}
+	for i, check := range c.checks {
+		if results[i].Status == Down {
+			report.Status = Down
+		}
+		report.Checks[check.Name] = results[i]
+	}
+	return report
+}
+
+// run runs the check, the check which is stuck is abandoned once the timeout passes.
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	type outcome struct {
+		details interface{}
+		err     error
+	}
+	done := make(chan outcome, 1)
+	startedAt := time.Now()
+	go func() {
+		details, err := check.Run(ctx)
+		done <- outcome{details: details, err: err}
+	}()
+	var res outcome
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res.err = fmt.Errorf("check is not finished in %s: %w", c.timeout, ctx.Err())
+	}
+
+	result := Result{
+		Status:    Up,
+		LatencyMs: float64(time.Since(startedAt).Microseconds()) / 1000,
+		Details:   res.details,
+	}
+	if res.err != nil {
+		result.Status = Down
+		result.Error = res.err.Error()
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"zombie_locator/internal/utils/health"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker_Check(t *testing.T) {
+	checker := health.NewChecker(health.Config{Timeout: 20 * time.Millisecond})
+	checker.Add(
+		health.Ping("postgres", func(ctx context.Context) error { return nil }),
+		health.Check{Name: "kafka_lag", Run: func(ctx context.Context) (interface{}, error) {
+			return map[string]int64{"zombie_locations": 3}, nil
+		}},
+	)
+
+	report := checker.Check(context.Background())
+	require.Equal(t, health.Up, report.Status)
+	require.Len(t, report.Checks, 2)
+	require.Equal(t, health.Up, report.Checks["postgres"].Status)
+	require.Empty(t, report.Checks["postgres"].Error)
+	require.Equal(t, map[string]int64{"zombie_locations": 3}, report.Checks["kafka_lag"].Details)
+
+	checker.Add(health.Ping("tile38", func(ctx context.Context) error { return errors.New("connection refused") }))
+	report = checker.Check(context.Background())
+	require.Equal(t, health.Down, report.Status)
+	require.Equal(t, health.Result{Status: health.Down, LatencyMs: report.Checks["tile38"].LatencyMs, Error: "connection refused"},
+		report.Checks["tile38"])
+	require.Equal(t, health.Up, report.Checks["postgres"].Status)
+}
+
+func TestChecker_Timeout(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+	checker := health.NewChecker(health.Config{Timeout: 20 * time.Millisecond})
+	checker.Add(health.Ping("kafka", func(ctx context.Context) error {
+		// the check ignoring ctx is abandoned as well
+		<-stuck
+		return nil
+	}))
+
+	startedAt := time.Now()
+	report := checker.Check(context.Background())
+	require.Less(t, time.Since(startedAt), time.Second)
+	require.Equal(t, health.Down, report.Status)
+	require.Contains(t, report.Checks["kafka"].Error, "context deadline exceeded")
+	require.GreaterOrEqual(t, report.Checks["kafka"].LatencyMs, float64(20))
+}
+
+func TestFreshness(t *testing.T) {
+	now := time.Now()
+	newest := func(at time.Time) func(ctx context.Context) (time.Time, error) {
+		return func(ctx context.Context) (time.Time, error) { return at, nil }
+	}
+	lag := func(n int64) func(ctx context.Context) (int64, error) {
+		return func(ctx context.Context) (int64, error) { return n, nil }
+	}
+
+	for name, tc := range map[string]struct {
+		check health.Check
+		stale bool
+	}{
+		"fresh":                   {check: health.Freshness("freshness", time.Minute, newest(now), lag(10))},
+		"quiet":                   {check: health.Freshness("freshness", time.Minute, newest(now.Add(-time.Hour)), lag(0))},
+		"stale":                   {check: health.Freshness("freshness", time.Minute, newest(now.Add(-time.Hour)), lag(10)), stale: true},
+		"nothing stored":          {check: health.Freshness("freshness", time.Minute, newest(time.Time{}), lag(10))},
+		"lag is not known":        {check: health.Freshness("freshness", time.Minute, newest(now.Add(-time.Hour)), nil)},
+		"newest update is failed": {check: health.Freshness("freshness", time.Minute, func(ctx context.Context) (time.Time, error) { return time.Time{}, errors.New("postgres is down") }, lag(0)), stale: true},
+		"lag is failed":           {check: health.Freshness("freshness", time.Minute, newest(now), func(ctx context.Context) (int64, error) { return 0, errors.New("kafka is down") }), stale: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			checker := health.NewChecker(health.Config{})
+			checker.Add(tc.check)
+			report := checker.Check(context.Background())
+			if tc.stale {
+				require.Equal(t, health.Down, report.Status, report.Checks["freshness"].Error)
+			} else {
+				require.Equal(t, health.Up, report.Status, report.Checks["freshness"].Error)
+			}
+		})
+	}
+
+	checker := health.NewChecker(health.Config{})
+	checker.Add(health.Freshness("freshness", time.Minute, newest(now.Add(-time.Hour)), lag(10)))
+	details := checker.Check(context.Background()).Checks["freshness"].Details.(health.FreshnessDetails)
+	require.True(t, now.Add(-time.Hour).Equal(*details.NewestUpdate))
+	require.InDelta(t, time.Hour.Seconds(), details.AgeSeconds, 5)
+	require.Equal(t, int64(10), *details.Lag)
+}
@@ -0,0 +1,49 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// FreshnessDetails is reported by the freshness check.
+type FreshnessDetails struct {
+	// NewestUpdate is the time of the newest stored event, nil when nothing is stored yet.
+	NewestUpdate *time.Time `json:"newest_update,omitempty"`
+	AgeSeconds   float64    `json:"age_seconds"`
+	// Lag is the number of events waiting to be stored, nil when it is not known.
+	Lag *int64 `json:"lag,omitempty"`
+}
+
+// Freshness returns the check which fails when the newest stored event is older than maxAge while
+// the consumers lag behind, so the stored data misses the events which have already happened.
+// The quiet period without events is not stale. lag is optional, without it the age is reported only.
+func Freshness(name string, maxAge time.Duration, newest func(ctx context.Context) (time.Time, error),
+	lag func(ctx context.Context) (int64, error)) Check {
+	return Check{
+		Name: name,
+		Run: func(ctx context.Context) (interface{}, error) {
+			newestUpdate, err := newest(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("unable to get the newest update: %w", err)
+			}
+			details := FreshnessDetails{}
+			if !newestUpdate.IsZero() {
+				details.NewestUpdate = &newestUpdate
+				details.AgeSeconds = time.Since(newestUpdate).Seconds()
+			}
+			if lag == nil {
+				return details, nil
+			}
+			behind, err := lag(ctx)
+			if err != nil {
+				return details, fmt.Errorf("unable to get the consumer lag: %w", err)
+			}
+			details.Lag = &behind
+			if behind > 0 && !newestUpdate.IsZero() && time.Since(newestUpdate) > maxAge {
+				return details, fmt.Errorf("data is stale: newest update is older than %s while %d events are waiting", maxAge, behind)
+			}
+			return details, nil
+		},
+	}
+}
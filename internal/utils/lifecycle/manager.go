@@ -0,0 +1,260 @@
+// Package lifecycle runs the long living components of the process. Components are started in the order
+// they were added and stopped in the reverse one, a failed component is restarted by its policy or
+// stops the whole process.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"zombie_locator/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultRestartBackoff    = time.Second
+	defaultRestartMaxBackoff = 30 * time.Second
+	defaultRestartResetAfter = time.Minute
+)
+
+var (
+	// Exited is the failure of the component which Run returned while it was not stopped.
+	Exited = errors.New("component exited")
+	// ShutdownDeadline is returned when the components are not stopped within the shutdown timeout.
+	ShutdownDeadline = errors.New("shutdown deadline exceeded")
+)
+
+// Config defines the lifecycle manager settings.
+type Config struct {
+	// ShutdownTimeout bounds the time all the components have to stop.
+	ShutdownTimeout time.Duration
+}
+
+// Component is the part of the process supervised by the Manager.
+type Component struct {
+	Name string
+	// Start prepares the component, e.g. binds the port, before the next component is started. Optional.
+	Start func(ctx context.Context) error
+	// Run does the work until ctx is done. Returning before that, even without error, is the failure.
+	Run func(ctx context.Context) error
+	// Stop releases the component once its Run has returned. Optional.
+	Stop func() error
+	// Restart is applied when Run fails.
+	Restart RestartPolicy
+}
+
+// RestartPolicy defines how the failed component is restarted.
+type RestartPolicy struct {
+	// MaxRestarts is the number of restarts in a row, zero means the failure stops the process
+	// and the negative value restarts the component forever.
+	MaxRestarts int
+	// Backoff is the delay before the first restart, it doubles on each next one up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// ResetAfter is the run time after which the component is considered recovered and its restarts start over.
+	ResetAfter time.Duration
+}
+
+// Manager starts, supervises and stops the components.
+type Manager struct {
+	log             logger.AppLogger
+	shutdownTimeout time.Duration
+	components      []Component
+}
+
+// NewManager sets up the lifecycle manager without components.
+func NewManager(log logger.AppLogger, cfg Config) *Manager {
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+	return &Manager{
+		log:             log.With(zap.String("service", "lifecycle")),
+		shutdownTimeout: cfg.ShutdownTimeout,
+	}
+}
+
+// Add registers the components, they are started in the order they are added.
+func (m *Manager) Add(components ...Component) {
+	m.components = append(m.components, components...)
+}
+
+// Run starts the components and supervises them until ctx is done or some component fails beyond
+// its restart policy, then stops all of them. It returns the failure which stopped the process,
+// nil when it was stopped by ctx and all the components stopped cleanly.
+func (m *Manager) Run(ctx context.Context) error {
+	failures := make(chan error, len(m.components))
+	started := make([]*running, 0, len(m.components))
+	var failErr error
+	for _, c := range m.components {
+		if c.Start != nil {
+			if err := c.Start(ctx); err != nil {
+				failErr = fmt.Errorf("failed to start %s: %w", c.Name, err)
+				break
+			}
+		}
+		started = append(started, m.launch(ctx, c, failures))
+		m.log.Info("component is started", zap.String("component", c.Name))
+	}
+
+	if failErr == nil {
+		select {
+		case <-ctx.Done():
+			m.log.Info("stopping components")
+		case failErr = <-failures:
+			m.log.Error("component failed, stopping components", failErr)
+		}
+	}
+	stopErr := m.stop(started)
+	if failErr != nil {
+		if stopErr != nil {
+			m.log.Error("failed to stop components", stopErr)
+		}
+		return failErr
+	}
+	return stopErr
+}
+
+// running is the started component.
+type running struct {
+	component Component
+	cancel    context.CancelFunc
+	// done is closed once the component is not running anymore, err is its last failure after it was stopped.
+	done chan struct{}
+	err  error
+}
+
+// launch runs the component in the background restarting it by its policy.
+// The final failure is sent to failures.
+func (m *Manager) launch(ctx context.Context, c Component, failures chan<- error) *running {
+	runCtx, cancel := context.WithCancel(ctx)
+	r := &running{component: c, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		r.err = m.supervise(runCtx, c, failures)
+	}()
+	return r
+}
+
+// supervise runs the component until ctx is done or the restarts are exhausted.
+// It returns the error of the run which was interrupted by ctx.
+func (m *Manager) supervise(ctx context.Context, c Component, failures chan<- error) error {
+	policy := c.Restart.withDefaults()
+	log := m.log.With(zap.String("component", c.Name))
+	restarts := 0
+	for {
+		runAt := time.Now()
+		err := c.Run(ctx)
+		if ctx.Err() != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		}
+		if err == nil {
+			err = Exited
+		}
+		if time.Since(runAt) >= policy.ResetAfter {
+			restarts = 0
+		}
+		if policy.MaxRestarts >= 0 && restarts >= policy.MaxRestarts {
+			failures <- fmt.Errorf("%s failed: %w", c.Name, err)
+			return nil
+		}
+		restarts++
+		delay := policy.delay(restarts)
+		log.Error("component failed, restarting", err, zap.Int("restart", restarts), zap.Duration("delay", delay))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// stop stops the started components in the reverse order, each one is stopped once the next one is.
+// Components left when the shutdown timeout passes are reported and abandoned.
+func (m *Manager) stop(started []*running) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.shutdownTimeout)
+	defer cancel()
+	var problems []string
+	deadline := false
+	for i := len(started) - 1; i >= 0; i-- {
+		r := started[i]
+		log := m.log.With(zap.String("component", r.component.Name))
+		r.cancel()
+		if ctx.Err() != nil {
+			// the rest is canceled, but nobody waits for it anymore
+			deadline = true
+			problems = append(problems, fmt.Sprintf("%s is abandoned", r.component.Name))
+			continue
+		}
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			deadline = true
+			problems = append(problems, fmt.Sprintf("%s is still running", r.component.Name))
+			continue
+		}
+		if r.err != nil {
+			log.Error("component stopped with error", r.err)
+			problems = append(problems, fmt.Sprintf("%s: %v", r.component.Name, r.err))
+		}
+		if r.component.Stop != nil {
+			stopped := make(chan error, 1)
+			go func() {
+				stopped <- r.component.Stop()
+			}()
+			select {
+			case err := <-stopped:
+				if err != nil {
+					log.Error("failed to stop component", err)
+					problems = append(problems, fmt.Sprintf("failed to stop %s: %v", r.component.Name, err))
+					continue
+				}
+			case <-ctx.Done():
+				deadline = true
+				problems = append(problems, fmt.Sprintf("%s is still stopping", r.component.Name))
+				continue
+			}
+		}
+		log.Info("component is stopped")
+	}
+	if deadline {
+		return fmt.Errorf("%w: %s", ShutdownDeadline, strings.Join(problems, "; "))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("failed to stop components: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (p RestartPolicy) withDefaults() RestartPolicy {
+	if p.Backoff <= 0 {
+		p.Backoff = defaultRestartBackoff
+	}
+	if p.MaxBackoff < p.Backoff {
+		p.MaxBackoff = defaultRestartMaxBackoff
+		if p.MaxBackoff < p.Backoff {
+			p.MaxBackoff = p.Backoff
+		}
+	}
+	if p.ResetAfter <= 0 {
+		p.ResetAfter = defaultRestartResetAfter
+	}
+	return p
+}
+
+// delay returns the backoff before the restart, it doubles on each restart up to MaxBackoff.
+func (p RestartPolicy) delay(restart int) time.Duration {
+	if restart > 32 {
+		return p.MaxBackoff
+	}
+	if d := p.Backoff << (restart - 1); d > 0 && d < p.MaxBackoff {
+		return d
+	}
+	return p.MaxBackoff
+}
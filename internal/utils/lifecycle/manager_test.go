@@ -0,0 +1,206 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zombie_locator/internal/logger"
+	"zombie_locator/internal/utils/lifecycle"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_StartsInOrderAndStopsInReverse(t *testing.T) {
+	events := &eventLog{}
+	manager := newManager(t, time.Second)
+	for _, name := range []string{"storage", "consumer", "http"} {
+		manager.Add(events.component(name))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runManager(ctx, manager)
+	require.Eventually(t, func() bool { return events.len() == 6 }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.Equal(t, []string{"start storage", "start consumer", "start http"}, events.filter("start "))
+	require.ElementsMatch(t, []string{"run storage", "run consumer", "run http"}, events.filter("run "))
+	require.Equal(t, []string{"stop http", "stop consumer", "stop storage"}, events.filter("stop "))
+}
+
+func TestManager_FailureStopsEveryComponent(t *testing.T) {
+	events := &eventLog{}
+	manager := newManager(t, time.Second)
+	manager.Add(events.component("storage"))
+	failing := events.component("consumer")
+	failing.Run = func(ctx context.Context) error {
+		return errors.New("kafka is down")
+	}
+	manager.Add(failing, events.component("http"))
+
+	err := <-runManager(context.Background(), manager)
+	require.EqualError(t, err, "consumer failed: kafka is down")
+	require.Equal(t, []string{"stop http", "stop consumer", "stop storage"}, events.filter("stop "))
+}
+
+func TestManager_ExitedComponentIsFailure(t *testing.T) {
+	manager := newManager(t, time.Second)
+	manager.Add(lifecycle.Component{
+		Name: "consumer",
+		Run:  func(ctx context.Context) error { return nil },
+	})
+	err := <-runManager(context.Background(), manager)
+	require.ErrorIs(t, err, lifecycle.Exited)
+}
+
+func TestManager_RestartsByPolicy(t *testing.T) {
+	var runs atomic.Int32
+	manager := newManager(t, time.Second)
+	manager.Add(lifecycle.Component{
+		Name: "consumer",
+		Run: func(ctx context.Context) error {
+			if runs.Add(1) <= 2 {
+				return errors.New("kafka is down")
+			}
+			<-ctx.Done()
+			return nil
+		},
+		Restart: lifecycle.RestartPolicy{MaxRestarts: 2, Backoff: time.Millisecond},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runManager(ctx, manager)
+	require.Eventually(t, func() bool { return runs.Load() == 3 }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestManager_RestartsAreExhausted(t *testing.T) {
+	var runs atomic.Int32
+	manager := newManager(t, time.Second)
+	manager.Add(lifecycle.Component{
+		Name: "consumer",
+		Run: func(ctx context.Context) error {
+			return fmt.Errorf("run %d failed", runs.Add(1))
+		},
+		Restart: lifecycle.RestartPolicy{MaxRestarts: 2, Backoff: time.Millisecond},
+	})
+
+	err := <-runManager(context.Background(), manager)
+	require.EqualError(t, err, "consumer failed: run 3 failed")
+	require.Equal(t, int32(3), runs.Load())
+}
+
+func TestManager_StartFailure(t *testing.T) {
+	events := &eventLog{}
+	manager := newManager(t, time.Second)
+	failing := events.component("http")
+	failing.Start = func(ctx context.Context) error {
+		return errors.New("address already in use")
+	}
+	manager.Add(events.component("consumer"), failing, events.component("breaker"))
+
+	err := <-runManager(context.Background(), manager)
+	require.EqualError(t, err, "failed to start http: address already in use")
+	// components after the failed one are never started, the started ones are stopped
+	require.Equal(t, []string{"start consumer", "stop consumer"}, events.filter("start ", "stop "))
+}
+
+func TestManager_ShutdownDeadline(t *testing.T) {
+	events := &eventLog{}
+	manager := newManager(t, 20*time.Millisecond)
+	manager.Add(events.component("storage"))
+	stuck := make(chan struct{})
+	defer close(stuck)
+	manager.Add(lifecycle.Component{
+		Name: "consumer",
+		Run: func(ctx context.Context) error {
+			<-stuck
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runManager(ctx, manager)
+	require.Eventually(t, func() bool { return events.len() == 2 }, time.Second, time.Millisecond)
+	cancel()
+	err := <-done
+	require.ErrorIs(t, err, lifecycle.ShutdownDeadline)
+	require.Contains(t, err.Error(), "consumer is still running")
+	require.Empty(t, events.filter("stop "))
+}
+
+func newManager(t *testing.T, shutdownTimeout time.Duration) *lifecycle.Manager {
+	appLog, err := logger.NewAppLogger()
+	require.NoError(t, err)
+	return lifecycle.NewManager(appLog, lifecycle.Config{ShutdownTimeout: shutdownTimeout})
+}
+
+func runManager(ctx context.Context, manager *lifecycle.Manager) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- manager.Run(ctx)
+	}()
+	return done
+}
+
+// eventLog records the lifecycle calls of the components.
+type eventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+// component returns the component which runs until it is stopped.
+func (e *eventLog) component(name string) lifecycle.Component {
+	return lifecycle.Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			e.add("start " + name)
+			return nil
+		},
+		Run: func(ctx context.Context) error {
+			e.add("run " + name)
+			<-ctx.Done()
+			return nil
+		},
+		Stop: func() error {
+			e.add("stop " + name)
+			return nil
+		},
+	}
+}
+
+func (e *eventLog) add(event string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, event)
+}
+
+func (e *eventLog) len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.events)
+}
+
+func (e *eventLog) list() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.events...)
+}
+
+// filter returns the events with the given prefixes in the order they happened.
+func (e *eventLog) filter(prefixes ...string) []string {
+	var res []string
+	for _, event := range e.list() {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(event, prefix) {
+				res = append(res, event)
+			}
+		}
+	}
+	return res
+}
@@ -1,12 +1,16 @@
 package shema_registry
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"zombie_locator/internal/entities"
 )
 
+// defaultRawVersion is the version of the raw payload without schema version header.
+const defaultRawVersion = 1
+
 type entityStreamEvent struct {
 	Version int    `json:"v"`
 	Data    string `json:"d"`
@@ -45,23 +49,57 @@ func (r *Registry) encodeEvent(version int, payload interface{}, caster caster)
 	return json.Marshal(result)
 }
 
-func (r *Registry) decodeEvent(message []byte, deCaster deCaster) (map[int]interface{}, error) {
-	var result entityStreamEvent
-	if err := json.Unmarshal(message, &result); err != nil {
+// decodeEvent accepts the raw payload, the envelope with base64 encoded payload and the envelope with inline json payload.
+// Version of the raw payload is taken from headerVersion, zero means the first version.
+func (r *Registry) decodeEvent(message []byte, headerVersion int, deCaster deCaster) (map[int]interface{}, error) {
+	version, data, err := r.unwrap(message, headerVersion)
+	if err != nil {
 		return nil, err
 	}
-	if _, ok := r.supportedUserVersions[result.Version]; !ok {
+	if _, ok := r.supportedUserVersions[version]; !ok {
 		return nil, UnsupportedEventVersion
 	}
-	decoded, err := r.decode(result.Data)
+	payload, err := deCaster(version, data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode event: %w", err)
+		return nil, err
+	}
+	return map[int]interface{}{version: payload}, nil
+}
+
+// unwrap detects the message format and returns the version with json payload.
+func (r *Registry) unwrap(message []byte, headerVersion int) (int, []byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(message, &fields); err != nil {
+		return 0, nil, err
 	}
-	payload, err := deCaster(result.Version, decoded)
+	rawVersion, hasVersion := fields["v"]
+	rawData, hasData := fields["d"]
+	if !hasVersion || !hasData {
+		// raw payload as it is documented for producers
+		if headerVersion == 0 {
+			headerVersion = defaultRawVersion
+		}
+		return headerVersion, message, nil
+	}
+
+	var version int
+	if err := json.Unmarshal(rawVersion, &version); err != nil {
+		return 0, nil, fmt.Errorf("failed to decode event version: %w", err)
+	}
+	rawData = bytes.TrimSpace(rawData)
+	if len(rawData) > 0 && rawData[0] == '{' {
+		// envelope with inline json payload
+		return version, rawData, nil
+	}
+	var encoded string
+	if err := json.Unmarshal(rawData, &encoded); err != nil {
+		return 0, nil, fmt.Errorf("failed to decode event: %w", err)
+	}
+	decoded, err := r.decode(encoded)
 	if err != nil {
-		return nil, err
+		return 0, nil, fmt.Errorf("failed to decode event: %w", err)
 	}
-	return map[int]interface{}{result.Version: payload}, nil
+	return version, decoded, nil
 }
 
 func (r *Registry) EncodeZombieLocationStreamEvent(version int, payload interface{}) ([]byte, error) {
@@ -79,8 +117,8 @@ func (r *Registry) EncodeZombieLocationStreamEvent(version int, payload interfac
 	})
 }
 
-func (r *Registry) DecodeZombieLocationStreamEvent(message []byte) (map[int]interface{}, error) {
-	return r.decodeEvent(message, func(v int, data []byte) (any, error) {
+func (r *Registry) DecodeZombieLocationStreamEvent(message []byte, headerVersion int) (map[int]interface{}, error) {
+	return r.decodeEvent(message, headerVersion, func(v int, data []byte) (any, error) {
 		switch v {
 		case 1:
 			var location entities.ZombieLocationV1
@@ -109,8 +147,8 @@ func (r *Registry) EncodeZombieCapturedStreamEvent(version int, payload interfac
 	})
 }
 
-func (r *Registry) DecodeZombieCapturedStreamEvent(message []byte) (map[int]interface{}, error) {
-	return r.decodeEvent(message, func(v int, data []byte) (any, error) {
+func (r *Registry) DecodeZombieCapturedStreamEvent(message []byte, headerVersion int) (map[int]interface{}, error) {
+	return r.decodeEvent(message, headerVersion, func(v int, data []byte) (any, error) {
 		switch v {
 		case 1:
 			var usr entities.ZombieCapturedV1
@@ -1,6 +1,8 @@
 package shema_registry_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 	"zombie_locator/internal/entities"
@@ -18,7 +20,7 @@ func TestRegistry_Encode_DecodeZombieCapturedStreamEvent(t *testing.T) {
 	}
 	data, err := registry.EncodeZombieCapturedStreamEvent(1, payload)
 	require.NoError(t, err)
-	res, err := registry.DecodeZombieCapturedStreamEvent(data)
+	res, err := registry.DecodeZombieCapturedStreamEvent(data, 0)
 	require.NoError(t, err)
 	require.Equal(t, 1, len(res))
 	decodedPayload, ok := res[1].(*entities.ZombieCapturedV1)
@@ -37,7 +39,7 @@ func TestRegistry_Encode_DecodeZombieLocationStreamEvent(t *testing.T) {
 		}
 		data, err := registry.EncodeZombieLocationStreamEvent(1, payload)
 		require.NoError(t, err)
-		res, err := registry.DecodeZombieLocationStreamEvent(data)
+		res, err := registry.DecodeZombieLocationStreamEvent(data, 0)
 		require.NoError(t, err)
 		require.Equal(t, 1, len(res))
 		decodedPayload, ok := res[1].(*entities.ZombieLocationV1)
@@ -54,7 +56,7 @@ func TestRegistry_Encode_DecodeZombieLocationStreamEvent(t *testing.T) {
 		}
 		data, err := registry.EncodeZombieLocationStreamEvent(2, payload)
 		require.NoError(t, err)
-		res, err := registry.DecodeZombieLocationStreamEvent(data)
+		res, err := registry.DecodeZombieLocationStreamEvent(data, 0)
 		require.NoError(t, err)
 		require.Equal(t, 1, len(res))
 		decodedPayload, ok := res[2].(*entities.ZombieLocationV2)
@@ -62,3 +64,79 @@ func TestRegistry_Encode_DecodeZombieLocationStreamEvent(t *testing.T) {
 		require.Equal(t, payload, *decodedPayload)
 	})
 }
+
+func TestRegistry_DecodeZombieLocationStreamEventFormats(t *testing.T) {
+	registry := shema_registry.NewRegistry([]int{1, 2})
+	expectedV1 := entities.ZombieLocationV1{
+		ZombieID:  uuid.MustParse("47bf590c-b593-412a-a2a1-68d051cd220c"),
+		Latitude:  48.85905,
+		Longitude: 2.294533,
+		UpdatedAt: "2022-01-01T22:33:44.55Z",
+	}
+	table := []struct {
+		name          string
+		fixture       string
+		headerVersion int
+		expected      any
+		expectedErr   error
+	}{
+		{name: "raw payload", fixture: "location_raw.json", expected: &expectedV1},
+		{name: "raw payload with header version", fixture: "location_raw.json", headerVersion: 1, expected: &expectedV1},
+		{name: "base64 envelope", fixture: "location_envelope_base64.json", expected: &expectedV1},
+		{name: "inline envelope", fixture: "location_envelope_inline.json", expected: &expectedV1},
+		{name: "envelope ignores header version", fixture: "location_envelope_inline.json", headerVersion: 2, expected: &expectedV1},
+		{
+			name:          "raw payload v2",
+			fixture:       "location_raw_v2.json",
+			headerVersion: 2,
+			expected: &entities.ZombieLocationV2{
+				ZombieID:  expectedV1.ZombieID,
+				Type:      "witch",
+				Latitude:  expectedV1.Latitude,
+				Longitude: expectedV1.Longitude,
+				UpdatedAt: expectedV1.UpdatedAt,
+			},
+		},
+		{name: "raw payload unsupported header version", fixture: "location_raw.json", headerVersion: 3, expectedErr: shema_registry.UnsupportedEventVersion},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := registry.DecodeZombieLocationStreamEvent(readFixture(t, tc.fixture), tc.headerVersion)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, 1, len(res))
+			for _, payload := range res {
+				require.Equal(t, tc.expected, payload)
+			}
+		})
+	}
+}
+
+func TestRegistry_DecodeZombieCapturedStreamEventFormats(t *testing.T) {
+	registry := shema_registry.NewRegistry([]int{1})
+	expected := &entities.ZombieCapturedV1{
+		ZombieID:  uuid.MustParse("84a526b3-4302-44ad-8fe6-4b8ce45d6980"),
+		UpdatedAt: "2022-01-01T22:33:44.66Z",
+	}
+	for _, fixture := range []string{"captured_raw.json", "captured_envelope_base64.json", "captured_envelope_inline.json"} {
+		t.Run(fixture, func(t *testing.T) {
+			res, err := registry.DecodeZombieCapturedStreamEvent(readFixture(t, fixture), 0)
+			require.NoError(t, err)
+			require.Equal(t, map[int]interface{}{1: expected}, res)
+		})
+	}
+
+	t.Run("broken payload", func(t *testing.T) {
+		_, err := registry.DecodeZombieCapturedStreamEvent([]byte("not a json"), 0)
+		require.Error(t, err)
+	})
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return data
+}
@@ -7,14 +7,19 @@ var (
 	UnsupportedEventVersion = errors.New("unsupported version event")
 )
 
+// HeaderSchemaVersion is the kafka header with the schema version of the raw payload.
+const HeaderSchemaVersion = "schema-version"
+
 // simply encode|decode to base 64. update to usage protobuf.
 type caster func(v int, data any) (any, bool)
 type deCaster func(v int, data []byte) (any, error)
 
 type SchemaRegistry interface {
 	EncodeZombieLocationStreamEvent(version int, payload interface{}) ([]byte, error)
-	DecodeZombieLocationStreamEvent(message []byte) (map[int]interface{}, error)
+	// DecodeZombieLocationStreamEvent decodes the raw payload or the envelope. headerVersion is the version
+	// of the raw payload taken from the message header, zero if it is unknown.
+	DecodeZombieLocationStreamEvent(message []byte, headerVersion int) (map[int]interface{}, error)
 
 	EncodeZombieCapturedStreamEvent(version int, payload interface{}) ([]byte, error)
-	DecodeZombieCapturedStreamEvent(message []byte) (map[int]interface{}, error)
+	DecodeZombieCapturedStreamEvent(message []byte, headerVersion int) (map[int]interface{}, error)
 }